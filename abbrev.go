@@ -0,0 +1,57 @@
+package repl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WithAbbreviation defines an abbreviation, as in the fish shell: when word
+// is typed on its own and followed by a space, or the line is entered with
+// it last, it is replaced in the line by expansion, for the user to see and
+// change. History keeps the line as it was entered, with the expansion.
+// Abbreviations can also be defined with the :abbr command.
+func WithAbbreviation(word string, expansion string) Option {
+	return func(s *session) {
+		if s.abbreviations == nil {
+			s.abbreviations = make(map[string]string)
+		}
+		s.abbreviations[word] = expansion
+	}
+}
+
+// expandAbbreviation replaces the word before the cursor with its
+// expansion, if it is an abbreviation, and reports whether it was.
+func (s *session) expandAbbreviation() bool {
+	buf := s.buf
+	if len(s.abbreviations) == 0 || s.heredocEnd != "" || buf.cursor < buf.length && buf.at(buf.cursor) != SPACE {
+		return false
+	}
+	start := buf.cursor
+	for start > 0 && buf.at(start-1) != SPACE {
+		start--
+	}
+	expansion, ok := s.abbreviations[string(buf.buf[start:buf.cursor])]
+	if !ok {
+		return false
+	}
+	//the word is just before the cursor, so dropping it leaves the rest alone
+	buf.length -= buf.cursor - start
+	buf.cursor = start
+	buf.InsertString(expansion)
+	return true
+}
+
+// listAbbreviations returns a line for each abbreviation, showing its
+// expansion.
+func (s *session) listAbbreviations() []string {
+	words := make([]string, 0, len(s.abbreviations))
+	for word := range s.abbreviations {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	lines := make([]string, 0, len(words))
+	for _, word := range words {
+		lines = append(lines, fmt.Sprintf("%s = %s", word, s.abbreviations[word]))
+	}
+	return lines
+}
@@ -0,0 +1,97 @@
+package repl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AliasDisplay says how the session lets the user see what an alias expands
+// to.
+type AliasDisplay int
+
+const (
+	// AliasHidden expands aliases just before the line is evaluated, without
+	// showing the expansion.
+	AliasHidden AliasDisplay = iota
+	// AliasShow prints the expanded line before evaluating it.
+	AliasShow
+	// AliasInPlace expands an alias in the line being edited as soon as a
+	// space is typed after it, so the line can be checked, and changed,
+	// before it is entered.
+	AliasInPlace
+)
+
+// WithAlias defines an alias: a line whose first word is name has it
+// replaced by expansion before it is evaluated. History keeps the line as it
+// was typed. Aliases can also be defined with the :alias command, and
+// removed with :unalias.
+func WithAlias(name string, expansion string) Option {
+	return func(s *session) {
+		if s.aliases == nil {
+			s.aliases = make(map[string]string)
+		}
+		s.aliases[name] = expansion
+	}
+}
+
+// WithAliasDisplay sets how aliases are shown being expanded. The default is
+// AliasHidden.
+func WithAliasDisplay(d AliasDisplay) Option {
+	return func(s *session) {
+		s.aliasDisplay = d
+	}
+}
+
+// expandAlias returns line with its first word replaced, if it is an alias,
+// first printing the expanded line, if aliases are shown.
+func (s *session) expandAlias(line string) string {
+	if len(s.aliases) == 0 || len(s.partial) > 0 {
+		return line
+	}
+	trimmed := strings.TrimLeft(line, " ")
+	name, rest := trimmed, ""
+	if i := strings.IndexByte(trimmed, ' '); i >= 0 {
+		name, rest = trimmed[:i], trimmed[i:]
+	}
+	expansion, ok := s.aliases[name]
+	if !ok {
+		return line
+	}
+	expanded := expansion + rest
+	if s.aliasDisplay == AliasShow {
+		s.putString(s.style(s.theme.Hint) + expanded + s.resetStyle() + "\n")
+	}
+	return expanded
+}
+
+// expandAliasInPlace replaces the word before the cursor with its expansion,
+// if it is an alias at the start of the line, and reports whether it was.
+func (s *session) expandAliasInPlace() bool {
+	buf := s.buf
+	if s.aliasDisplay != AliasInPlace || len(s.aliases) == 0 || len(s.partial) > 0 || s.heredocEnd != "" || buf.cursor != buf.length {
+		return false
+	}
+	line := buf.String()
+	expansion, ok := s.aliases[strings.TrimLeft(line, " ")]
+	if !ok {
+		return false
+	}
+	buf.Clear()
+	buf.InsertString(line[:len(line)-len(strings.TrimLeft(line, " "))] + expansion)
+	return true
+}
+
+// listAliases returns a line for each alias, showing its expansion.
+func (s *session) listAliases() []string {
+	names := make([]string, 0, len(s.aliases))
+	for name := range s.aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s = %s", name, s.aliases[name]))
+	}
+	return lines
+}
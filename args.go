@@ -0,0 +1,91 @@
+package repl
+
+import "strings"
+
+// lastArgState is where yank-last-arg has got to, between presses of M-.
+type lastArgState struct {
+	back     int //how far back in history the argument inserted came from
+	inserted int //how long it was
+}
+
+// yankLastArg inserts the last word of the previous history entry at the
+// cursor, as bash does. Pressed again, it replaces it with the last word of
+// the entry before that, and so on back through the history.
+func (s *session) yankLastArg(ch byte) {
+	buf := s.buf
+	a := &s.lastArg
+	back := 1
+	if s.lastCommand == "yank-last-arg" {
+		back = a.back + 1
+	}
+	for {
+		line, ok := buf.historyEntry(back)
+		if !ok {
+			s.bell()
+			return
+		}
+		if words := strings.Fields(line); len(words) > 0 {
+			if s.lastCommand == "yank-last-arg" {
+				//take out the previous one, which ends at the cursor
+				buf.cursor -= a.inserted
+				buf.length -= a.inserted
+			}
+			cursor := buf.cursor
+			buf.InsertString(words[len(words)-1])
+			*a = lastArgState{back: back, inserted: buf.cursor - cursor}
+			s.drawline()
+			return
+		}
+		back++
+	}
+}
+
+// maxArg is the largest numeric argument the digit keys build up.
+const maxArg = 9999
+
+// repeatable are the commands a numeric argument repeats, as many times as
+// it says. Other commands take it to mean what they like, or ignore it.
+var repeatable = map[string]bool{
+	"backward-char":        true,
+	"backward-delete-char": true,
+	"backward-kill-word":   true,
+	"backward-word":        true,
+	"forward-char":         true,
+	"forward-word":         true,
+	"kill-word":            true,
+	"self-insert":          true,
+}
+
+// digitArgument adds the digit typed, with M-0 to M-9, to the numeric
+// argument for the next command.
+func (s *session) digitArgument(ch byte) {
+	if ch < '0' || ch > '9' {
+		s.bell()
+		return
+	}
+	if !s.hasArg {
+		s.hasArg, s.arg = true, 0
+	}
+	s.arg = s.arg*10 + int(ch-'0')
+	if s.arg > maxArg {
+		s.arg = maxArg
+	}
+}
+
+// yankNthArg inserts a word of the previous history entry at the cursor:
+// the one the numeric argument says, counting from 0 for the first, or the
+// second, the first argument of a command, if there is no numeric argument.
+func (s *session) yankNthArg(ch byte) {
+	n := 1
+	if s.hasArg {
+		n = s.arg
+	}
+	line, _ := s.buf.historyEntry(1)
+	words := strings.Fields(line)
+	if n >= len(words) {
+		s.bell()
+		return
+	}
+	s.buf.InsertString(words[n])
+	s.drawline()
+}
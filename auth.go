@@ -0,0 +1,105 @@
+package repl
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Credentials are what a network client offers to prove who it is.
+type Credentials struct {
+	RemoteAddr string //the client's network address
+	Token      string //a bearer token, from a WebSocket client's request
+	Password   string //the password typed at the session's password prompt
+
+	// Certificates is the verified chain of the certificate a TLS client
+	// presented, starting with the client's own.
+	Certificates []*x509.Certificate
+}
+
+// maxAuthAttempts is how many passwords a client may try before it is
+// disconnected.
+const maxAuthAttempts = 3
+
+// WithAuthentication requires network clients to be accepted by check before
+// a handler is created for them. A WebSocket client may present a token, in
+// an "Authorization: Bearer" header or a "token" query parameter, and a TLS
+// client a certificate; otherwise the client is asked for a password, which
+// is not echoed in character mode. Clients in line mode, such as netcat, echo
+// what they type themselves, so their passwords are visible on their own
+// screens. Sessions on the local terminal ignore this option.
+func WithAuthentication(check func(c Credentials) bool) Option {
+	return func(s *session) {
+		s.auth = check
+	}
+}
+
+// authenticate checks the client's credentials, asking for a password if it
+// has no token or certificate, and reports whether the client may go on. The
+// password accepted is filled in to c.
+func (s *session) authenticate(c *Credentials, charMode bool) bool {
+	if s.auth == nil {
+		return true
+	}
+	if c.Token != "" || len(c.Certificates) > 0 {
+		if s.auth(*c) {
+			return true
+		}
+		s.putString(s.messages.AuthFailed + "\n")
+		return false
+	}
+	for i := 0; i < maxAuthAttempts; i++ {
+		s.putString(s.messages.Password)
+		s.secret = true
+		password, ok := s.readLine(false)
+		s.secret = false
+		if !ok {
+			return false
+		}
+		if charMode {
+			s.putString("\n")
+		}
+		c.Password = password
+		if s.auth(*c) {
+			return true
+		}
+		s.putString(s.messages.AuthFailed + "\n")
+	}
+	return false
+}
+
+// owner returns what identifies the client that offered c, so that what it
+// leaves behind can be kept for it alone: a digest of the certificate, token
+// or password it was accepted with, or its host if it offered none, as it
+// doesn't when the server has no WithAuthentication.
+func (c Credentials) owner() string {
+	h := sha256.New()
+	switch {
+	case len(c.Certificates) > 0:
+		h.Write([]byte("certificate:"))
+		h.Write(c.Certificates[0].Raw)
+	case c.Token != "":
+		h.Write([]byte("token:" + c.Token))
+	case c.Password != "":
+		h.Write([]byte("password:" + c.Password))
+	default:
+		host, _, err := net.SplitHostPort(c.RemoteAddr)
+		if err != nil {
+			host = c.RemoteAddr
+		}
+		h.Write([]byte("host:" + host))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bearerToken returns the token a WebSocket client presented with its
+// request, if any.
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,121 @@
+package repl
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backgroundWait is how long a session waits, when it starts, for the
+// terminal to say what its background color is.
+const backgroundWait = 200 * time.Millisecond
+
+// WithAutoTheme has the session ask the terminal for its background color
+// when it starts, with OSC 11, and draw with DarkTheme or LightTheme to suit
+// it. A terminal that doesn't answer within a moment leaves the theme as it
+// was, and one that answers late has the theme changed then. REPL and Wrap
+// turn it on when both stdin and stdout are terminals and color is on;
+// WithTheme, or a theme in the configuration file, turns it off again.
+func WithAutoTheme(on bool) Option {
+	return func(s *session) {
+		s.autoTheme = on
+	}
+}
+
+// queryBackground asks the terminal for its background color and waits for
+// the answer, picking the theme from it, so that the first prompt is drawn
+// in the right colors. Keys typed while it waits are kept.
+func (s *session) queryBackground() {
+	s.putExtended("\033]11;?\a")
+	timeout := time.NewTimer(backgroundWait)
+	defer timeout.Stop()
+	for {
+		if start, end := backgroundReply(s.pending); start >= 0 {
+			s.setBackground(s.pending[start+len(oscBackground) : end])
+			rest := s.pending[end:]
+			if rest[0] == ESCAPE {
+				rest = rest[2:]
+			} else {
+				rest = rest[1:]
+			}
+			s.pending = append(s.pending[:start:start], rest...)
+			return
+		}
+		select {
+		case chunk := <-s.input:
+			s.pending = append(s.pending, chunk...)
+		case <-s.closing:
+			return
+		case <-timeout.C:
+			s.awaitOSCReply() //it may yet answer, later
+			return
+		}
+	}
+}
+
+// oscBackground starts the terminal's answer to the OSC 11 query.
+const oscBackground = "\033]11;"
+
+// backgroundReply finds the terminal's answer to the OSC 11 query in input,
+// which starts at start and has its terminator at end. start is -1 if input
+// doesn't hold all of an answer.
+func backgroundReply(input []byte) (start, end int) {
+	start = bytes.Index(input, []byte(oscBackground))
+	if start < 0 {
+		return -1, 0
+	}
+	for end = start + len(oscBackground); end < len(input); end++ {
+		if input[end] == BEEP || input[end] == ESCAPE && end+1 < len(input) && input[end+1] == '\\' {
+			return start, end
+		}
+	}
+	return -1, 0
+}
+
+// setBackground picks the theme for a terminal whose background color is
+// color, as the terminal gives it in its answer to OSC 11, such as
+// rgb:1e1e/1e1e/1e1e.
+func (s *session) setBackground(color []byte) {
+	if !s.autoTheme {
+		return
+	}
+	if light, ok := isLightColor(string(color)); ok {
+		s.theme = DarkTheme
+		if light {
+			s.theme = LightTheme
+		}
+	}
+}
+
+// isLightColor reports whether color, in the X11 form rgb:r/g/b with one to
+// four hex digits for each component, is closer to white than to black. A
+// fourth component, for the alpha of rgba:r/g/b/a, is ignored.
+func isLightColor(color string) (light bool, ok bool) {
+	var parts []string
+	switch {
+	case len(color) > 4 && color[:4] == "rgb:":
+		parts = strings.Split(color[4:], "/")
+	case len(color) > 5 && color[:5] == "rgba:":
+		parts = strings.Split(color[5:], "/")
+		if len(parts) == 4 {
+			parts = parts[:3]
+		}
+	}
+	if len(parts) != 3 {
+		return false, false
+	}
+	var rgb [3]float64
+	for n, part := range parts {
+		if len(part) == 0 || len(part) > 4 {
+			return false, false
+		}
+		v, err := strconv.ParseUint(part, 16, 16)
+		if err != nil {
+			return false, false
+		}
+		rgb[n] = float64(v) / float64(uint64(1)<<(4*len(part))-1)
+	}
+	luminance := 0.2126*rgb[0] + 0.7152*rgb[1] + 0.0722*rgb[2]
+	return luminance > 0.5, true
+}
@@ -0,0 +1,98 @@
+package repl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// WithBalanceIndicator turns on or off a marker at the right margin of the
+// line being edited that shows which brackets are still open, with how many
+// of each, and whether a string is, so that it is clear why entering the line
+// would continue it rather than evaluate it. It counts the earlier lines of
+// an unfinished entry too. It is off by default, and never shown in
+// accessible or low bandwidth mode.
+func WithBalanceIndicator(on bool) Option {
+	return func(s *session) {
+		s.balanceIndicator = on
+	}
+}
+
+// unbalanced returns the marker for what is left open in text: each kind of
+// bracket still open, followed by how many if more than one, and a double
+// quote if a string is. It is empty if everything is closed.
+func unbalanced(text string) string {
+	var open [3]int
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		if inString {
+			if escaped {
+				escaped = false
+			} else if ch == '\\' {
+				escaped = true
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+		if ch == '"' {
+			inString = true
+		} else if k := strings.IndexByte("([{", ch); k >= 0 {
+			open[k]++
+		} else if k := strings.IndexByte(")]}", ch); k >= 0 && open[k] > 0 {
+			open[k]--
+		}
+	}
+	var marks []string
+	for k, n := range open {
+		if n == 1 {
+			marks = append(marks, "([{"[k:k+1])
+		} else if n > 1 {
+			marks = append(marks, "([{"[k:k+1]+strconv.Itoa(n))
+		}
+	}
+	if inString {
+		marks = append(marks, `"`)
+	}
+	return strings.Join(marks, " ")
+}
+
+// drawBalance shows what is left open at the right margin of the line being
+// edited, if there is room beside it, and erases the marker once everything
+// is closed.
+func (s *session) drawBalance() {
+	if !s.balanceIndicator || s.accessible || s.lowBandwidth || s.cols <= 0 {
+		return
+	}
+	text := s.buf.String()
+	if len(s.partial) > 0 || len(s.pasted) > 0 {
+		text = strings.Join(append(append(append([]string{}, s.partial...), s.pasted...), text), "\n")
+	}
+	mark := unbalanced(text)
+	width := displayWidth(s.shown)
+	col := s.cols - len(mark) //leaving the last column free, so the terminal doesn't wrap
+	if width+2 > col {
+		mark = ""
+	}
+	if mark == "" {
+		if s.balanceCol > 0 && width < s.balanceCol-1 {
+			s.putString("\0337\033[" + strconv.Itoa(s.balanceCol) + "G\033[K\0338")
+		}
+		s.balanceCol = 0
+		return
+	}
+	from := col
+	if s.balanceCol > 0 && s.balanceCol < col && width < s.balanceCol-1 {
+		from = s.balanceCol //erase a longer marker
+	}
+	frame := append(s.frame[:0], "\0337\033["...)
+	frame = strconv.AppendInt(frame, int64(from), 10)
+	frame = append(frame, 'G', ESCAPE, '[', 'K', ESCAPE, '[')
+	frame = strconv.AppendInt(frame, int64(col), 10)
+	frame = append(frame, 'G')
+	frame = s.appendStyled(frame, s.theme.Hint, []byte(mark))
+	frame = append(frame, ESCAPE, '8')
+	s.frame = frame
+	s.putChars(frame)
+	s.balanceCol = col
+}
@@ -0,0 +1,197 @@
+package repl
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// BidiMode says how the session shows lines that have right-to-left text,
+// such as Hebrew or Arabic, in them. The line is always edited in logical
+// order, the order it was typed in, and the cursor moves through it in that
+// order; only what is drawn differs.
+type BidiMode int
+
+const (
+	// BidiReorder draws each run of right-to-left text reversed, as a
+	// terminal that knows nothing of bidirectional text needs it, with
+	// numbers in it left to right and brackets mirrored. This is a simple
+	// approximation of the Unicode bidirectional algorithm, for a line that
+	// is left to right overall.
+	BidiReorder BidiMode = iota
+	// BidiLogical draws the line in logical order, for terminals that
+	// reorder right-to-left text themselves.
+	BidiLogical
+)
+
+// WithBidi sets how lines with right-to-left text in them are drawn. The
+// default is BidiReorder.
+func WithBidi(m BidiMode) Option {
+	return func(s *session) {
+		s.bidi = m
+	}
+}
+
+// isRTL reports whether r is written right to left.
+func isRTL(r rune) bool {
+	switch {
+	case r >= 0x0590 && r <= 0x08ff, r >= 0xfb1d && r <= 0xfdff, r >= 0xfe70 && r <= 0xfeff:
+		return true
+	case r >= 0x10800 && r <= 0x10fff, r >= 0x1e800 && r <= 0x1efff:
+		return true
+	}
+	return false
+}
+
+// hasRTL reports whether b has any right-to-left text in it.
+func hasRTL(b []byte) bool {
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(b[i:])
+		if isRTL(r) {
+			return true
+		}
+		i += n
+	}
+	return false
+}
+
+// runeWidth returns how many columns r takes on the screen: none for a
+// combining mark, which goes over the character before it, two for the wide
+// characters of Chinese, Japanese and Korean, and emoji, and one for anything
+// else.
+func runeWidth(r rune) int {
+	if r < 0x300 {
+		return 1
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me) || r == 0x200b || r == 0x200c || r == 0x200d || r == 0xfeff {
+		return 0
+	}
+	if isWide(r) {
+		return 2
+	}
+	return 1
+}
+
+// isWide reports whether r is one of the East Asian wide or fullwidth
+// characters, or an emoji, which terminals draw two columns wide.
+func isWide(r rune) bool {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, r >= 0x2e80 && r <= 0x303e, r >= 0x3041 && r <= 0x33ff:
+		return true
+	case r >= 0x3400 && r <= 0x4dbf, r >= 0x4e00 && r <= 0x9fff, r >= 0xa000 && r <= 0xa4cf:
+		return true
+	case r >= 0xac00 && r <= 0xd7a3, r >= 0xf900 && r <= 0xfaff, r >= 0xfe30 && r <= 0xfe4f:
+		return true
+	case r >= 0xff00 && r <= 0xff60, r >= 0xffe0 && r <= 0xffe6:
+		return true
+	case r >= 0x1f300 && r <= 0x1f64f, r >= 0x1f900 && r <= 0x1f9ff, r >= 0x20000 && r <= 0x3fffd:
+		return true
+	}
+	return false
+}
+
+// displayWidth returns how many columns b takes on the screen.
+func displayWidth(b []byte) int {
+	w := 0
+	for i := 0; i < len(b); {
+		if b[i] < utf8.RuneSelf {
+			w++
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRune(b[i:])
+		w += runeWidth(r)
+		i += n
+	}
+	return w
+}
+
+// mirrored maps brackets to those facing the other way, which is how they
+// are drawn in right-to-left text.
+var mirrored = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{', '<': '>', '>': '<'}
+
+// visualOrder appends line to b in the order it is drawn by BidiReorder,
+// and returns where the character at position pos in line ends up. A run of
+// right-to-left text goes from one right-to-left character to the last one
+// before a left-to-right letter, taking in the spaces, punctuation and
+// digits between them and the brackets closing those opened in it, and is
+// reversed, except for the numbers in it.
+func visualOrder(b []byte, line []byte, pos int) ([]byte, int) {
+	type char struct {
+		r   rune
+		at  int
+		rtl bool
+	}
+	var chars []char
+	for i := 0; i < len(line); {
+		r, n := utf8.DecodeRune(line[i:])
+		chars = append(chars, char{r: r, at: i})
+		i += n
+	}
+	for i := 0; i < len(chars); i++ {
+		if !isRTL(chars[i].r) {
+			continue
+		}
+		last := i
+		for j := i + 1; j < len(chars); j++ {
+			r := chars[j].r
+			if isRTL(r) {
+				last = j
+			} else if unicode.IsLetter(r) {
+				break
+			}
+		}
+		open := 0
+		for j := i; j <= last; j++ {
+			switch chars[j].r {
+			case '(', '[', '{':
+				open++
+			case ')', ']', '}':
+				open--
+			}
+		}
+		for j := last + 1; j < len(chars) && open > 0 && !unicode.IsLetter(chars[j].r); j++ {
+			//brackets opened in the run are closed in it too
+			switch chars[j].r {
+			case ')', ']', '}':
+				open--
+				last = j
+			}
+		}
+		for j, k := i, last; j < k; j, k = j+1, k-1 {
+			chars[j], chars[k] = chars[k], chars[j]
+		}
+		for j := i; j <= last; j++ {
+			chars[j].rtl = true
+		}
+		for j := i; j <= last; j++ {
+			k := j
+			for k <= last && unicode.IsDigit(chars[k].r) {
+				k++
+			}
+			for a, z := j, k-1; a < z; a, z = a+1, z-1 {
+				chars[a], chars[z] = chars[z], chars[a]
+			}
+			if k > j {
+				j = k - 1
+			}
+		}
+		i = last
+	}
+	start := len(b)
+	visual := start + len(line)
+	for _, c := range chars {
+		if c.at == pos {
+			visual = len(b)
+		}
+		r := c.r
+		if m, ok := mirrored[r]; ok && c.rtl {
+			r = m
+		}
+		b = utf8.AppendRune(b, r)
+	}
+	return b, visual - start
+}
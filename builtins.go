@@ -0,0 +1,88 @@
+package repl
+
+import (
+	"strings"
+)
+
+// builtins are the commands a session carries out itself, rather than
+// passing them to the handler, when they are entered on a line of their own.
+var builtins = map[string]func(s *session, args string){
+	":abbr": func(s *session, args string) {
+		word, expansion := args, ""
+		if i := strings.IndexByte(args, ' '); i >= 0 {
+			word, expansion = args[:i], strings.TrimSpace(args[i+1:])
+		}
+		if expansion != "" {
+			WithAbbreviation(word, expansion)(s)
+			return
+		}
+		for _, line := range s.listAbbreviations() {
+			if word == "" || strings.HasPrefix(line, word+" =") {
+				s.putString(line)
+				s.putChar(NEWLINE)
+			}
+		}
+	},
+	":alias": func(s *session, args string) {
+		name, expansion := args, ""
+		if i := strings.IndexByte(args, ' '); i >= 0 {
+			name, expansion = args[:i], strings.TrimSpace(args[i+1:])
+		}
+		if expansion != "" {
+			WithAlias(name, expansion)(s)
+			return
+		}
+		for _, line := range s.listAliases() {
+			if name == "" || strings.HasPrefix(line, name+" =") {
+				s.putString(line)
+				s.putChar(NEWLINE)
+			}
+		}
+	},
+	":bindings": func(s *session, args string) {
+		for _, line := range s.describeBindings() {
+			s.putString(line)
+			s.putChar(NEWLINE)
+		}
+	},
+	":history": func(s *session, args string) {
+		s.historyCommand(args)
+	},
+	":r": func(s *session, args string) {
+		s.rerun(args)
+	},
+	":unalias": func(s *session, args string) {
+		delete(s.aliases, args)
+	},
+}
+
+// WithBuiltins turns the session's built-in commands, such as :bindings, on
+// or off. They are on by default; a handler whose language gives lines like
+// ":bindings" a meaning of their own should turn them off.
+func WithBuiltins(on bool) Option {
+	return func(s *session) {
+		s.noBuiltins = !on
+	}
+}
+
+// builtin carries out line if it is a built-in command, and reports whether
+// it was.
+func (s *session) builtin(line string) bool {
+	if s.noBuiltins {
+		return false
+	}
+	name, args := strings.TrimSpace(line), ""
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	fn, ok := builtins[name]
+	if !ok {
+		return false
+	}
+	fn(s, args)
+	s.showPrompt()
+	if !s.buf.IsEmpty() {
+		s.drawline() //what the command left in the line
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// maxOSC bounds the size of an OSC reply from the terminal.
+const maxOSC = 1 << 20
+
+// oscReplyWait is how long after asking the terminal a question with an OSC
+// sequence the session takes ESC ] as the start of the answer, rather than as
+// Alt-] typed by the user.
+const oscReplyWait = 5 * time.Second
+
+// clipboardTimeout is how long a clipboard utility is given to finish.
+const clipboardTimeout = 2 * time.Second
+
+// WithOSC52 keeps the system clipboard in step with the kill buffer, using
+// the OSC 52 escape sequence, which many terminal emulators support even over
+// SSH: text killed in the session can then be pasted into other programs.
+// C-x C-y asks the terminal for the contents of the clipboard and inserts
+// them, if the terminal allows programs to read it.
+func WithOSC52(on bool) Option {
+	return func(s *session) {
+		s.osc52 = on
+	}
+}
+
+// WithLocalClipboard lets the session use the clipboard utilities of the
+// machine it runs on: pbcopy and pbpaste on macOS, wl-copy and wl-paste under
+// Wayland, and xclip or xsel under X. Meta-W copies the line being edited to
+// the clipboard, Meta-R copies the last result, and C-x C-y inserts the
+// clipboard's contents. Where no utility is available the session falls back
+// to OSC 52, if that is enabled, and otherwise rings the bell.
+//
+// This only makes sense when the user is at the same machine, so REPL and
+// Wrap turn it on unless the program is running under SSH. Network sessions
+// have it off.
+func WithLocalClipboard(on bool) Option {
+	return func(s *session) {
+		s.localClipboard = on
+	}
+}
+
+// clipboardTool is a pair of commands that copy their input to the clipboard,
+// and print its contents.
+type clipboardTool struct {
+	copy  []string
+	paste []string
+}
+
+// findClipboardTool returns the first of the clipboard utilities suited to
+// the platform that is installed, or nil if there are none.
+func findClipboardTool() *clipboardTool {
+	var tools []clipboardTool
+	if runtime.GOOS == "darwin" {
+		tools = append(tools, clipboardTool{[]string{"pbcopy"}, []string{"pbpaste"}})
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, clipboardTool{[]string{"wl-copy"}, []string{"wl-paste", "-n"}})
+	}
+	if os.Getenv("DISPLAY") != "" {
+		tools = append(tools,
+			clipboardTool{[]string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+			clipboardTool{[]string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}})
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool.copy[0]); err == nil {
+			return &tool
+		}
+	}
+	return nil
+}
+
+// runClipboardTool runs command with input on its stdin, and returns what it
+// printed.
+func runClipboardTool(command []string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+		return nil, cmd.Run()
+	}
+	return cmd.Output()
+}
+
+// killed is called after text is killed into the kill buffer.
+func (s *session) killed() {
+	if s.osc52 && len(s.buf.yanked) > 0 {
+		s.copyOSC52(s.buf.yanked)
+	}
+}
+
+// copyOSC52 asks the terminal to put text on the clipboard.
+func (s *session) copyOSC52(text []byte) {
+	s.flush()
+	s.putExtended("\033]52;c;" + base64.StdEncoding.EncodeToString(text) + "\a")
+}
+
+// copyToClipboard puts text on the clipboard, with a local utility if there
+// is one, or else with OSC 52.
+func (s *session) copyToClipboard(text []byte) {
+	if s.localClipboard {
+		if tool := findClipboardTool(); tool != nil {
+			if _, err := runClipboardTool(tool.copy, text); err == nil {
+				return
+			}
+		}
+	}
+	if s.osc52 {
+		s.copyOSC52(text)
+		return
+	}
+	s.bell()
+}
+
+// pasteClipboard inserts the contents of the clipboard, read with a local
+// utility if there is one, as if they had been pasted. Otherwise it asks the
+// terminal for them, and they arrive later, as an OSC reply among the
+// keystrokes.
+func (s *session) pasteClipboard() {
+	if s.localClipboard {
+		if tool := findClipboardTool(); tool != nil {
+			if text, err := runClipboardTool(tool.paste, nil); err == nil {
+				if len(text) > 0 {
+					s.paste(string(text))
+				}
+				return
+			}
+		}
+	}
+	if !s.osc52 {
+		s.bell()
+		return
+	}
+	s.flush()
+	s.putExtended("\033]52;c;?\a")
+	s.awaitOSCReply()
+}
+
+// awaitOSCReply notes that the terminal has been asked a question, whose
+// answer will arrive among the keystrokes as an OSC string.
+func (s *session) awaitOSCReply() {
+	s.oscUntil = time.Now().Add(oscReplyWait)
+}
+
+// oscReplyStart reports whether ch, and the input after it, start the answer
+// to a question the terminal has been asked, and if so reads it as far as the
+// ESC ].
+func (s *session) oscReplyStart(ch byte) bool {
+	if ch != ESCAPE || s.oscUntil.IsZero() {
+		return false
+	}
+	if time.Now().After(s.oscUntil) {
+		s.oscUntil = time.Time{}
+		return false
+	}
+	if len(s.pending) == 0 {
+		s.awaitInput(keySequenceWait)
+	}
+	if len(s.pending) == 0 || s.pending[0] != ']' {
+		return false
+	}
+	s.pending = s.pending[1:]
+	return true
+}
+
+// oscByte adds ch to osc, an OSC string from the terminal that has been read
+// as far as the ESC ], and acts on the string once it is complete, which it
+// is at BEL or ESC \. It returns nil once the string is finished with, when
+// no more answers are expected.
+func (s *session) oscByte(osc []byte, ch byte) []byte {
+	if ch == BEEP {
+		s.oscUntil = time.Time{}
+		s.oscReply(osc)
+		return nil
+	}
+	if ch == '\\' && len(osc) > 0 && osc[len(osc)-1] == ESCAPE {
+		s.oscUntil = time.Time{}
+		s.oscReply(osc[:len(osc)-1])
+		return nil
+	}
+	if len(osc) >= maxOSC {
+		s.oscUntil = time.Time{}
+		return nil
+	}
+	return append(osc, ch)
+}
+
+// oscReply acts on a complete OSC string from the terminal. The clipboard's
+// contents are pasted, since they may hold anything.
+func (s *session) oscReply(osc []byte) {
+	if bytes.HasPrefix(osc, []byte("52;")) {
+		i := bytes.IndexByte(osc[3:], ';')
+		if i < 0 {
+			return
+		}
+		text, err := base64.StdEncoding.DecodeString(string(osc[3+i+1:]))
+		if err != nil || len(text) == 0 {
+			s.bell()
+			return
+		}
+		s.paste(string(text))
+	}
+	if bytes.HasPrefix(osc, []byte("11;")) && s.autoTheme {
+		s.setBackground(osc[3:])
+		s.drawn = false
+		s.drawline()
+	}
+}
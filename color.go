@@ -0,0 +1,253 @@
+package repl
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Color is a color for text or its background: one of the 256 colors of
+// the terminal's palette, or any color by its red, green and blue parts.
+// The zero Color is the terminal's default.
+type Color uint32
+
+const (
+	paletteColor Color = 1 << 24
+	rgbColor     Color = 2 << 24
+)
+
+// The first eight colors of the palette, which every color terminal has.
+// Palette(8) to Palette(15) are their bright versions.
+var (
+	Black   = Palette(0)
+	Red     = Palette(1)
+	Green   = Palette(2)
+	Yellow  = Palette(3)
+	Blue    = Palette(4)
+	Magenta = Palette(5)
+	Cyan    = Palette(6)
+	White   = Palette(7)
+)
+
+// Palette returns color n of the terminal's 256-color palette: the 16
+// standard colors, then a 6x6x6 color cube, then 24 shades of gray.
+func Palette(n uint8) Color {
+	return paletteColor | Color(n)
+}
+
+// RGB returns the color with the given red, green and blue parts, which
+// terminals without true color show as the nearest color they have.
+func RGB(r, g, b uint8) Color {
+	return rgbColor | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// appendSGR appends the SGR parameters that set c as the text color, or
+// the background color if base is 40, to params.
+func (c Color) appendSGR(params []string, base int) []string {
+	switch {
+	case c&rgbColor != 0:
+		return append(params, strconv.Itoa(base+8), "2", strconv.Itoa(int(c>>16&0xff)), strconv.Itoa(int(c>>8&0xff)), strconv.Itoa(int(c&0xff)))
+	case c&paletteColor != 0 && c&0xff < 8:
+		return append(params, strconv.Itoa(base+int(c&0xff)))
+	case c&paletteColor != 0 && c&0xff < 16:
+		return append(params, strconv.Itoa(base+60+int(c&0xff)-8))
+	case c&paletteColor != 0:
+		return append(params, strconv.Itoa(base+8), "5", strconv.Itoa(int(c&0xff)))
+	}
+	return params
+}
+
+// Style is a way of drawing text, for building the fields of a Theme
+// without spelling out escape sequences. Colors the terminal doesn't have
+// are drawn as the nearest ones it does (see WithColorDepth).
+type Style struct {
+	Fg        Color
+	Bg        Color
+	Bold      bool
+	Underline bool
+}
+
+// SGR returns the parameters of the SGR escape sequence for the style, as a
+// Theme field holds them, or "" for the terminal's default style.
+func (st Style) SGR() string {
+	if st == (Style{}) {
+		return ""
+	}
+	params := []string{"0"}
+	if st.Bold {
+		params = append(params, "1")
+	}
+	if st.Underline {
+		params = append(params, "4")
+	}
+	params = st.Fg.appendSGR(params, 30)
+	params = st.Bg.appendSGR(params, 40)
+	return strings.Join(params, ";")
+}
+
+// ColorDepth is how many colors the terminal can show.
+type ColorDepth int
+
+const (
+	// TrueColor terminals show any color, so styles are sent as they are.
+	TrueColor ColorDepth = iota
+	// Color256 terminals have the 256-color palette.
+	Color256
+	// Color16 terminals have the 8 standard colors and their bright
+	// versions.
+	Color16
+	// Color8 terminals have only the 8 standard colors.
+	Color8
+)
+
+// WithColorDepth says how many colors the terminal has, so that styles
+// using colors it doesn't have are drawn with the nearest ones it does. REPL
+// and Wrap take it from COLORTERM and TERM; other sessions send styles as
+// they are, unless told otherwise.
+func WithColorDepth(d ColorDepth) Option {
+	return func(s *session) {
+		s.colorDepth = d
+		s.sgrCache = nil
+	}
+}
+
+// envColorDepth returns the color depth the environment says the terminal
+// has.
+func envColorDepth() ColorDepth {
+	term := os.Getenv("TERM")
+	switch colorterm := os.Getenv("COLORTERM"); {
+	case colorterm == "truecolor" || colorterm == "24bit" || strings.HasSuffix(term, "-direct") || os.Getenv("WT_SESSION") != "":
+		return TrueColor
+	case strings.Contains(term, "256color"):
+		return Color256
+	case term == "linux" || term == "ansi" || strings.HasSuffix(term, "-color") || strings.HasSuffix(term, "-8color"):
+		return Color8
+	}
+	return Color16
+}
+
+// sgr returns the SGR parameters sgr with any colors the terminal doesn't
+// have replaced by the nearest ones it does.
+func (s *session) sgr(sgr string) string {
+	if s.colorDepth == TrueColor {
+		return sgr
+	}
+	if to, ok := s.sgrCache[sgr]; ok {
+		return to
+	}
+	if s.sgrCache == nil {
+		s.sgrCache = make(map[string]string)
+	}
+	to := downgradeSGR(sgr, s.colorDepth)
+	s.sgrCache[sgr] = to
+	return to
+}
+
+// downgradeSGR rewrites the colors in the SGR parameters sgr for a terminal
+// of the given depth.
+func downgradeSGR(sgr string, depth ColorDepth) string {
+	params := strings.Split(sgr, ";")
+	out := make([]string, 0, len(params))
+	for i := 0; i < len(params); i++ {
+		p, err := strconv.Atoi(params[i])
+		if err != nil {
+			out = append(out, params[i])
+			continue
+		}
+		switch {
+		case (p == 38 || p == 48) && i+2 < len(params) && params[i+1] == "5":
+			n, err := strconv.Atoi(params[i+2])
+			if err != nil || n < 0 || n > 255 {
+				out = append(out, params[i:i+3]...)
+			} else {
+				out = appendColor(out, p-8, n, paletteRGB(n), depth)
+			}
+			i += 2
+		case (p == 38 || p == 48) && i+4 < len(params) && params[i+1] == "2":
+			var rgb [3]int
+			for j := range rgb {
+				rgb[j], err = strconv.Atoi(params[i+2+j])
+				if err != nil {
+					break
+				}
+			}
+			if err != nil {
+				out = append(out, params[i:i+5]...)
+			} else {
+				out = appendColor(out, p-8, -1, rgb, depth)
+			}
+			i += 4
+		case depth == Color8 && (p >= 90 && p <= 97 || p >= 100 && p <= 107):
+			out = append(out, strconv.Itoa(p-60))
+		default:
+			out = append(out, params[i])
+		}
+	}
+	return strings.Join(out, ";")
+}
+
+// appendColor appends the SGR parameters for the color nearest to rgb,
+// which is palette color n unless n is -1, that a terminal of the given
+// depth has. base is 30 for the text color and 40 for the background.
+func appendColor(out []string, base int, n int, rgb [3]int, depth ColorDepth) []string {
+	switch {
+	case depth == Color256 && n >= 0:
+		return append(out, strconv.Itoa(base+8), "5", strconv.Itoa(n))
+	case depth == Color256:
+		return append(out, strconv.Itoa(base+8), "5", strconv.Itoa(nearestPalette(rgb, 16, 256)))
+	}
+	colors := 16
+	if depth == Color8 {
+		colors = 8
+	}
+	if n < 0 || n >= colors {
+		n = nearestPalette(rgb, 0, colors)
+	}
+	if n >= 8 {
+		return append(out, strconv.Itoa(base+60+n-8))
+	}
+	return append(out, strconv.Itoa(base+n))
+}
+
+// standardColors are the colors xterm gives the first 16 colors of its
+// palette by default.
+var standardColors = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the levels of red, green and blue in the color cube of the
+// 256-color palette.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// paletteRGB returns the red, green and blue parts of color n of the
+// 256-color palette.
+func paletteRGB(n int) [3]int {
+	switch {
+	case n < 16:
+		return standardColors[n]
+	case n < 232:
+		n -= 16
+		return [3]int{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]}
+	}
+	gray := 8 + (n-232)*10
+	return [3]int{gray, gray, gray}
+}
+
+// nearestPalette returns the color of the palette, from color from up to
+// color to, that is nearest to rgb.
+func nearestPalette(rgb [3]int, from, to int) int {
+	best, bestDist := from, -1
+	for n := from; n < to; n++ {
+		c := paletteRGB(n)
+		dist := 0
+		for j := range c {
+			d := c[j] - rgb[j]
+			dist += d * d
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = n, dist
+		}
+	}
+	return best
+}
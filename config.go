@@ -0,0 +1,400 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFile returns the path of the user's configuration file for programs
+// built on this package: $XDG_CONFIG_HOME/repl/config, or
+// ~/.config/repl/config if XDG_CONFIG_HOME is not set.
+func ConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "repl", "config")
+}
+
+// configSettings are the settings a configuration file may contain, each
+// turning its value into an option.
+var configSettings = map[string]func(value string) (Option, error){
+	"accessible": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithAccessibleMode(on), err
+	},
+	"ambiguous-completion": func(value string) (Option, error) {
+		a, ok := map[string]Ambiguous{"bell": AmbiguousBell, "silent": AmbiguousSilent, "count": AmbiguousCount, "list": AmbiguousList, "menu": AmbiguousMenu}[value]
+		if !ok {
+			return nil, fmt.Errorf("ambiguous-completion must be bell, silent, count, list, or menu")
+		}
+		return WithAmbiguousCompletion(a), nil
+	},
+	"completion-space": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithCompletionSpace(on), err
+	},
+	"bandwidth": func(value string) (Option, error) {
+		switch value {
+		case "auto":
+			return WithBandwidth(BandwidthAuto), nil
+		case "high":
+			return WithBandwidth(BandwidthHigh), nil
+		case "low":
+			return WithBandwidth(BandwidthLow), nil
+		}
+		return nil, fmt.Errorf("bandwidth must be auto, high, or low")
+	},
+	"bell": func(value string) (Option, error) {
+		switch value {
+		case "audible":
+			return WithBell(BellAudible), nil
+		case "visual":
+			return WithBell(BellVisual), nil
+		case "none":
+			return WithBell(BellNone), nil
+		}
+		return nil, fmt.Errorf("bell must be audible, visual, or none")
+	},
+	"abbr": func(value string) (Option, error) {
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("abbr needs a word and an expansion")
+		}
+		return WithAbbreviation(fields[0], strings.Join(fields[1:], " ")), nil
+	},
+	"alias": func(value string) (Option, error) {
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("alias needs a name and an expansion")
+		}
+		return WithAlias(fields[0], strings.Join(fields[1:], " ")), nil
+	},
+	"alias-display": func(value string) (Option, error) {
+		d, ok := map[string]AliasDisplay{"hidden": AliasHidden, "show": AliasShow, "in-place": AliasInPlace}[value]
+		if !ok {
+			return nil, fmt.Errorf("alias-display must be hidden, show or in-place")
+		}
+		return WithAliasDisplay(d), nil
+	},
+	"balance-indicator": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithBalanceIndicator(on), err
+	},
+	"bind": func(value string) (Option, error) {
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("bind needs a key and a command")
+		}
+		key, name := strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1]
+		if _, ok := commands[name]; !ok && name != "none" {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+		if name == "none" {
+			name = ""
+		}
+		return WithBinding(key, name), nil
+	},
+	"builtins": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithBuiltins(on), err
+	},
+	"clipboard": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithLocalClipboard(on), err
+	},
+	"color": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithColor(on), err
+	},
+	"heredoc": func(value string) (Option, error) {
+		if value == "off" {
+			value = ""
+		}
+		return WithHeredoc(value), nil
+	},
+	"idle-timeout": func(value string) (Option, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("idle-timeout must be a duration, such as 30m")
+		}
+		return WithIdleTimeout(d, nil), nil
+	},
+	"interrupt-grace": func(value string) (Option, error) {
+		if value == "off" {
+			return WithInterruptGrace(-1), nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("interrupt-grace must be a duration, such as 2s, or off")
+		}
+		return WithInterruptGrace(d), nil
+	},
+	"key-hints": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithKeyHints(on), err
+	},
+	"match-flash": func(value string) (Option, error) {
+		m, ok := map[string]MatchFlash{"jump": MatchJump, "color": MatchColor, "none": MatchNone}[value]
+		if !ok {
+			return nil, fmt.Errorf("match-flash must be jump, color, or none")
+		}
+		return func(s *session) {
+			s.matchFlash = m
+		}, nil
+	},
+	"match-flash-time": func(value string) (Option, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("match-flash-time must be a duration, such as 300ms")
+		}
+		return func(s *session) {
+			s.matchTime = d
+		}, nil
+	},
+	"normalize": func(value string) (Option, error) {
+		switch value {
+		case "accents":
+			return WithNormalizer(ComposeAccents), nil
+		case "off":
+			return WithNormalizer(nil), nil
+		}
+		return nil, fmt.Errorf("normalize must be accents or off")
+	},
+	"numbering": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		if !on {
+			return WithNumbering("", ""), err
+		}
+		return WithNumbering("In [%d]: ", "Out[%d]: "), err
+	},
+	"editor": func(value string) (Option, error) {
+		return WithEditor(value), nil
+	},
+	"osc52": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithOSC52(on), err
+	},
+	"paste": func(value string) (Option, error) {
+		m, ok := map[string]PasteMode{"block": PasteBlock, "lines": PasteLines, "typed": PasteTyped}[value]
+		if !ok {
+			return nil, fmt.Errorf("paste must be block, lines or typed")
+		}
+		return WithPasteMode(m), nil
+	},
+	"words": func(value string) (Option, error) {
+		m, ok := map[string]WordModel{"whitespace": WordsWhitespace, "readline": WordsReadline}[value]
+		if !ok {
+			return nil, fmt.Errorf("words must be whitespace or readline")
+		}
+		return WithWordModel(m), nil
+	},
+	"history-expansion": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithHistoryExpansion(on), err
+	},
+	"suggest": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithSuggestions(on), err
+	},
+	"tick": func(value string) (Option, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("tick must be a duration, such as 1s")
+		}
+		return WithTick(d), nil
+	},
+	"plain-output": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithPlainOutput(on), err
+	},
+	"color-depth": func(value string) (Option, error) {
+		d, ok := map[string]ColorDepth{"8": Color8, "16": Color16, "256": Color256, "truecolor": TrueColor}[value]
+		if !ok {
+			return nil, fmt.Errorf("color-depth must be 8, 16, 256, or truecolor")
+		}
+		return WithColorDepth(d), nil
+	},
+	"theme": func(value string) (Option, error) {
+		if value == "auto" {
+			return WithAutoTheme(true), nil
+		}
+		t, err := themeByName(value)
+		return WithTheme(t), err
+	},
+	"passthrough": func(value string) (Option, error) {
+		p, ok := map[string]Passthrough{"none": PassthroughNone, "tmux": PassthroughTmux, "screen": PassthroughScreen}[value]
+		if !ok {
+			return nil, fmt.Errorf("passthrough must be none, tmux, or screen")
+		}
+		return WithPassthrough(p), nil
+	},
+	"bidi": func(value string) (Option, error) {
+		m, ok := map[string]BidiMode{"reorder": BidiReorder, "logical": BidiLogical}[value]
+		if !ok {
+			return nil, fmt.Errorf("bidi must be reorder or logical")
+		}
+		return WithBidi(m), nil
+	},
+	"cursor": func(value string) (Option, error) {
+		c, ok := cursorShapes[value]
+		if !ok {
+			return nil, fmt.Errorf("cursor must be default, block, underline, bar, or one of those with blinking- before it")
+		}
+		return WithCursorShape(c), nil
+	},
+	"eof": func(value string) (Option, error) {
+		if value == "off" {
+			return WithEOF(0), nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("eof must be a number, or off")
+		}
+		return WithEOF(n), nil
+	},
+	"history-verify": func(value string) (Option, error) {
+		on, err := parseSwitch(value)
+		return WithHistoryVerify(on), err
+	},
+	"history-file": func(value string) (Option, error) {
+		if strings.HasPrefix(value, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				value = filepath.Join(home, value[2:])
+			}
+		}
+		return WithHistoryFile(value), nil
+	},
+	"history-size": func(value string) (Option, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("history-size must be a number")
+		}
+		return WithHistorySize(n), nil
+	},
+}
+
+// parseSwitch parses the value of an on or off setting.
+func parseSwitch(value string) (bool, error) {
+	switch value {
+	case "on", "true", "yes":
+		return true, nil
+	case "off", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off")
+}
+
+// LoadConfig reads the configuration file at path and returns the options it
+// sets. The file holds one setting per line, in the form "name = value", and
+// lines starting with # are comments. A file that does not exist sets no
+// options.
+//
+// REPL and Wrap load the user's ConfigFile for the console session, applying
+// it beneath the options passed to them, so that end users can tune the
+// behavior of the programs they use and the programs still have the last
+// word. Other sessions can be given a configuration by passing the options
+// returned here.
+func LoadConfig(path string) ([]Option, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var options []Option
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return options, fmt.Errorf("%s:%d: expected name = value", path, lineno)
+		}
+		name := strings.TrimSpace(line[:i])
+		setting, ok := configSettings[name]
+		if !ok {
+			return options, fmt.Errorf("%s:%d: unknown setting %q", path, lineno, name)
+		}
+		option, err := setting(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return options, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		options = append(options, option)
+	}
+	return options, scanner.Err()
+}
+
+// envOptions returns the options set by environment variables, following
+// common conventions: NO_COLOR, or CLICOLOR=0, turns color off, as does
+// output that is not a terminal, unless CLICOLOR_FORCE is set, and has escape
+// sequences removed altogether; REPL_HISTFILE names a history file; and
+// VISUAL, or else EDITOR, the editor for C-x C-e. COLORTERM and TERM say how
+// many colors the terminal has, and on a terminal with color the theme is
+// picked to suit its background. The local clipboard is used unless the
+// program is running under SSH, and extended escape sequences are passed
+// through tmux or screen when it is running in one.
+func envOptions() []Option {
+	var options []Option
+	if p := envPassthrough(); p != PassthroughNone {
+		options = append(options, WithPassthrough(p))
+	}
+	if os.Getenv("SSH_CONNECTION") == "" && os.Getenv("SSH_TTY") == "" {
+		options = append(options, WithLocalClipboard(true))
+	}
+	color := isTerminal(int(os.Stdout.Fd()))
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("CLICOLOR") == "0" {
+		color = false
+	} else if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		color = true
+	}
+	if !color {
+		options = append(options, WithColor(false))
+		if !isTerminal(int(os.Stdout.Fd())) {
+			options = append(options, WithPlainOutput(true))
+		}
+	} else {
+		options = append(options, WithColorDepth(envColorDepth()))
+	}
+	if color && isTerminal(int(os.Stdin.Fd())) && isTerminal(int(os.Stdout.Fd())) {
+		options = append(options, WithAutoTheme(true))
+	}
+	if path := os.Getenv("REPL_HISTFILE"); path != "" {
+		options = append(options, WithHistoryFile(path))
+	}
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		options = append(options, WithEditor(editor))
+	} else if editor := os.Getenv("EDITOR"); editor != "" {
+		options = append(options, WithEditor(editor))
+	}
+	return options
+}
+
+// userOptions returns the options set by the environment, then those from
+// the user's configuration file, followed by options, so that each can
+// override the ones before. Any problem with the file is reported on stderr.
+func userOptions(options []Option) []Option {
+	env := envOptions()
+	path := ConfigFile()
+	if path == "" {
+		return append(env, options...)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repl:", err)
+	}
+	return append(append(env, config...), options...)
+}
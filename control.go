@@ -0,0 +1,163 @@
+package repl
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrClosed is returned by REPL, RunTerminal and the other functions that run
+// a session when the session was ended by Control.Close.
+var ErrClosed = errors.New("Session closed")
+
+// errSessionFinished is what a Control returns once its session has ended.
+var errSessionFinished = errors.New("Session finished")
+
+// Control lets the application act on a running session from outside it. It
+// is attached to a session with WithControl, and its methods may be called
+// from any goroutine, including from the handler's own Eval.
+type Control struct {
+	mu     sync.Mutex
+	s      *session
+	next   ReplHandler //the handler waiting to replace the session's, if any
+	closed bool
+}
+
+// WithControl attaches c to the session, so that the application can use it
+// to act on the session while it runs. A Control belongs to a single
+// session, so it shouldn't be given in the options of a Server, which are
+// shared by all of its sessions.
+func WithControl(c *Control) Option {
+	return func(s *session) {
+		c.mu.Lock()
+		c.s = s
+		c.closed = false
+		c.mu.Unlock()
+		s.control = c
+		s.closing = make(chan struct{})
+	}
+}
+
+// SetHandler replaces the session's handler with h, for an application that
+// reloads its scripting engine or switches between languages without ending
+// the session. The replacement is made on the session's goroutine, between
+// keystrokes, once any Eval in progress has returned: the old handler is
+// stopped with the history so far, and h is started, its history replacing
+// the session's unless it returns nil. An Eval the user has abandoned isn't
+// waited for; the old handler is stopped once it returns, on another
+// goroutine, while the session goes on with h. Any
+// unfinished multi-line entry is discarded, and the line being edited is
+// kept, redrawn with h's prompt. SetHandler doesn't wait for this to happen,
+// so it can be called from within Eval, in which case the replacement is made
+// as soon as that Eval returns. If it is called again before then, only the
+// last handler given replaces the old one.
+func (c *Control) SetHandler(h ReplHandler) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.s
+	if s == nil {
+		return errors.New("Control is not attached to a session")
+	}
+	select {
+	case <-s.stopped:
+		return errSessionFinished
+	default:
+	}
+	posted := c.next != nil
+	c.next = h
+	if !posted {
+		go s.post(c.swap)
+	}
+	return nil
+}
+
+// Close ends the session, for an application that is exiting or an
+// administrator shutting a session down. Any Eval in progress is asked to stop,
+// if the handler is an Interrupter, and allowed to return; then the session
+// stops waiting for input, even with keystrokes typed ahead, stops its
+// handler with the history so far, and restores the terminal, and the
+// function running it returns ErrClosed. Close doesn't wait for this, so it
+// can be called from within Eval; closing a session that has already
+// finished does nothing.
+func (c *Control) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.s == nil {
+		return errors.New("Control is not attached to a session")
+	}
+	if !c.closed {
+		c.closed = true
+		close(c.s.closing)
+	}
+	return nil
+}
+
+// isClosing reports whether the session's Control has closed it.
+func (s *session) isClosing() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// swap replaces the session's handler with the one most recently given to
+// SetHandler, unless the session has done so already.
+func (c *Control) swap() {
+	if h := c.take(); h != nil {
+		c.s.swapHandler(h)
+	}
+}
+
+// take returns the handler waiting to replace the session's, if any, so that
+// the session can make the replacement as soon as an Eval that asked for it
+// returns, before any input typed ahead is evaluated.
+func (c *Control) take() ReplHandler {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.next
+	c.next = nil
+	return h
+}
+
+// swapHandler stops the session's handler and starts h in its place. If an
+// evaluation the user abandoned is still running on the old handler, it is
+// stopped on a goroutine of its own once that evaluation returns, so that a
+// handler stuck in Eval doesn't hold the session up.
+func (s *session) swapHandler(h ReplHandler) {
+	var history []string
+	if s.buf != nil {
+		history = s.buf.history
+	}
+	old, abandoned := s.handler, stillRunning(s.abandoned)
+	s.abandoned = nil
+	if len(abandoned) == 0 {
+		old.Stop(history)
+	} else {
+		history = append([]string(nil), history...)
+		go func() {
+			for _, done := range abandoned {
+				<-done
+			}
+			old.Stop(history)
+		}()
+	}
+	s.handler = h
+	s.adopt()
+	s.switchHistory()
+	if hist := h.Start(); hist != nil && s.buf != nil {
+		s.buf.history = hist
+		s.buf.leaveHistory()
+	}
+	if r, ok := h.(Resizer); ok && s.cols > 0 {
+		r.Resized(s.cols, s.rows)
+	}
+	s.partial = s.partial[:0]
+	if s.buf != nil {
+		s.prompt = s.mainPrompt()
+		s.drawline()
+	}
+}
@@ -0,0 +1,60 @@
+package repl
+
+import (
+	"strconv"
+)
+
+// CursorShape is the shape of the terminal's cursor, as set by the DECSCUSR
+// escape sequence, whose parameter it is.
+type CursorShape int
+
+const (
+	// CursorDefault is whatever shape the user has set up the terminal to
+	// use.
+	CursorDefault CursorShape = iota
+	CursorBlinkingBlock
+	CursorBlock
+	CursorBlinkingUnderline
+	CursorUnderline
+	CursorBlinkingBar
+	CursorBar
+)
+
+// cursorShapes are the shapes, by the names the config file gives them.
+var cursorShapes = map[string]CursorShape{
+	"default":            CursorDefault,
+	"blinking-block":     CursorBlinkingBlock,
+	"block":              CursorBlock,
+	"blinking-underline": CursorBlinkingUnderline,
+	"underline":          CursorUnderline,
+	"blinking-bar":       CursorBlinkingBar,
+	"bar":                CursorBar,
+}
+
+// WithCursorShape sets the shape of the cursor while the session runs. The
+// user's own shape is put back when it ends. Terminals that can't change the
+// cursor's shape ignore it.
+func WithCursorShape(c CursorShape) Option {
+	return func(s *session) {
+		s.cursorShape = c
+	}
+}
+
+// setCursorShape changes the shape of the cursor, if it isn't that shape
+// already.
+func (s *session) setCursorShape(c CursorShape) {
+	if c == s.cursorShown {
+		return
+	}
+	s.putString("\033[" + strconv.Itoa(int(c)) + " q")
+	s.cursorShown = c
+}
+
+// startCursor sets the cursor's shape for the session, and returns the
+// function that puts the user's own back.
+func (s *session) startCursor() func() {
+	s.setCursorShape(s.cursorShape)
+	return func() {
+		s.setCursorShape(CursorDefault)
+	}
+}
@@ -0,0 +1,97 @@
+package repl
+
+import "strings"
+
+// dabbrevState is where dynamic abbreviation expansion has got to, between
+// presses of M-/.
+type dabbrevState struct {
+	stem       string   //the start of the word being expanded
+	candidates []string //the words it could be expanded to, nearest first
+	next       int      //the index of the next candidate to try
+	inserted   int      //how much of the current candidate was inserted
+}
+
+// isDabbrevChar says whether ch can be part of a word for dynamic
+// abbreviation expansion.
+func isDabbrevChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-' || ch >= 0x80
+}
+
+// dabbrevExpand expands the word before the cursor to a word that starts
+// with it, found earlier in the line, later in the line, or in the history,
+// most recent first. Pressed again, it replaces the expansion with the next
+// word found, and after the last, goes back to the word as typed.
+func (s *session) dabbrevExpand(ch byte) {
+	buf := s.buf
+	d := &s.dabbrev
+	if s.lastCommand != "dabbrev-expand" {
+		start := buf.cursor
+		for start > 0 && isDabbrevChar(buf.at(start-1)) {
+			start--
+		}
+		if start == buf.cursor {
+			s.thisCommand = ""
+			s.bell()
+			return
+		}
+		stem := string(buf.buf[start:buf.cursor])
+		*d = dabbrevState{stem: stem, candidates: s.dabbrevCandidates(stem, start)}
+	} else {
+		//take out the previous expansion, which ends at the cursor
+		buf.cursor -= d.inserted
+		buf.length -= d.inserted
+	}
+	if d.next >= len(d.candidates) {
+		d.next, d.inserted = 0, 0
+		s.drawline()
+		s.bell()
+		return
+	}
+	cursor := buf.cursor
+	buf.InsertString(d.candidates[d.next][len(d.stem):])
+	d.inserted = buf.cursor - cursor
+	d.next++
+	s.drawline()
+}
+
+// dabbrevCandidates returns the distinct words, other than stem itself, that
+// start with stem: those before start in the line, nearest first, then those
+// after the word at the cursor, then those in the history, most recent first.
+func (s *session) dabbrevCandidates(stem string, start int) []string {
+	buf := s.buf
+	seen := map[string]bool{stem: true}
+	var candidates []string
+	add := func(words []string) {
+		for _, word := range words {
+			if strings.HasPrefix(word, stem) && !seen[word] {
+				seen[word] = true
+				candidates = append(candidates, word)
+			}
+		}
+	}
+	line := buf.String()
+	before := dabbrevWords(line[:start])
+	for i, j := 0, len(before)-1; i < j; i, j = i+1, j-1 {
+		before[i], before[j] = before[j], before[i]
+	}
+	add(before)
+	end := buf.cursor
+	for end < len(line) && isDabbrevChar(line[end]) {
+		end++
+	}
+	add(dabbrevWords(line[end:]))
+	for i := len(buf.history) - 1; i >= 0; i-- {
+		words := dabbrevWords(buf.history[i])
+		for j := len(words) - 1; j >= 0; j-- {
+			add(words[j : j+1])
+		}
+	}
+	return candidates
+}
+
+// dabbrevWords returns the words in text, in order.
+func dabbrevWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r < 0x80 && !isDabbrevChar(byte(r))
+	})
+}
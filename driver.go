@@ -0,0 +1,53 @@
+package repl
+
+import (
+	"io"
+)
+
+// Run runs a REPL session for handler on streams supplied by the caller, such
+// as the ends of pipes, with no terminal at all, so that a host program can
+// drive the session entirely in-process, as a bridge between the REPL and a
+// chat service might. The bytes read from in reach the session as keystrokes,
+// and out receives everything the session draws, with newlines sent as CRLF
+// as a terminal would receive them. Unless WithSize is given, the session
+// doesn't know the width of the screen, and lines are not wrapped for it.
+//
+// Run returns when the session ends, or when reading from in fails, as it does
+// at end of file. Unlike Pipe, it runs the session on the caller's goroutine,
+// and the caller reads out at its own pace. A session ended by Control.Close
+// may leave a read of in waiting, for the caller to end by closing it.
+func Run(in io.Reader, out io.Writer, handler ReplHandler, options ...Option) error {
+	t := &streamTerminal{in: in, out: crlfWriter{out}}
+	return runTerminal(newSession(handler, t, options...), t)
+}
+
+// WithSize sets the width and height of the screen, for a session on a
+// terminal whose size can't be found out, such as one run by Run.
+func WithSize(cols, rows int) Option {
+	return func(s *session) {
+		s.cols, s.rows = cols, rows
+	}
+}
+
+// streamTerminal is a Terminal made of a reader and a writer, with no size or
+// modes.
+type streamTerminal struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (st *streamTerminal) Read(p []byte) (int, error) {
+	return st.in.Read(p)
+}
+
+func (st *streamTerminal) Write(p []byte) (int, error) {
+	return st.out.Write(p)
+}
+
+func (st *streamTerminal) Size() (int, int) {
+	return 0, 0
+}
+
+func (st *streamTerminal) SetMode(mode TerminalMode) error {
+	return nil
+}
@@ -0,0 +1,79 @@
+package repl
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// defaultEditor is the editor edit-and-execute-command runs when none is set.
+const defaultEditor = "vi"
+
+// WithEditor sets the editor that edit-and-execute-command, bound to C-x
+// C-e, runs on the line, as readline does: a command such as "vi" or
+// "code --wait", which is given the name of a file holding the line. REPL
+// and Wrap take it from VISUAL or EDITOR; without any, vi is run.
+func WithEditor(command string) Option {
+	return func(s *session) {
+		s.editor = command
+	}
+}
+
+// editAndExecute runs the editor on the line, with the terminal handed over
+// to it as RunInteractive hands it over, and then enters what it left in the
+// file as if it had been pasted. Sessions with no tty of their own, and
+// editors that fail, ring the bell and leave the line as it was.
+func (s *session) editAndExecute() {
+	if _, ok := s.terminal.(*ttyTerminal); !ok {
+		s.bell()
+		return
+	}
+	//the editor starts below the line, which is drawn afresh when it is done
+	s.flush()
+	s.putString("\n")
+	s.drawn = false
+	text, err := s.runEditor(s.buf.String())
+	if err != nil {
+		s.bell()
+		s.drawline()
+		return
+	}
+	text = strings.TrimRight(text, "\r\n")
+	s.buf.Clear()
+	if strings.Contains(text, "\n") && s.pasteMode != PasteTyped {
+		s.paste(text + "\n")
+		return
+	}
+	s.buf.InsertString(s.normalized(strings.ReplaceAll(text, "\n", " ")))
+	s.drawline()
+	commands["accept-line"].run(s, RETURN)
+}
+
+// runEditor edits text in a temporary file with the session's editor, and
+// returns what the editor left in it.
+func (s *session) runEditor(text string) (string, error) {
+	f, err := os.CreateTemp("", "repl-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(text + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	args := strings.Fields(s.editor)
+	if len(args) == 0 {
+		args = []string{defaultEditor}
+	}
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	t := rawTakeover{fn: func(s *session) error { return s.runInteractive(cmd) }, done: make(chan error, 1)}
+	s.takeRaw(t)
+	if err := <-t.done; err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(f.Name())
+	return string(edited), err
+}
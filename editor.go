@@ -0,0 +1,105 @@
+package repl
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrInterrupted is returned by LineEditor.Edit when the user presses Ctrl-C.
+var ErrInterrupted = errors.New("interrupted")
+
+// LineEditor edits single lines on a terminal, with the keys, history,
+// completion and rendering of a session, but no REPL loop around them, for
+// programs that ask for a value in the middle of some other flow.
+type LineEditor struct {
+	// Complete, if not nil, completes the line up to the cursor, as a
+	// handler's Complete does.
+	Complete func(line string) (string, []string)
+	// History holds the lines entered, oldest first, and is added to by
+	// each call to Edit.
+	History []string
+
+	t       Terminal
+	options []Option
+	pending []byte //input read past the end of the last line edited
+}
+
+// NewLineEditor returns a LineEditor for t, which edits with the given
+// options, as a session would.
+func NewLineEditor(t Terminal, options ...Option) *LineEditor {
+	return &LineEditor{t: t, options: options}
+}
+
+// editorHandler is the handler for the session a LineEditor edits with,
+// which only ever supplies the prompt and completions.
+type editorHandler struct {
+	e      *LineEditor
+	prompt string
+}
+
+func (h *editorHandler) Eval(expr string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (h *editorHandler) Complete(expr string) (string, []string) {
+	if h.e.Complete == nil {
+		return "", nil
+	}
+	return h.e.Complete(expr)
+}
+
+func (h *editorHandler) Reset() {
+}
+
+func (h *editorHandler) Prompt() string {
+	return h.prompt
+}
+
+func (h *editorHandler) Start() []string {
+	return nil
+}
+
+func (h *editorHandler) Stop(history []string) {
+}
+
+// Edit shows prompt, followed by initial, which the user can edit, and
+// returns the line once it is entered. Keys typed ahead of the line being
+// entered are kept for the next call. It returns io.EOF if the user presses
+// Ctrl-D on an empty line or the terminal's input ends, and ErrInterrupted if
+// the user presses Ctrl-C.
+func (e *LineEditor) Edit(prompt string, initial string) (string, error) {
+	s := newSession(&editorHandler{e: e, prompt: prompt}, e.t, e.options...)
+	s.editing = true
+	s.input = nil
+	var data [1024]byte
+	s.readInput = func() ([]byte, error) {
+		n, err := e.t.Read(data[:])
+		return data[:n], err
+	}
+	s.pending, e.pending = e.pending, nil
+	s.cols, s.rows = e.t.Size()
+	if err := e.t.SetMode(ModeCbreak); err != nil {
+		return "", err
+	}
+	defer e.t.SetMode(ModeNormal)
+	//a session with a line already is one being resumed, which is redrawn
+	buf := newLineBuf(1024)
+	buf.history = e.History
+	buf.historySize = s.historySize
+	buf.maxLength = s.maxLineLength
+	buf.words = s.wordModel
+	buf.InsertString(initial)
+	buf.initial = initial
+	s.buf = buf
+	s.prompt = prompt
+	s.run()
+	e.History = buf.history
+	e.pending = s.pending
+	if s.editErr != nil {
+		return "", s.editErr
+	}
+	if !s.edited {
+		return "", io.EOF
+	}
+	return s.editedLine, nil
+}
@@ -0,0 +1,80 @@
+package repl
+
+import (
+	"errors"
+	"strings"
+)
+
+// Fuzz runs data through the key decoder and line editor of a session, as
+// though it had all been typed at once, against a handler that does nothing
+// but echo and offer a few completions. No terminal is involved: the output
+// goes to a VirtualTerminal, which is discarded. Fuzz panics if the session
+// does, or if it leaves the line it was editing in an inconsistent state.
+//
+// It follows the go-fuzz convention, returning 1 for input that ran through
+// the editor, and can be called from a "go test -fuzz" target:
+//
+//	func FuzzREPL(f *testing.F) {
+//		f.Fuzz(func(t *testing.T, data []byte) { repl.Fuzz(data) })
+//	}
+func Fuzz(data []byte) int {
+	vt := NewVirtualTerminal(80, 24)
+	s := newSession(fuzzHandler{}, vt)
+	s.pending = data
+	s.input = nil //no more input is coming
+	s.async = make(chan func(), 1)
+	s.async <- func() { s.done = true }
+	s.run()
+	lb := s.buf
+	if lb.cursor < 0 || lb.cursor > lb.length || lb.length > len(lb.buf) {
+		panic("line buffer out of range")
+	}
+	if s.shownCursor < 0 || s.shownCursor > len(s.shown) {
+		panic("shown cursor out of range")
+	}
+	return 1
+}
+
+// fuzzHandler is the handler used by Fuzz.
+type fuzzHandler struct{}
+
+var fuzzWords = []string{"define", "defmacro", "display", "lambda", "let"}
+
+func (fuzzHandler) Eval(expr string) (string, bool, error) {
+	if strings.HasSuffix(expr, "\\") {
+		return "", true, nil
+	}
+	if strings.HasPrefix(expr, "!") {
+		return "", false, errors.New(expr)
+	}
+	return expr, false, nil
+}
+
+func (fuzzHandler) Complete(expr string) (string, []string) {
+	i := strings.LastIndexAny(expr, " ()")
+	prefix := expr[i+1:]
+	var matches []string
+	for _, word := range fuzzWords {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0][len(prefix):], matches
+	}
+	return "", matches
+}
+
+func (fuzzHandler) Reset() {
+}
+
+func (fuzzHandler) Prompt() string {
+	return "? "
+}
+
+func (fuzzHandler) Start() []string {
+	return []string{"(define x 1)", "(display x)"}
+}
+
+func (fuzzHandler) Stop(history []string) {
+}
@@ -0,0 +1,227 @@
+package repl
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GRPCHandler returns an http.Handler serving the Repl gRPC service described
+// in repl.proto, for infrastructure that already standardizes on gRPC. Each
+// Session stream gets its own handler from newHandler, which is started when
+// the stream opens and stopped, with the lines evaluated as its history, when
+// the client closes it. Inputs are answered in the order they are sent, but
+// an interrupt is passed on to the handler's Interrupt as soon as it arrives,
+// if the handler is an Interrupter, to cut short an eval that is running.
+//
+// gRPC requires HTTP/2, so the handler must be served either over TLS or by
+// an http.Server configured for unencrypted HTTP/2:
+//
+//	srv := &http.Server{Addr: ":9000", Handler: repl.GRPCHandler(newHandler)}
+//	srv.Protocols = new(http.Protocols)
+//	srv.Protocols.SetUnencryptedHTTP2(true)
+//	srv.ListenAndServe()
+func GRPCHandler(newHandler HandlerFactory) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requires HTTP/2", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		if r.URL.Path != "/repl.Repl/Session" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "12") //UNIMPLEMENTED
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", "unknown method "+r.URL.Path)
+			return
+		}
+		serveGRPCSession(w, r, newHandler())
+	})
+}
+
+func serveGRPCSession(w http.ResponseWriter, r *http.Request, handler ReplHandler) {
+	flusher, _ := w.(http.Flusher)
+	send := func(out *grpcOutput) error {
+		msg := out.marshal()
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+		if _, err := w.Write(append(frame, msg...)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	history := handler.Start()
+	status, message := "0", ""
+	if err := send(&grpcOutput{prompt: handler.Prompt()}); err != nil {
+		handler.Stop(history)
+		return
+	}
+	//inputs are answered in turn on a goroutine of their own, so that an
+	//interrupt can be read, and passed on, while an eval runs
+	queue := make(chan *grpcInput, maxProtocolQueue)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for in := range queue {
+			if in.kind == 1 && in.text != "" {
+				history = append(history, in.text)
+			}
+			if err := send(handleGRPCInput(handler, in)); err != nil {
+				return
+			}
+		}
+	}()
+	for reading := true; reading; {
+		in, err := readGRPCInput(r.Body)
+		if err != nil {
+			if err != io.EOF {
+				status, message = "13", err.Error() //INTERNAL
+			}
+			break
+		}
+		if in.kind == 3 {
+			interruptHandler(handler)
+		}
+		select {
+		case queue <- in:
+		case <-stopped:
+			reading = false
+		}
+	}
+	close(queue)
+	<-stopped
+	handler.Stop(history)
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", status)
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", message)
+	}
+}
+
+// handleGRPCInput evaluates a line, completes text, or resets the handler
+// after an interrupt, as in says, and returns the Output to send back.
+func handleGRPCInput(handler ReplHandler, in *grpcInput) *grpcOutput {
+	out := &grpcOutput{}
+	switch in.kind {
+	case 1:
+		result, more, err := handler.Eval(in.text)
+		if err != nil {
+			out.err = err.Error()
+			out.prompt = handler.Prompt()
+		} else if more {
+			out.more = true
+		} else {
+			out.value = result
+			out.prompt = handler.Prompt()
+		}
+	case 2:
+		out.completion, out.candidates = handler.Complete(in.text)
+	case 3:
+		resetHandler(handler, ResetInterrupt, "")
+		out.prompt = handler.Prompt()
+	}
+	return out
+}
+
+// grpcInput is a decoded Input message: kind is the number of the field set
+// in its oneof, and text its value for line and complete.
+type grpcInput struct {
+	kind int
+	text string
+}
+
+type grpcOutput struct {
+	prompt     string
+	value      string
+	err        string
+	more       bool
+	completion string
+	candidates []string
+}
+
+func readGRPCInput(r io.Reader) (*grpcInput, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("Compressed gRPC messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxWebSocketMessage {
+		return nil, errors.New("gRPC message too large")
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	in := &grpcInput{}
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errors.New("Malformed gRPC message")
+		}
+		msg = msg[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errors.New("Malformed gRPC message")
+			}
+			msg = msg[n:]
+			if field == 3 && v != 0 {
+				in.kind = 3
+			}
+		case 2:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return nil, errors.New("Malformed gRPC message")
+			}
+			if field == 1 || field == 2 {
+				in.kind = field
+				in.text = string(msg[n : n+int(length)])
+			}
+			msg = msg[n+int(length):]
+		case 1:
+			if len(msg) < 8 {
+				return nil, errors.New("Malformed gRPC message")
+			}
+			msg = msg[8:]
+		case 5:
+			if len(msg) < 4 {
+				return nil, errors.New("Malformed gRPC message")
+			}
+			msg = msg[4:]
+		default:
+			return nil, errors.New("Malformed gRPC message")
+		}
+	}
+	return in, nil
+}
+
+func (out *grpcOutput) marshal() []byte {
+	var msg []byte
+	str := func(field int, s string) {
+		if s != "" {
+			msg = binary.AppendUvarint(msg, uint64(field<<3|2))
+			msg = binary.AppendUvarint(msg, uint64(len(s)))
+			msg = append(msg, s...)
+		}
+	}
+	str(1, out.prompt)
+	str(2, out.value)
+	str(3, out.err)
+	if out.more {
+		msg = append(msg, 4<<3, 1)
+	}
+	str(5, out.completion)
+	for _, c := range out.candidates {
+		msg = binary.AppendUvarint(msg, uint64(6<<3|2))
+		msg = binary.AppendUvarint(msg, uint64(len(c)))
+		msg = append(msg, c...)
+	}
+	return msg
+}
@@ -0,0 +1,53 @@
+package repl
+
+import (
+	"strings"
+)
+
+// WithHeredoc lets a line that ends with introducer followed by a word, such
+// as "<<EOF", go on for as many lines as it takes, until one that is just
+// the word. The lines between are taken verbatim, without abbreviations or
+// completion, and the whole, from the first line to the last, is evaluated
+// as one string. The word may be quoted. Heredocs are off by default.
+func WithHeredoc(introducer string) Option {
+	return func(s *session) {
+		s.heredoc = introducer
+	}
+}
+
+// heredocLine takes a line that has been entered, and returns what should be
+// evaluated, or false if the line starts or continues a heredoc, and nothing
+// should be evaluated yet.
+func (s *session) heredocLine(line string) (string, bool) {
+	if s.heredocEnd != "" {
+		s.heredocLines = append(s.heredocLines, line)
+		if strings.TrimSpace(line) != s.heredocEnd {
+			return "", false
+		}
+		text := strings.Join(s.heredocLines, "\n")
+		s.heredocLines = s.heredocLines[:0]
+		s.heredocEnd = ""
+		return text, true
+	}
+	if s.heredoc == "" || len(s.partial) > 0 {
+		return line, true
+	}
+	i := strings.LastIndex(line, s.heredoc)
+	if i < 0 {
+		return line, true
+	}
+	word := strings.Trim(strings.TrimSpace(line[i+len(s.heredoc):]), `'"`)
+	if word == "" || strings.ContainsAny(word, " \t") {
+		return line, true
+	}
+	s.heredocEnd = word
+	s.heredocLines = append(s.heredocLines[:0], line)
+	return "", false
+}
+
+// heredocPrompt shows the prompt for the next line of a heredoc, which is
+// none, as for the lines of any unfinished entry.
+func (s *session) heredocPrompt() {
+	s.prompt = ""
+	s.showPrompt()
+}
@@ -0,0 +1,125 @@
+package repl
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hintDelay is how long the session waits, after the first keys of a chord,
+// before showing the keys that can follow them, and hintTime is how long it
+// shows them.
+const hintDelay = 500 * time.Millisecond
+const hintTime = 5 * time.Second
+
+// maxHintLines is the most lines the hints for a chord take up.
+const maxHintLines = 8
+
+// WithKeyHints turns on or off the hints shown when the user pauses partway
+// through a chord such as C-x C-w: after a moment, the keys that can complete
+// the chord are listed below the line, with the commands they run. The hints
+// go away when the next key is typed, or after a few seconds. They are on by
+// default, except in accessible mode.
+func WithKeyHints(on bool) Option {
+	return func(s *session) {
+		s.noKeyHints = !on
+	}
+}
+
+// keyHints returns the keys that can follow the chord prefix, each with the
+// command it runs, laid out in columns that fit in cols.
+func (s *session) keyHints(prefix string, cols int) []string {
+	stem := chordStem(prefix)
+	var items []string
+	width := 0
+	for key, name := range s.keymap {
+		if strings.HasPrefix(key, stem) && len(key) > len(stem) {
+			item := key[len(stem):] + " " + name
+			items = append(items, item)
+			if len(item) > width {
+				width = len(item)
+			}
+		}
+	}
+	sort.Strings(items)
+	width += 3
+	perLine := (cols - 1) / width
+	if perLine < 1 {
+		perLine = 1
+	}
+	var lines []string
+	for i := 0; i < len(items) && len(lines) < maxHintLines; i += perLine {
+		var sb strings.Builder
+		for j := i; j < i+perLine && j < len(items); j++ {
+			sb.WriteString(items[j])
+			if j+1 < i+perLine && j+1 < len(items) {
+				sb.WriteString(strings.Repeat(" ", width-len(items[j])))
+			}
+		}
+		line := sb.String()
+		if len(line) >= cols {
+			line = line[:cols-1]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// showHints lists the keys that can complete the chord begun so far below
+// the line being edited.
+func (s *session) showHints() {
+	cols := s.cols
+	if cols <= 0 {
+		cols = 80
+	}
+	s.showBelow(s.keyHints(s.chord, cols))
+}
+
+// showBelow shows lines, in the hint style, below the line being edited,
+// leaving the cursor where it was, until the next key is typed or a few
+// seconds have passed.
+func (s *session) showBelow(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	if s.hintLines > 0 {
+		s.clearHints()
+	}
+	s.flush()
+	frame := s.frame[:0]
+	for _, line := range lines {
+		frame = append(frame, RETURN, NEWLINE)
+		frame = s.appendStyled(frame, s.theme.Hint, []byte(line))
+		frame = append(frame, ESCAPE, '[', 'K')
+	}
+	frame = append(frame, ESCAPE, '[', 'J', ESCAPE, '[')
+	frame = strconv.AppendInt(frame, int64(len(lines)), 10)
+	frame = append(frame, 'A')
+	s.frame = frame
+	s.putChars(frame)
+	s.hintLines = len(lines)
+	s.damagedBelow()
+	s.drawn = false
+	s.drawline()
+	s.hintShown++
+	shown := s.hintShown
+	time.AfterFunc(hintTime, func() {
+		s.post(func() {
+			if s.hintLines > 0 && s.hintShown == shown {
+				s.clearHints()
+			}
+		})
+	})
+}
+
+// clearHints erases the hints below the line being edited.
+func (s *session) clearHints() {
+	s.flush()
+	s.putString("\033[B\r\033[J\033[A")
+	s.hintLines = 0
+	s.damagedBelow()
+	s.drawn = false
+	s.drawline()
+	s.drawBelow()
+}
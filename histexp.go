@@ -0,0 +1,219 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WithHistoryExpansion turns on csh and bash style history expansion, which
+// replaces references to earlier lines in a line entered with what they refer
+// to: !! is the previous line, !n the line :history numbers n, !-n the line n
+// back, !text the last line that starts with text, and !?text the last that
+// contains it. A word designator after a colon picks words out of the line: :0
+// is the first, :n the nth after it, :^ the first after it, :$ the last, and :*
+// all but the first; !$, !^ and !* are short for those of the previous line. A
+// ! before a space, = or ( or at the end of the line, or after a backslash, is
+// left alone. A line of the form ^old^new^ is the previous line with the
+// first old in it replaced by new, and anything after the last ^ added.
+func WithHistoryExpansion(on bool) Option {
+	return func(s *session) {
+		s.historyExpansion = on
+	}
+}
+
+// errNoEvent is what expandHistory returns for a reference to a line that
+// isn't in the history.
+var errNoEvent = errors.New("event not found")
+
+// errSubstitution is what expandHistory returns for ^old^new when the previous
+// line has no old in it.
+var errSubstitution = errors.New("substitution failed")
+
+// expandHistory returns line with its history references expanded, and
+// whether there were any.
+func (s *session) expandHistory(line string) (string, bool, error) {
+	if strings.HasPrefix(line, "^") && strings.Count(line, "^") >= 2 {
+		return s.quickSubstitution(line)
+	}
+	if !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+	var sb strings.Builder
+	expanded := false
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		if ch == '\\' && i+1 < len(line) && line[i+1] == '!' {
+			sb.WriteString("\\!")
+			i++
+			continue
+		}
+		if ch != '!' || i+1 == len(line) || strings.IndexByte(" \t=(", line[i+1]) >= 0 {
+			sb.WriteByte(ch)
+			continue
+		}
+		text, n, err := s.historyReference(line[i:])
+		if err != nil {
+			return line, false, err
+		}
+		sb.WriteString(text)
+		expanded = true
+		i += n - 1
+	}
+	return sb.String(), expanded, nil
+}
+
+// quickSubstitution expands a line of the form ^old^new^rest.
+func (s *session) quickSubstitution(line string) (string, bool, error) {
+	parts := strings.SplitN(line[1:], "^", 3)
+	event := "^" + parts[0] + "^" + parts[1]
+	prev, ok := s.buf.historyEntry(1)
+	if !ok {
+		return line, false, fmt.Errorf("%s: %w", event, errNoEvent)
+	}
+	if parts[0] == "" || !strings.Contains(prev, parts[0]) {
+		return line, false, fmt.Errorf("%s: %w", event, errSubstitution)
+	}
+	expansion := strings.Replace(prev, parts[0], parts[1], 1)
+	if len(parts) == 3 {
+		expansion += parts[2]
+	}
+	return expansion, true, nil
+}
+
+// historyReference expands the history reference at the start of ref,
+// which starts with !, and returns the text it refers to and how long it is.
+func (s *session) historyReference(ref string) (string, int, error) {
+	buf := s.buf
+	var line string
+	var ok bool
+	n := 1
+	switch c := ref[1]; {
+	case c == '!':
+		line, ok = buf.historyEntry(1)
+		n = 2
+	case c == '$' || c == '^' || c == '*':
+		line, ok = buf.historyEntry(1)
+		return s.designated(line, ok, "!!", ref[1:], 1)
+	case c == '-' || c >= '0' && c <= '9':
+		j := 2
+		for j < len(ref) && ref[j] >= '0' && ref[j] <= '9' {
+			j++
+		}
+		num, err := strconv.Atoi(ref[1:j])
+		if err == nil && num < 0 {
+			line, ok = buf.historyEntry(-num)
+		} else if err == nil && num > 0 {
+			line, ok = buf.numberedEntry(num)
+		}
+		n = j
+	case c == '?':
+		j := strings.IndexByte(ref[2:], '?')
+		text := ref[2:]
+		n = len(ref)
+		if j >= 0 {
+			text, n = ref[2:2+j], j+3
+		}
+		line, ok = buf.searchHistory(func(l string) bool { return strings.Contains(l, text) })
+	default:
+		j := 1
+		for j < len(ref) && strings.IndexByte(" \t:", ref[j]) < 0 {
+			j++
+		}
+		prefix := ref[1:j]
+		line, ok = buf.searchHistory(func(l string) bool { return strings.HasPrefix(l, prefix) })
+		n = j
+	}
+	if n < len(ref) && ref[n] == ':' {
+		return s.designated(line, ok, ref[:n], ref[n+1:], n+1)
+	}
+	if !ok {
+		return "", 0, fmt.Errorf("%s: %w", ref[:n], errNoEvent)
+	}
+	return line, n, nil
+}
+
+// designated returns the words of line that the word designator at the start
+// of rest picks out, and the length of the whole reference, which is n bytes
+// before rest, and the designator.
+func (s *session) designated(line string, ok bool, event string, rest string, n int) (string, int, error) {
+	if !ok {
+		return "", 0, fmt.Errorf("%s: %w", event, errNoEvent)
+	}
+	words := strings.Fields(line)
+	if rest == "" {
+		return "", 0, fmt.Errorf("%s: bad word specifier", event)
+	}
+	switch c := rest[0]; {
+	case c == '$' && len(words) > 0:
+		return words[len(words)-1], n + 1, nil
+	case c == '^' && len(words) > 1:
+		return words[1], n + 1, nil
+	case c == '*':
+		if len(words) < 2 {
+			return "", n + 1, nil
+		}
+		return strings.Join(words[1:], " "), n + 1, nil
+	case c >= '0' && c <= '9':
+		j := 0
+		for j < len(rest) && rest[j] >= '0' && rest[j] <= '9' {
+			j++
+		}
+		if k, _ := strconv.Atoi(rest[:j]); k < len(words) {
+			return words[k], n + j, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%s:%c: bad word specifier", event, rest[0])
+}
+
+// searchHistory returns the most recent history entry that match accepts.
+func (lb *lineBuf) searchHistory(match func(string) bool) (string, bool) {
+	for back := 1; ; back++ {
+		line, ok := lb.historyEntry(back)
+		if !ok {
+			return "", false
+		}
+		if match(line) {
+			return line, true
+		}
+	}
+}
+
+// expandHistoryInLine expands the history references in the line, for the
+// accept-line and magic-space commands, expanding only those before the
+// cursor if beforeCursor is set. It leaves the line alone and reports the
+// error if a reference can't be expanded. It reports whether the line
+// changed.
+func (s *session) expandHistoryInLine(beforeCursor bool) (bool, error) {
+	if !s.historyExpansion || s.heredocEnd != "" {
+		return false, nil
+	}
+	buf := s.buf
+	line := buf.String()
+	before, after := line, ""
+	if beforeCursor {
+		before, after = line[:buf.cursor], line[buf.cursor:]
+	}
+	expansion, changed, err := s.expandHistory(before)
+	if err != nil || !changed {
+		return false, err
+	}
+	buf.Clear()
+	buf.InsertString(expansion)
+	cursor := buf.cursor
+	buf.InsertString(after)
+	buf.moveTo(cursor)
+	return true, nil
+}
+
+// magicSpace expands the history references before the cursor, so that they
+// can be checked before the line is entered, and inserts a space. It is
+// meant to be bound to SPC.
+func (s *session) magicSpace(ch byte) {
+	if _, err := s.expandHistoryInLine(true); err != nil {
+		s.bell()
+	}
+	s.drawline()
+	s.selfInsert(SPACE)
+}
@@ -0,0 +1,246 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// historyBlock is how much of a history file is read at a time.
+const historyBlock = 64 * 1024
+
+// WithHistoryFile keeps the session's history in the file at path, one entry
+// per line. Lines entered in the session are appended to it as they are
+// accepted, and earlier entries are recalled from it with the usual history
+// keys, after any history the handler's Start returned.
+//
+// The file is not read at startup. It is read backwards from its end, a block
+// at a time, as the user moves back through history, so a file with a very
+// large history costs nothing until its older entries are actually wanted.
+func WithHistoryFile(path string) Option {
+	return func(s *session) {
+		s.historyFile = path
+	}
+}
+
+// WithHistorySize limits history to the n most recent entries, counting those
+// recalled from a history file. The default, 0, is no limit.
+func WithHistorySize(n int) Option {
+	return func(s *session) {
+		s.historySize = n
+	}
+}
+
+// historyFile is the lazily read contents of a history file. Entries are
+// indexed newest first, and only as far back as has been asked for.
+type historyFile struct {
+	path    string
+	end     int64    //the offset in the file before which nothing has been read
+	partial []byte   //the start of an entry whose beginning is still unread
+	entries []string //newest first
+}
+
+// openHistoryFile notes the size of the file at path, without reading it. A
+// file that does not exist yet is treated as empty.
+func openHistoryFile(path string) *historyFile {
+	hf := &historyFile{path: path}
+	if info, err := os.Stat(path); err == nil {
+		hf.end = info.Size()
+	}
+	return hf
+}
+
+// entry returns the i'th most recent entry in the file, counting from 0,
+// reading as much more of the file as it takes to find it.
+func (hf *historyFile) entry(i int) (string, bool) {
+	for i >= len(hf.entries) && hf.end > 0 {
+		if !hf.readBlock() {
+			break
+		}
+	}
+	if i < len(hf.entries) {
+		return hf.entries[i], true
+	}
+	return "", false
+}
+
+// readBlock reads the block of the file before the part already read, and
+// indexes the entries it completes.
+func (hf *historyFile) readBlock() bool {
+	f, err := os.Open(hf.path)
+	if err != nil {
+		hf.end = 0
+		return false
+	}
+	defer f.Close()
+	start := hf.end - historyBlock
+	if start < 0 {
+		start = 0
+	}
+	data := make([]byte, hf.end-start, hf.end-start+int64(len(hf.partial)))
+	if _, err := f.ReadAt(data, start); err != nil {
+		hf.end = 0
+		return false
+	}
+	data = append(data, hf.partial...)
+	hf.end = start
+	for {
+		i := len(data) - 1
+		for i >= 0 && data[i] != '\n' {
+			i--
+		}
+		if i < 0 && start > 0 {
+			hf.partial = data
+			return true
+		}
+		if line := data[i+1:]; len(line) > 0 {
+			hf.entries = append(hf.entries, string(line))
+		}
+		if i < 0 {
+			hf.partial = nil
+			return true
+		}
+		data = data[:i]
+	}
+}
+
+// add appends line to the end of the file.
+func (hf *historyFile) add(line string) {
+	f, err := os.OpenFile(hf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	f.WriteString(line + "\n")
+	f.Close()
+}
+
+// allHistory returns the whole history, oldest first, reading all of the
+// history file if there is one. An entry's number is its place in it,
+// counting from 1.
+func (lb *lineBuf) allHistory() []string {
+	var entries []string
+	for back := 1; ; back++ {
+		line, ok := lb.historyEntry(back)
+		if !ok {
+			break
+		}
+		entries = append(entries, line)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// numberedEntry returns the entry numbered n, as :history numbers them.
+func (lb *lineBuf) numberedEntry(n int) (string, bool) {
+	entries := lb.allHistory()
+	if n < 1 || n > len(entries) {
+		return "", false
+	}
+	return entries[n-1], true
+}
+
+// WithHistoryVerify puts a line recalled from history by :r, or changed by
+// history expansion, back in the line to be looked over, so that it runs
+// only when RET is pressed again, as bash's histverify option does. By
+// default such lines run straight away.
+func WithHistoryVerify(on bool) Option {
+	return func(s *session) {
+		s.historyVerify = on
+	}
+}
+
+// rerun carries out :r, which recalls the history entry numbered n, the
+// entry -n back if n is negative, or the entry before the :r itself if n is
+// missing, and runs it, or leaves it in the line with WithHistoryVerify. An
+// entry that is a :r is refused, as the :r itself is in history by now, and
+// running one could run the same :r again, and so on for ever.
+func (s *session) rerun(arg string) {
+	lb := s.buf
+	var line string
+	var ok bool
+	n, err := strconv.Atoi(arg)
+	switch {
+	case arg == "":
+		line, ok = lb.historyEntry(2) //the entry before the :r
+	case err == nil && n < 0:
+		line, ok = lb.historyEntry(1 - n)
+	case err == nil:
+		line, ok = lb.numberedEntry(n)
+	}
+	if !ok {
+		s.putString(fmt.Sprintf(s.messages.NoHistoryEntry+"\n", arg))
+		return
+	}
+	if fields := strings.Fields(line); len(fields) > 0 && fields[0] == ":r" {
+		s.putString(fmt.Sprintf(s.messages.RerunOfRerun+"\n", arg))
+		return
+	}
+	lb.Clear()
+	lb.InsertString(line)
+	if !s.historyVerify {
+		s.pending = append([]byte{RETURN}, s.pending...)
+	}
+}
+
+// HistoryNamer may be implemented by a handler with modes of its own, such as
+// a SQL mode and a script mode, that should each have a history of their
+// own. HistoryName returns the name of the mode the handler is in, or "" for
+// its main history; it is asked when the session starts, after each
+// evaluation, and when Control.SetHandler gives the session a new handler, so
+// the history switches whenever the mode does. Each history is kept in a file
+// of its own, named like the history file with "." and the mode's name added.
+type HistoryNamer interface {
+	HistoryName() string
+}
+
+// historyName returns the name of the history h's current mode uses.
+func historyName(h ReplHandler) string {
+	if hn, ok := h.(HistoryNamer); ok {
+		return hn.HistoryName()
+	}
+	return ""
+}
+
+// savedHistory is the history of a mode that isn't in use.
+type savedHistory struct {
+	history []string
+	file    *historyFile
+	pinned  []string
+}
+
+// historyPath returns the path of the file that keeps the history in use, or
+// "" if history is kept only in memory.
+func (s *session) historyPath() string {
+	if s.historyFile == "" || s.historyName == "" {
+		return s.historyFile
+	}
+	return s.historyFile + "." + fileSafe(s.historyName)
+}
+
+// switchHistory puts the history of the handler's current mode in use, if
+// it isn't already, keeping that of the mode before for when it is back.
+func (s *session) switchHistory() {
+	name := historyName(s.handler)
+	lb := s.buf
+	if name == s.historyName || lb == nil {
+		return
+	}
+	if s.histories == nil {
+		s.histories = make(map[string]*savedHistory)
+	}
+	s.histories[s.historyName] = &savedHistory{lb.history, lb.historyFile, lb.pinned}
+	s.historyName = name
+	lb.leaveHistory()
+	if saved, ok := s.histories[name]; ok {
+		lb.history, lb.historyFile, lb.pinned = saved.history, saved.file, saved.pinned
+		return
+	}
+	lb.history, lb.historyFile, lb.pinned = nil, nil, nil
+	if path := s.historyPath(); path != "" {
+		lb.historyFile = openHistoryFile(path)
+		s.loadPinned()
+	}
+}
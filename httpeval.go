@@ -0,0 +1,189 @@
+package repl
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPSessionHeader names the header that carries the HTTP session id.
+const HTTPSessionHeader = "X-Repl-Session"
+
+// httpSessionIdle is how long an HTTP session may go unused before it is
+// discarded.
+const httpSessionIdle = 30 * time.Minute
+
+// maxHTTPSessions is the most HTTP sessions kept at once. Requests that would
+// start another are refused until one is discarded.
+const maxHTTPSessions = 256
+
+// errTooManySessions is returned by session when a new session is needed but
+// maxHTTPSessions are kept already.
+var errTooManySessions = errors.New("Too many sessions")
+
+// errNoSession is returned by session for a request that may only continue a
+// session, given the id of none the client has.
+var errNoSession = errors.New("No such session")
+
+// HTTPHandler returns an http.Handler that evaluates code for web dashboards
+// and scripts. It serves
+//
+//	POST /eval           the request body is the code to evaluate
+//	POST /complete       the request body is the text to complete
+//	GET  /complete?code= the same, in a session already started
+//
+// and replies with a ProtocolResponse in JSON. Each client session gets its
+// own handler from newHandler, so multi-line input and any other handler
+// state is kept per session. The session id is returned in the
+// X-Repl-Session header of every response, and is passed back in the same
+// header (or a "session" query parameter) to continue the session. A session
+// can only be continued by the client that started it, as told by its
+// credentials, or by its host when there are none. Sessions are discarded
+// after 30 minutes without use, and no more than 256 are kept.
+//
+// So that a web page the user visits can't run code with it, requests from
+// pages on other origins than the server's own are refused, unless
+// WithAllowedOrigins allows them, and so is text posted with any of the
+// content types a form can send, such as text/plain, unless
+// WithAuthentication is given to check the client's bearer token or
+// certificate. Only a POST starts a session, since a page can have the
+// browser GET anything without saying where it comes from. The other options
+// are ignored.
+func HTTPHandler(newHandler HandlerFactory, options ...Option) http.Handler {
+	s := newSession(nil, io.Discard, options...)
+	return &httpEval{newHandler: newHandler, auth: s.auth, origins: s.origins, sessions: make(map[string]*httpSession)}
+}
+
+type httpEval struct {
+	newHandler HandlerFactory
+	auth       func(c Credentials) bool
+	origins    []string
+	mu         sync.Mutex
+	sessions   map[string]*httpSession
+}
+
+type httpSession struct {
+	mu       sync.Mutex
+	handler  ReplHandler
+	owner    string //the Credentials.owner of the client that started it
+	lastUsed time.Time
+}
+
+func (he *httpEval) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !originAllowed(r, he.origins) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	creds := httpCredentials(r)
+	if he.auth != nil && !he.auth(creds) {
+		http.Error(w, "Authentication failed", http.StatusUnauthorized)
+		return
+	}
+	req := &ProtocolRequest{}
+	switch {
+	case r.Method == "POST" && (strings.HasSuffix(r.URL.Path, "/eval") || strings.HasSuffix(r.URL.Path, "/complete")):
+		if he.auth == nil && formContentType(r.Header.Get("Content-Type")) {
+			http.Error(w, "Code must not be sent as a form", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebSocketMessage))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Op = "eval"
+		if strings.HasSuffix(r.URL.Path, "/complete") {
+			req.Op = "complete"
+		}
+		req.Code = string(body)
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/complete"):
+		req.Op = "complete"
+		req.Code = r.URL.Query().Get("code")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	id := r.Header.Get(HTTPSessionHeader)
+	if id == "" {
+		id = r.URL.Query().Get("session")
+	}
+	id, hs, err := he.session(id, creds.owner(), r.Method == "POST")
+	switch err {
+	case errTooManySessions:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errNoSession:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	hs.mu.Lock()
+	resp := handleProtocolRequest(hs.handler, req)
+	hs.mu.Unlock()
+	w.Header().Set(HTTPSessionHeader, id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// formContentType reports whether t is one of the content types a web page
+// can post to another origin without asking first, as a form does.
+func formContentType(t string) bool {
+	t, _, _ = mime.ParseMediaType(t)
+	switch t {
+	case "", "text/plain", "application/x-www-form-urlencoded", "multipart/form-data":
+		return true
+	}
+	return false
+}
+
+// httpCredentials returns the credentials an HTTP client presented with r.
+func httpCredentials(r *http.Request) Credentials {
+	c := Credentials{RemoteAddr: r.RemoteAddr, Token: bearerToken(r)}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		c.Certificates = r.TLS.VerifiedChains[0]
+	}
+	return c
+}
+
+// session returns the session with the given id that owner started, starting
+// a new one if there is no such session and start is set, and discards any
+// that have gone idle.
+func (he *httpEval) session(id string, owner string, start bool) (string, *httpSession, error) {
+	he.mu.Lock()
+	defer he.mu.Unlock()
+	now := time.Now()
+	for key, hs := range he.sessions {
+		if now.Sub(hs.lastUsed) > httpSessionIdle {
+			delete(he.sessions, key)
+			go func(hs *httpSession) {
+				hs.mu.Lock()
+				defer hs.mu.Unlock()
+				hs.handler.Stop(nil)
+			}(hs)
+		}
+	}
+	hs, ok := he.sessions[id]
+	ok = ok && hs.owner == owner
+	if !ok && !start {
+		return "", nil, errNoSession
+	}
+	if !ok && len(he.sessions) >= maxHTTPSessions {
+		return "", nil, errTooManySessions
+	}
+	if !ok {
+		var b [16]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+		hs = &httpSession{handler: he.newHandler(), owner: owner}
+		hs.handler.Start()
+		he.sessions[id] = hs
+	}
+	hs.lastUsed = now
+	return id, hs, nil
+}
@@ -0,0 +1,63 @@
+package repl
+
+import (
+	"time"
+)
+
+// WithIdleTimeout locks or ends the session once no key has been typed for
+// d, for shared consoles that shouldn't be left open. If unlock is nil the
+// session ends, with the handler's Stop called as usual. Otherwise the line
+// being edited is hidden and the user is asked for a passphrase, typed
+// without echo, until unlock accepts it; the session then carries on where it
+// left off.
+func WithIdleTimeout(d time.Duration, unlock func(passphrase string) bool) Option {
+	return func(s *session) {
+		s.idleTimeout = d
+		s.unlock = unlock
+	}
+}
+
+// idle is called when the session has been idle for its idle timeout. It
+// reports whether the session should carry on.
+func (s *session) idle() bool {
+	s.flush()
+	if s.unlock == nil {
+		s.putString("\n" + s.messages.IdleExit + "\n")
+		s.handler.Stop(s.buf.history)
+		s.exit = true
+		return false
+	}
+	s.locked, s.secret = true, true
+	defer func() { s.locked, s.secret = false, false }()
+	s.putString("\r\033[J" + s.messages.Locked + "\n")
+	for true {
+		s.putString(s.messages.Passphrase)
+		var passphrase []byte
+		for true {
+			ch, ok := s.getChar()
+			if !ok {
+				return false
+			}
+			if ch == RETURN || ch == NEWLINE {
+				break
+			}
+			switch ch {
+			case DELETE, BACKSPACE:
+				if len(passphrase) > 0 {
+					passphrase = passphrase[:len(passphrase)-1]
+				}
+			case CTRL_C:
+				passphrase = passphrase[:0]
+			default:
+				passphrase = append(passphrase, ch)
+			}
+		}
+		s.putString("\n")
+		if s.unlock(string(passphrase)) {
+			s.drawn = false
+			s.drawline()
+			return true
+		}
+	}
+	return false //never happens
+}
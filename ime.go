@@ -0,0 +1,80 @@
+package repl
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// WithPreedit sets fn to see the text an input method sends as it is
+// composed, for terminals that forward the preedit text, the text still
+// being composed, as well as the text committed. Each burst of non-ASCII text
+// typed is passed to fn, after any preedit text held back from the burst
+// before, and fn returns the part to put into the line and the part that is
+// still being composed, which is shown at the cursor but kept out of the line
+// until a later burst commits it. Any other key commits it as it stands.
+func WithPreedit(fn func(text string) (commit string, preedit string)) Option {
+	return func(s *session) {
+		s.preeditFunc = fn
+	}
+}
+
+// insertComposed inserts the character whose UTF-8 encoding starts with ch,
+// along with the rest of the text typed in the same burst, as an input method
+// sends a composed word, so that it goes into the line in one piece and the
+// line is redrawn once.
+func (s *session) insertComposed(ch byte) {
+	text := []byte{ch}
+	for !utf8.FullRune(text) {
+		ch, ok := s.getChar()
+		if !ok {
+			return
+		}
+		text = append(text, ch)
+	}
+	for len(s.pending) > 0 && s.pending[0] >= utf8.RuneSelf {
+		n := 1
+		for n < len(s.pending) && !utf8.RuneStart(s.pending[n]) {
+			n++
+		}
+		if !utf8.FullRune(s.pending[:n]) {
+			break //the rest of it is yet to come, and will be a burst of its own
+		}
+		text = append(text, s.pending[:n]...)
+		s.pending = s.pending[n:]
+	}
+	if !utf8.Valid(text) {
+		s.bell()
+		return
+	}
+	str := string(text)
+	if s.preeditFunc != nil {
+		str, s.preedit = s.preeditFunc(s.preedit + str)
+	}
+	str = s.normalized(strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, str))
+	if s.buf.fit(len(str)) < len(str) {
+		s.bell()
+		return
+	}
+	s.buf.InsertString(str)
+	s.drawline()
+}
+
+// commitPreedit puts any preedit text being shown into the line, before a
+// key other than more composed text is handled.
+func (s *session) commitPreedit() {
+	if s.preedit == "" {
+		return
+	}
+	str := s.preedit
+	s.preedit = ""
+	if s.buf.fit(len(str)) == len(str) {
+		s.buf.InsertString(s.normalized(str))
+	}
+	s.drawline()
+}
@@ -0,0 +1,137 @@
+package repl
+
+import (
+	"errors"
+	"time"
+)
+
+// Interrupter may be implemented by a handler whose evaluations can be cut
+// short. Interrupt is called, from the session's goroutine rather than the
+// one running Eval, when the user presses Ctrl-C while Eval is running; Eval
+// should then return as soon as it can, with an error if it likes. Interrupt
+// itself should not block.
+type Interrupter interface {
+	Interrupt()
+}
+
+// interruptGrace is how soon, by default, a second Ctrl-C must follow the
+// first to abandon an evaluation.
+const interruptGrace = 2 * time.Second
+
+// errAbandoned is returned by evaluate when the user gave up waiting for the
+// handler.
+var errAbandoned = errors.New("evaluation abandoned")
+
+// WithInterruptGrace sets how soon a second Ctrl-C must follow the first,
+// during an evaluation, to abandon it: the first asks the handler to stop, if
+// it is an Interrupter, and the second stops waiting for it and returns to
+// the prompt, leaving Eval to finish by itself, with a warning that the
+// handler's state may be inconsistent. If d is 0, the default of two seconds
+// is used; if it is negative, evaluations are never abandoned.
+func WithInterruptGrace(d time.Duration) Option {
+	return func(s *session) {
+		s.noAbandon = d < 0
+		s.interruptGrace = d
+	}
+}
+
+// evalResult is what the handler's Eval returned.
+type evalResult struct {
+	result string
+	more   bool
+	err    error
+}
+
+// evaluate calls the handler's Eval on a goroutine of its own, and waits for
+// it, watching the input for Ctrl-C meanwhile. Other input is kept for after
+// the evaluation. It returns errAbandoned if the user stopped waiting.
+func (s *session) evaluate(str string) (string, bool, error) {
+	done := make(chan evalResult, 1)
+	handler := s.handler
+	go func() {
+		result, more, err := handler.Eval(str)
+		done <- evalResult{result, more, err}
+	}()
+	grace := s.interruptGrace
+	if grace == 0 {
+		grace = interruptGrace
+	}
+	var interrupted time.Time
+	closing := s.closing
+	var take chan rawTakeover
+	if s.raw != nil {
+		take = s.raw.take
+	}
+	for {
+		select {
+		case r := <-done:
+			return r.result, r.more, r.err
+		case t := <-take:
+			s.takeRaw(t)
+		case <-closing:
+			closing = nil //the handler is asked to stop once, then waited for
+			if i, ok := s.handler.(Interrupter); ok {
+				i.Interrupt()
+			}
+		case chunk := <-s.input:
+			if s.trace != nil && !s.secret {
+				s.traceInput(chunk)
+			}
+			for i, ch := range chunk {
+				if ch != CTRL_C {
+					s.pending = append(s.pending, ch)
+				} else if !interrupted.IsZero() && !s.noAbandon && time.Since(interrupted) < grace {
+					if sh, ok := serialized(handler); ok {
+						sh.abandon()
+					}
+					s.abandoned = append(stillRunning(s.abandoned), done)
+					//what was typed after the Ctrl-C is for the prompt it returns to
+					s.pending = append(s.pending, chunk[i+1:]...)
+					return "", false, errAbandoned
+				} else {
+					interrupted = time.Now()
+					s.interruptNotice()
+					if i, ok := s.handler.(Interrupter); ok {
+						i.Interrupt()
+					}
+				}
+			}
+		}
+	}
+}
+
+// stillRunning returns those of the abandoned evaluations, given by the
+// channels their results go to, that haven't yet returned.
+func stillRunning(abandoned []chan evalResult) []chan evalResult {
+	running := abandoned[:0]
+	for _, done := range abandoned {
+		if len(done) == 0 {
+			running = append(running, done)
+		}
+	}
+	return running
+}
+
+// interruptNotice says that the evaluation has been interrupted, above any
+// progress being shown.
+func (s *session) interruptNotice() {
+	msg := s.messages.Interrupting + "\n"
+	if s.noAbandon {
+		msg = s.messages.Interrupt + "\n"
+	}
+	p := s.progress
+	if p == nil {
+		s.putString(msg)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shown > 0 {
+		s.putString("\r\033[J")
+		p.shown = 0
+	}
+	s.putString(msg)
+	if len(p.lines(true)) > 0 {
+		p.draw()
+	}
+}
@@ -0,0 +1,531 @@
+package repl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Keys are named the way the Emacs and readline documentation names them:
+// "C-a" is Ctrl-A, "M-f" is ESC followed by f, "TAB", "RET", "DEL", "SPC" and
+// "ESC" are those keys, and "ESC [ A" is the sequence the up arrow sends. A
+// chord of several keys is named by its keys, separated by spaces. A key
+// bound to the command "prefix" starts a chord.
+
+// defaultKeymap binds each key to the name of the command it runs. Printable
+// characters not in the keymap insert themselves.
+var defaultKeymap = map[string]string{
+	"C-a":     "beginning-of-line",
+	"C-b":     "backward-char",
+	"C-c":     "interrupt",
+	"C-d":     "delete-char-or-eof",
+	"C-e":     "end-of-line",
+	"C-f":     "forward-char",
+	"C-k":     "kill-line",
+	"C-l":     "clear-screen",
+	"C-n":     "next-history",
+	"C-p":     "previous-history",
+	"C-y":     "yank",
+	"TAB":     "complete",
+	"RET":     "accept-line",
+	"DEL":     "backward-delete-char",
+	"ESC":     "prefix",
+	"M-DEL":   "backward-kill-word",
+	"M-.":     "yank-last-arg",
+	"M-0":     "digit-argument",
+	"M-1":     "digit-argument",
+	"M-2":     "digit-argument",
+	"M-3":     "digit-argument",
+	"M-4":     "digit-argument",
+	"M-5":     "digit-argument",
+	"M-6":     "digit-argument",
+	"M-7":     "digit-argument",
+	"M-8":     "digit-argument",
+	"M-9":     "digit-argument",
+	"M-/":     "dabbrev-expand",
+	"M-\\":    "delete-horizontal-space",
+	"M-SPC":   "just-one-space",
+	"M-p":     "history-search-backward",
+	"M-n":     "history-search-forward",
+	"M-b":     "backward-word",
+	"M-d":     "kill-word",
+	"M-f":     "forward-word",
+	"M-h":     "describe-bindings",
+	"M-r":     "copy-result",
+	"M-w":     "copy-line",
+	"M-[":     "prefix",
+	"M-C-y":   "yank-nth-arg",
+	"C-x":     "prefix",
+	"C-x ?":   "describe-bindings",
+	"C-x p":   "pin-line",
+	"C-x C-e": "edit-and-execute-command",
+	"C-x C-r": "copy-result",
+	"C-x C-t": "toggle-recording",
+	"C-x C-u": "revert-line",
+	"C-x C-w": "copy-line",
+	"C-x C-y": "paste-clipboard",
+	"ESC [ 2": "bracketed-paste",
+	"ESC [ A": "previous-history",
+	"ESC [ B": "next-history",
+	"ESC [ C": "forward-char",
+	"ESC [ D": "backward-char",
+}
+
+// WithBinding binds key to the named command, or unbinds it if command is
+// empty. The commands are listed by the describe-bindings command, bound to
+// M-h, and the :bindings command.
+func WithBinding(key string, command string) Option {
+	return func(s *session) {
+		if command == "" {
+			delete(s.keymap, key)
+		} else {
+			s.keymap[key] = command
+		}
+	}
+}
+
+// newKeymap returns a copy of the default keymap, for a session to change as
+// it likes.
+func newKeymap() map[string]string {
+	keymap := make(map[string]string, len(defaultKeymap))
+	for key, command := range defaultKeymap {
+		keymap[key] = command
+	}
+	return keymap
+}
+
+// command is something a key can be bound to. run is passed the last key of
+// the chord that invoked it.
+type command struct {
+	doc string
+	run func(s *session, ch byte)
+}
+
+// commands are the commands keys can be bound to, by name. It is filled in by
+// init, as some commands refer back to it.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"accept-line": {"evaluate the line", func(s *session, ch byte) {
+			buf := s.buf
+			if s.expandAbbreviation() {
+				s.drawline()
+			}
+			if changed, err := s.expandHistoryInLine(false); err != nil {
+				//leave the line to be put right
+				s.flush()
+				s.putString("\n" + s.messages.Error + " " + err.Error() + "\n")
+				s.drawn = false
+				s.drawline()
+				return
+			} else if changed && s.historyVerify {
+				s.drawline() //to be looked over before it runs
+				return
+			}
+			s.drawline()
+			s.flush()
+			s.drawBalance()
+			s.eraseBelow()
+			if !buf.IsEmpty() {
+				s.putChar('\n')
+			}
+			s.drawn = false
+			if s.editing {
+				if buf.IsEmpty() {
+					s.putChar('\n')
+				}
+				s.editedLine = s.acceptedLines()
+				s.edited, s.exit = true, true
+				return
+			}
+			str, ok := s.heredocLine(s.acceptedLines())
+			if !ok {
+				s.heredocPrompt()
+				return
+			}
+			str = s.expandAlias(str)
+			if !s.builtin(str) {
+				s.eval(str)
+			}
+		}},
+		"backward-char": {"move back a character", func(s *session, ch byte) {
+			if s.buf.Backward() {
+				s.drawCursor()
+			}
+		}},
+		"backward-delete-char": {"delete the character before the cursor", func(s *session, ch byte) {
+			if s.buf.Backward() {
+				s.buf.Delete()
+				if !s.echoBackspace() {
+					s.drawline()
+				}
+			} else {
+				s.bell()
+			}
+		}},
+		"backward-kill-word": {"kill the word before the cursor", func(s *session, ch byte) {
+			s.buf.WordBackspace()
+			s.drawline()
+			s.killed()
+		}},
+		"backward-word": {"move back a word", func(s *session, ch byte) {
+			s.buf.WordBackward()
+			s.drawCursor()
+		}},
+		"bracketed-paste": {"take in pasted text", (*session).bracketedPaste},
+		"beginning-of-line": {"move to the start of the line", func(s *session, ch byte) {
+			s.buf.Begin()
+			s.drawCursor()
+		}},
+		"clear-screen": {"clear the screen and redraw the line at the top", func(s *session, ch byte) {
+			s.flush()
+			s.putString("\033[H\033[2J")
+			s.toolbarRows = 0 //the toolbar was cleared too
+			if s.progress != nil {
+				s.progress.below = 0
+			}
+			s.drawn = false
+			s.drawline()
+			s.drawBelow()
+		}},
+		"complete": {"complete the word before the cursor; twice lists the candidates", (*session).complete},
+		"copy-line": {"copy the line to the clipboard", func(s *session, ch byte) {
+			s.copyToClipboard([]byte(s.buf.String()))
+		}},
+		"copy-result": {"copy the last result to the clipboard", func(s *session, ch byte) {
+			s.copyToClipboard([]byte(s.result))
+		}},
+		"dabbrev-expand": {"expand the word before the cursor from other words in the line or history; again for the next", (*session).dabbrevExpand},
+		"delete-char-or-eof": {"delete the character under the cursor, or end the session if the line is empty", func(s *session, ch byte) {
+			if s.buf.IsEmpty() {
+				s.eof()
+			} else {
+				s.buf.Delete()
+				s.drawline()
+			}
+		}},
+		"delete-horizontal-space": {"delete the spaces around the cursor", func(s *session, ch byte) {
+			if s.buf.DeleteHorizontalSpace() > 0 {
+				s.drawline()
+			}
+		}},
+		"describe-bindings": {"list the key bindings", func(s *session, ch byte) {
+			s.flush()
+			for _, line := range s.describeBindings() {
+				s.putChar(NEWLINE)
+				s.putString(line)
+			}
+			s.putChar(NEWLINE)
+			s.drawn = false
+			s.drawline()
+		}},
+		"digit-argument": {"add a digit to the numeric argument for the next command", (*session).digitArgument},
+		"edit-and-execute-command": {"edit the line in the editor named by VISUAL or EDITOR, then enter it", func(s *session, ch byte) {
+			s.editAndExecute()
+		}},
+		"end-of-line": {"move to the end of the line", func(s *session, ch byte) {
+			s.buf.End()
+			s.drawCursor()
+		}},
+		"forward-char": {"move forward a character", func(s *session, ch byte) {
+			if s.buf.Forward() {
+				s.drawCursor()
+			}
+		}},
+		"forward-word": {"move forward a word", func(s *session, ch byte) {
+			s.buf.WordForward()
+			s.drawCursor()
+		}},
+		"interrupt": {"discard the line and reset the handler", func(s *session, ch byte) {
+			s.flush()
+			if s.editing {
+				s.putString("\n")
+				s.editErr, s.exit = ErrInterrupted, true
+				return
+			}
+			s.putString(s.messages.Interrupt + "\n")
+			discarded := strings.Join(append(append(append(s.partial, s.heredocLines...), s.pasted...), s.buf.String()), "\n")
+			s.partial = s.partial[:0]
+			s.pasted = s.pasted[:0]
+			s.heredocLines = s.heredocLines[:0]
+			s.heredocEnd = ""
+			s.buf.Clear()
+			s.buf.leaveHistory() //the next line starts afresh, not from the entry recalled
+			resetHandler(s.handler, ResetInterrupt, discarded)
+			s.prompt = s.mainPrompt()
+			s.showPrompt()
+		}},
+		"just-one-space": {"replace the spaces around the cursor with one", func(s *session, ch byte) {
+			s.buf.JustOneSpace()
+			s.drawline()
+		}},
+		"kill-line": {"kill to the end of the line", func(s *session, ch byte) {
+			s.buf.KillToEnd()
+			s.drawline()
+			s.killed()
+		}},
+		"kill-word": {"kill the word after the cursor", func(s *session, ch byte) {
+			s.buf.WordDelete()
+			s.drawline()
+			s.killed()
+		}},
+		"magic-space": {"expand the history references before the cursor, and insert a space", (*session).magicSpace},
+		"history-search-backward": {"recall the previous line, pinned lines first, that starts with the text before the cursor", func(s *session, ch byte) {
+			s.searchHistoryPrefix(false)
+		}},
+		"history-search-forward": {"recall the next line that starts with the text before the cursor", func(s *session, ch byte) {
+			s.searchHistoryPrefix(true)
+		}},
+		"next-history": {"recall the next line in history", func(s *session, ch byte) {
+			s.buf.NextInHistory()
+			s.drawline()
+		}},
+		"paste-clipboard": {"insert the contents of the clipboard", func(s *session, ch byte) {
+			s.pasteClipboard()
+		}},
+		"pin-line": {"pin the line to the history, or unpin it", (*session).pinLine},
+		"prefix":   {"start a chord", func(s *session, ch byte) {}},
+		"previous-history": {"recall the previous line in history", func(s *session, ch byte) {
+			s.buf.PrevInHistory()
+			s.drawline()
+		}},
+		"redraw": {"redraw the line below itself", func(s *session, ch byte) {
+			s.flush()
+			s.putString("\n")
+			s.drawn = false
+			s.drawline()
+		}},
+		"revert-line": {"undo all the changes made to the line", func(s *session, ch byte) {
+			s.buf.Revert()
+			s.drawline()
+		}},
+		"self-insert": {"insert the character typed", (*session).selfInsert},
+		"toggle-recording": {"pause or resume recording the session", func(s *session, ch byte) {
+			s.toggleRecording()
+		}},
+		"yank": {"insert the text last killed", func(s *session, ch byte) {
+			s.buf.Yank()
+			s.drawline()
+		}},
+		"yank-last-arg": {"insert the last word of the previous line; again for the line before", (*session).yankLastArg},
+		"yank-nth-arg":  {"insert the first argument of the previous line, or the word the numeric argument says", (*session).yankNthArg},
+	}
+}
+
+// dispatch runs the command bound to the key ch, taken together with any
+// keys of a chord read before it.
+func (s *session) dispatch(ch byte) {
+	if s.hintLines > 0 && s.menu == nil {
+		s.clearHints()
+	}
+	key := chordName(s.chord, ch)
+	name, ok := s.keymap[key]
+	if !ok && s.chord == "" && ch >= SPACE && ch != DELETE {
+		name, ok = "self-insert", true
+	}
+	if s.preedit != "" && (name != "self-insert" || ch < utf8.RuneSelf) {
+		s.commitPreedit()
+	}
+	s.chord = ""
+	s.thisCommand = name
+	if s.menu != nil && name != "prefix" && s.menuKey(key, name) {
+		s.thisCommand = "complete"
+	} else if cmd, found := commands[name]; ok && found {
+		if name == "prefix" {
+			s.chord = key
+		}
+		n := 1
+		if s.hasArg && repeatable[name] {
+			n = s.arg
+		}
+		for i := 0; i < n; i++ {
+			cmd.run(s, ch)
+		}
+	} else {
+		s.thisCommand = "undefined"
+		s.bell()
+	}
+	if s.trace != nil {
+		s.traceKey(key, s.thisCommand)
+	}
+	if s.metrics != nil && s.chord == "" {
+		s.metrics.Keystroke()
+	}
+	if s.chord == "" {
+		//the keys of a chord don't count as commands of their own
+		s.lastCommand = s.thisCommand
+		if name != "digit-argument" {
+			s.hasArg, s.arg = false, 0
+		}
+	}
+	if s.chord != "" && !s.noKeyHints && !s.accessible && len(s.pending) == 0 {
+		s.pause(hintDelay)
+		if len(s.pending) == 0 {
+			s.showHints()
+		}
+	}
+}
+
+// selfInsert inserts the character typed, or the text composed, if it is
+// not ASCII, and flashes the bracket it matches, if it is a closing bracket,
+// or completes the word it ends, if it is one of the handler's completion
+// triggers.
+func (s *session) selfInsert(ch byte) {
+	if ch >= utf8.RuneSelf {
+		s.insertComposed(ch)
+		return
+	}
+	if ch < SPACE || ch == DELETE || s.buf.fit(1) == 0 {
+		s.bell()
+		return
+	}
+	if ch == SPACE && (s.expandAliasInPlace() || s.expandAbbreviation()) {
+		s.buf.Insert(ch)
+		s.drawline()
+		return
+	}
+	s.buf.Insert(ch)
+	if !s.echoInsert(ch) {
+		s.drawline()
+	}
+	if s.triggers != "" && strings.IndexByte(s.triggers, ch) >= 0 && len(s.pending) == 0 && s.heredocEnd == "" {
+		s.completeWord(true)
+		s.thisCommand = "complete" //so that TAB lists the candidates
+		return
+	}
+	match := matching(ch)
+	if match != 0 && s.matchFlash != MatchNone && !s.lowBandwidth && !s.accessible && len(s.pending) == 0 {
+		s.highlightMatch(match, ch)
+	}
+}
+
+// complete asks the handler to complete the word before the cursor. Pressed
+// a second time, it lists the candidates.
+func (s *session) complete(ch byte) {
+	if _, ok := s.peekChar(); ok {
+		//pasting text in, don't do the tab completion
+		s.thisCommand = ""
+	} else if s.lastCommand == "complete" {
+		if s.completions != nil {
+			s.listOptions(s.completions)
+		}
+		s.bell()
+	} else {
+		s.completeWord(false)
+	}
+}
+
+// completeWord asks the handler to complete the word before the cursor, and
+// inserts what the candidates have in common. Completion that the user
+// didn't ask for, auto, doesn't ring the bell.
+func (s *session) completeWord(auto bool) {
+	buf := s.buf
+	start := time.Now()
+	addendum, opt := s.handler.Complete(string(buf.buf[0:buf.cursor]))
+	if s.metrics != nil {
+		s.metrics.Complete(time.Since(start))
+	}
+	if len(addendum) > 0 {
+		buf.InsertString(addendum)
+	}
+	s.completions = nil
+	if len(opt) == 1 && !s.bareCompletion {
+		buf.Insert(' ')
+	} else if len(opt) > 1 {
+		s.completions = opt
+	}
+	s.drawline()
+	switch {
+	case len(opt) == 1:
+	case len(opt) == 0:
+		if !auto && !(s.suggest && s.didYouMean()) {
+			s.bell()
+		}
+	case s.accessible || s.ambiguous == AmbiguousList:
+		s.listOptions(opt)
+	case s.ambiguous == AmbiguousMenu:
+		s.openMenu(opt)
+	case s.ambiguous == AmbiguousCount:
+		s.showBelow([]string{fmt.Sprintf(s.messages.Candidates, len(opt))})
+	case s.ambiguous == AmbiguousBell && !auto:
+		s.bell()
+	}
+}
+
+// chordName returns the name of the key ch, read after the chord prefix.
+func chordName(prefix string, ch byte) string {
+	return chordStem(prefix) + keyName(ch)
+}
+
+// chordStem returns what the names of the keys that continue the chord
+// prefix start with.
+func chordStem(prefix string) string {
+	switch prefix {
+	case "":
+		return ""
+	case "ESC":
+		return "M-"
+	case "M-[":
+		return "ESC [ "
+	}
+	return prefix + " "
+}
+
+// keyName returns the name of the key ch on its own.
+func keyName(ch byte) string {
+	switch {
+	case ch == TAB:
+		return "TAB"
+	case ch == RETURN:
+		return "RET"
+	case ch == ESCAPE:
+		return "ESC"
+	case ch == DELETE:
+		return "DEL"
+	case ch == SPACE:
+		return "SPC"
+	case ch >= CTRL_A && ch <= 26:
+		return fmt.Sprintf("C-%c", ch+'a'-1)
+	case ch < SPACE:
+		return fmt.Sprintf("C-%c", ch+'@')
+	case ch < DELETE:
+		return string(rune(ch))
+	}
+	return fmt.Sprintf("\\x%02x", ch)
+}
+
+// describeBindings returns a line for each of the session's key bindings,
+// showing the key, its command, and what the command does, fitted to the
+// terminal's width.
+func (s *session) describeBindings() []string {
+	keys := make([]string, 0, len(s.keymap))
+	keyWidth, nameWidth := 0, 0
+	for key, name := range s.keymap {
+		keys = append(keys, key)
+		if len(key) > keyWidth {
+			keyWidth = len(key)
+		}
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+	sort.Strings(keys)
+	cols := s.cols
+	if cols <= 0 {
+		cols = 80
+	}
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		name := s.keymap[key]
+		line := fmt.Sprintf("%-*s  %-*s  %s", keyWidth, key, nameWidth, name, commands[name].doc)
+		line = strings.TrimRight(line, " ")
+		if len(line) >= cols {
+			line = line[:cols-1]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
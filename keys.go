@@ -0,0 +1,137 @@
+package repl
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// KeyEvent is a key read from a terminal.
+type KeyEvent struct {
+	// Name is the key's name, as key bindings name it: "a", "C-a", "M-f",
+	// "RET", "SPC", or "ESC [ A" for the up arrow.
+	Name string
+	// Rune is the character typed, for a key that types one, or 0.
+	Rune rune
+	// Bytes are what the terminal sent for the key.
+	Bytes []byte
+}
+
+// KeyReader decodes the bytes a terminal sends into keys, recognizing the
+// escape sequences of function keys and the UTF-8 encoding of characters,
+// for programs that build interactive modes of their own, such as menus or
+// pagers. An ESC on its own at the end of what the terminal sent is the
+// Escape key; followed by anything else, it is a Meta key or the start of a
+// sequence.
+type KeyReader struct {
+	r       io.Reader
+	buf     [256]byte
+	pending []byte
+}
+
+// NewKeyReader returns a KeyReader that reads keys from r, normally a
+// Terminal in ModeCbreak or ModeRaw.
+func NewKeyReader(r io.Reader) *KeyReader {
+	return &KeyReader{r: r}
+}
+
+// ReadKey returns the next key. A key cut short by the end of the input, or
+// an error, is returned a byte at a time.
+func (kr *KeyReader) ReadKey() (KeyEvent, error) {
+	for {
+		if len(kr.pending) > 0 {
+			if n, name, r := decodeKey(kr.pending); n > 0 {
+				return kr.take(n, name, r), nil
+			}
+		}
+		m, err := kr.r.Read(kr.buf[:])
+		kr.pending = append(kr.pending, kr.buf[:m]...)
+		if err != nil {
+			if len(kr.pending) == 0 {
+				return KeyEvent{}, err
+			}
+			if n, name, r := decodeKey(kr.pending); n > 0 {
+				return kr.take(n, name, r), nil
+			}
+			return kr.take(1, keyName(kr.pending[0]), 0), nil
+		}
+	}
+}
+
+// take returns the key made of the first n pending bytes, and removes them.
+func (kr *KeyReader) take(n int, name string, r rune) KeyEvent {
+	key := KeyEvent{Name: name, Rune: r, Bytes: append([]byte(nil), kr.pending[:n]...)}
+	kr.pending = kr.pending[n:]
+	return key
+}
+
+// decodeKey decodes the key at the start of b, returning how many bytes it
+// took, its name, and the character it types, if any. It returns 0 bytes if
+// b holds only the start of a key.
+func decodeKey(b []byte) (int, string, rune) {
+	ch := b[0]
+	switch {
+	case ch == ESCAPE:
+		if len(b) == 1 {
+			return 1, "ESC", 0
+		}
+		if b[1] == '[' || b[1] == 'O' {
+			return decodeSequence(b)
+		}
+		if b[1] >= utf8.RuneSelf {
+			n, name, r := decodeKey(b[1:])
+			if n == 0 {
+				return 0, "", 0
+			}
+			return n + 1, "M-" + name, r
+		}
+		return 2, "M-" + keyName(b[1]), 0
+	case ch < SPACE || ch == DELETE:
+		return 1, keyName(ch), 0
+	case ch < utf8.RuneSelf:
+		return 1, keyName(ch), rune(ch)
+	}
+	if !utf8.FullRune(b) {
+		return 0, "", 0
+	}
+	r, n := utf8.DecodeRune(b)
+	if r == utf8.RuneError && n == 1 {
+		return 1, keyName(ch), 0
+	}
+	return n, string(r), r
+}
+
+// decodeSequence decodes an escape sequence at the start of b: ESC [, then
+// parameter bytes, then a final byte, or ESC O and a single byte.
+func decodeSequence(b []byte) (int, string, rune) {
+	var sb strings.Builder
+	sb.WriteString("ESC ")
+	sb.WriteByte(b[1])
+	if b[1] == 'O' {
+		if len(b) < 3 {
+			return 0, "", 0
+		}
+		sb.WriteString(" " + keyName(b[2]))
+		return 3, sb.String(), 0
+	}
+	for i := 2; i < len(b); i++ {
+		sb.WriteString(" " + keyName(b[i]))
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return i + 1, sb.String(), 0
+		}
+		if b[i] < 0x20 || b[i] > 0x3f {
+			//not a well formed sequence, so stop at what came before it
+			return i, chordNameFrom(b[:i]), 0
+		}
+	}
+	return 0, "", 0
+}
+
+// chordNameFrom names the keys in b taken as a chord.
+func chordNameFrom(b []byte) string {
+	names := make([]string, len(b))
+	for i, ch := range b {
+		names[i] = keyName(ch)
+	}
+	return strings.Join(names, " ")
+}
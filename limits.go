@@ -0,0 +1,66 @@
+package repl
+
+import (
+	"time"
+)
+
+// WithMaxLineLength limits the line being edited to n bytes. Keys that would
+// make it longer ring the bell, and text inserted in other ways is cut short;
+// a line-mode network client that sends a longer line has the whole line
+// rejected. The default, 0, is no limit. Together with WithInputRate and
+// WithHistorySize, this keeps a hostile network client from exhausting the
+// server's memory or CPU.
+func WithMaxLineLength(n int) Option {
+	return func(s *session) {
+		s.maxLineLength = n
+	}
+}
+
+// WithInputRate limits how fast the session reads input from a network
+// client to bytesPerSecond, allowing bursts of up to burst bytes, such as a
+// paste. A client that sends faster is simply read more slowly, so that the
+// excess backs up in the connection. The default, 0, is no limit.
+func WithInputRate(bytesPerSecond int, burst int) Option {
+	return func(s *session) {
+		s.inputRate = bytesPerSecond
+		s.inputBurst = burst
+	}
+}
+
+// rateLimiter is a token bucket, refilled at rate tokens a second up to
+// burst.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n tokens from the bucket, first sleeping until there are enough.
+func (rl *rateLimiter) wait(n int) {
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	if rl.tokens < 0 {
+		time.Sleep(time.Duration(-rl.tokens / rl.rate * float64(time.Second)))
+	}
+}
+
+// fit returns how many of n more bytes the line has room for.
+func (lb *lineBuf) fit(n int) int {
+	if lb.maxLength > 0 && lb.length+n > lb.maxLength {
+		return clamp(lb.maxLength-lb.length, 0, n)
+	}
+	return n
+}
@@ -0,0 +1,291 @@
+// Package liner offers the commonly used part of the API of
+// github.com/peterh/liner on top of the repl package's line editor, so that
+// a program written for liner can switch to this one by changing its import
+// path:
+//
+//	line := liner.NewLiner()
+//	defer line.Close()
+//	line.SetCtrlCAborts(true)
+//	line.SetCompleter(func(line string) []string { ... })
+//	if f, err := os.Open(historyPath); err == nil {
+//		line.ReadHistory(f)
+//		f.Close()
+//	}
+//	for {
+//		input, err := line.Prompt("> ")
+//		if err != nil { // io.EOF or liner.ErrPromptAborted
+//			break
+//		}
+//		line.AppendHistory(input)
+//		...
+//	}
+//
+// Lines are edited with the keys, history and rendering of a repl session.
+package liner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/boynton/repl"
+)
+
+// ErrPromptAborted is returned by Prompt when the user presses Ctrl-C, if
+// SetCtrlCAborts has been turned on.
+var ErrPromptAborted = errors.New("prompt aborted")
+
+// HistoryLimit is the most history entries kept.
+const HistoryLimit = 1000
+
+// Completer returns the lines that line could be completed to.
+type Completer func(line string) []string
+
+// WordCompleter returns the completions of the word at pos in line, which
+// would replace everything between head and tail.
+type WordCompleter func(line string, pos int) (head string, completions []string, tail string)
+
+// State reads lines, as liner's State does.
+type State struct {
+	mu          sync.Mutex
+	t           repl.Terminal
+	editor      *repl.LineEditor
+	history     []string
+	ctrlCAborts bool
+}
+
+// NewLiner returns a State that reads lines from the process's terminal, or
+// from its standard input if that isn't a terminal.
+func NewLiner() *State {
+	return NewLinerOptions()
+}
+
+// NewLinerOptions is NewLiner with options, for features of the repl
+// package that liner has no methods for.
+func NewLinerOptions(options ...repl.Option) *State {
+	t := terminal(os.Stdin, os.Stdout)
+	//liner completes to exactly the candidate, with no space after it
+	options = append([]repl.Option{repl.WithCompletionSpace(false)}, options...)
+	return &State{t: t, editor: repl.NewLineEditor(t, options...)}
+}
+
+// Close finishes with the State. The terminal is already back in its normal
+// mode whenever Prompt isn't running, so there is nothing to undo.
+func (s *State) Close() error {
+	return nil
+}
+
+// Prompt shows p and returns the line entered. It returns io.EOF if the user
+// presses Ctrl-D on an empty line or the input ends. Ctrl-C returns
+// ErrPromptAborted if SetCtrlCAborts has been turned on, and otherwise starts
+// the line afresh.
+func (s *State) Prompt(p string) (string, error) {
+	return s.PromptWithSuggestion(p, "", -1)
+}
+
+// PromptWithSuggestion is Prompt with text as the line to start editing
+// from. The cursor starts at the end of it, whatever pos is.
+func (s *State) PromptWithSuggestion(p string, text string, pos int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		//the editor adds every line to its history, which is left to AppendHistory
+		s.editor.History = s.history
+		line, err := s.editor.Edit(p, text)
+		if err == repl.ErrInterrupted {
+			if s.ctrlCAborts {
+				return "", ErrPromptAborted
+			}
+			continue
+		}
+		return line, err
+	}
+}
+
+// PasswordPrompt shows p and returns what is typed up to the end of the line,
+// without echoing it.
+func (s *State) PasswordPrompt(p string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.t.SetMode(repl.ModeCbreak); err != nil {
+		return "", err
+	}
+	defer s.t.SetMode(repl.ModeNormal)
+	io.WriteString(s.t, p)
+	var password []byte
+	var b [1]byte //read a byte at a time, so as to take nothing past the line
+	for {
+		if _, err := s.t.Read(b[:]); err != nil {
+			io.WriteString(s.t, "\n")
+			return "", err
+		}
+		switch ch := b[0]; ch {
+		case '\r', '\n':
+			io.WriteString(s.t, "\n")
+			return string(password), nil
+		case 3: //Ctrl-C
+			if s.ctrlCAborts {
+				io.WriteString(s.t, "\n")
+				return "", ErrPromptAborted
+			}
+			password = password[:0]
+		case 4: //Ctrl-D
+			if len(password) == 0 {
+				io.WriteString(s.t, "\n")
+				return "", io.EOF
+			}
+		case 8, 127: //backspace
+			if len(password) > 0 {
+				_, n := utf8.DecodeLastRune(password)
+				password = password[:len(password)-n]
+			}
+		default:
+			if ch >= ' ' {
+				password = append(password, ch)
+			}
+		}
+	}
+}
+
+// SetCtrlCAborts sets whether Ctrl-C makes Prompt return ErrPromptAborted,
+// rather than starting the line afresh.
+func (s *State) SetCtrlCAborts(aborts bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctrlCAborts = aborts
+}
+
+// SetCompleter sets f to complete lines when TAB is pressed.
+func (s *State) SetCompleter(f Completer) {
+	if f == nil {
+		s.SetWordCompleter(nil)
+		return
+	}
+	s.SetWordCompleter(func(line string, pos int) (string, []string, string) {
+		return "", f(line[:pos]), line[pos:]
+	})
+}
+
+// SetWordCompleter sets f to complete the word at the cursor when TAB is
+// pressed.
+func (s *State) SetWordCompleter(f WordCompleter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f == nil {
+		s.editor.Complete = nil
+		return
+	}
+	s.editor.Complete = func(line string) (string, []string) {
+		head, completions, _ := f(line, len(line))
+		if !strings.HasPrefix(line, head) {
+			return "", nil
+		}
+		word := line[len(head):]
+		var candidates []string
+		for _, c := range completions {
+			if strings.HasPrefix(c, word) {
+				candidates = append(candidates, c)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", nil
+		}
+		common := candidates[0]
+		for _, c := range candidates[1:] {
+			n := 0
+			for n < len(common) && n < len(c) && common[n] == c[n] {
+				n++
+			}
+			common = common[:n]
+		}
+		for !utf8.ValidString(common) {
+			common = common[:len(common)-1] //a character cut in two
+		}
+		return common[len(word):], candidates
+	}
+}
+
+// AppendHistory adds item to the end of history, unless it is the same as
+// the last entry.
+func (s *State) AppendHistory(item string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.history); n > 0 && s.history[n-1] == item {
+		return
+	}
+	history := append(s.history[:len(s.history):len(s.history)], item)
+	if n := len(history) - HistoryLimit; n > 0 {
+		history = history[n:]
+	}
+	s.history = history
+}
+
+// ClearHistory forgets the history.
+func (s *State) ClearHistory() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = nil
+}
+
+// ReadHistory adds the lines read from r to history, and returns how many
+// there were.
+func (s *State) ReadHistory(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		s.AppendHistory(scanner.Text())
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// WriteHistory writes the history to w, a line at a time, and returns how
+// many lines it wrote.
+func (s *State) WriteHistory(w io.Writer) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, item := range s.history {
+		if _, err := fmt.Fprintln(w, item); err != nil {
+			return i, err
+		}
+	}
+	return len(s.history), nil
+}
+
+// terminal returns the Terminal made of in and out: the tty itself if in is
+// one, or a plain stream otherwise.
+func terminal(in *os.File, out io.Writer) repl.Terminal {
+	if info, err := in.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return repl.TTY(in)
+	}
+	return &streamTerminal{in: in, out: out}
+}
+
+// streamTerminal is a Terminal made of a reader and a writer, with no size or
+// modes.
+type streamTerminal struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (st *streamTerminal) Read(p []byte) (int, error) {
+	return st.in.Read(p)
+}
+
+func (st *streamTerminal) Write(p []byte) (int, error) {
+	return st.out.Write(p)
+}
+
+func (st *streamTerminal) Size() (int, int) {
+	return 0, 0
+}
+
+func (st *streamTerminal) SetMode(mode repl.TerminalMode) error {
+	return nil
+}
@@ -0,0 +1,195 @@
+package repl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Describer may be implemented by a handler that can say what its completion
+// candidates are, such as a function's signature followed by its docstring.
+// Describe is called for a candidate when it is selected in the completion
+// menu, and not before, so it may be slow to work out; what it returns is
+// shown beside the menu, or below it if the terminal is too narrow, and ""
+// shows nothing.
+type Describer interface {
+	Describe(candidate string) string
+}
+
+// maxMenuLines is the most candidates the completion menu shows at once,
+// and maxPreviewLines the most lines of description shown below it.
+const maxMenuLines = 8
+const maxPreviewLines = 4
+
+// minPreviewWidth is the narrowest a description beside the menu can be
+// before it goes below the menu instead.
+const minPreviewWidth = 24
+
+// completionMenu is the state of the completion menu while it is open.
+type completionMenu struct {
+	candidates []string
+	selected   int //the candidate selected, or -1 before one is
+	first      int //the first candidate shown
+	inserted   int //how much of the line before the cursor the selection inserted
+	described  map[string]string
+}
+
+// openMenu shows the completion candidates in a menu below the line, which
+// TAB, C-n and the down arrow move through, inserting each in turn, and
+// S-TAB, C-p and the up arrow move back through. RET keeps the candidate
+// selected, C-g takes it out again, and any other key keeps it and then does
+// what it usually does.
+func (s *session) openMenu(candidates []string) {
+	s.menu = &completionMenu{candidates: candidates, selected: -1, described: make(map[string]string)}
+	s.drawMenu()
+}
+
+// menuKey acts on the key, named key and bound to the command name, while
+// the menu is open, and returns true if the menu took it. A key it doesn't
+// take closes the menu first.
+func (s *session) menuKey(key string, name string) bool {
+	m := s.menu
+	switch {
+	case name == "complete" || name == "next-history" || key == "C-n":
+		s.selectCandidate((m.selected + 1) % len(m.candidates))
+	case name == "previous-history" || key == "C-p" || key == "ESC [ Z":
+		s.selectCandidate((m.selected + len(m.candidates) - 1) % len(m.candidates))
+	case name == "accept-line" && m.selected >= 0:
+		s.closeMenu(true)
+	case key == "C-g":
+		s.closeMenu(false)
+	default:
+		s.closeMenu(true)
+		return false
+	}
+	return true
+}
+
+// selectCandidate puts candidate n into the line in place of the one
+// selected before it, and redraws the menu.
+func (s *session) selectCandidate(n int) {
+	m := s.menu
+	s.uninsert(m.inserted)
+	c := m.candidates[n]
+	before := string(s.buf.buf[:s.buf.cursor])
+	k := len(c)
+	for k > 0 && !strings.HasSuffix(before, c[:k]) {
+		k--
+	}
+	cursor := s.buf.cursor
+	s.buf.InsertString(c[k:])
+	m.inserted = s.buf.cursor - cursor
+	m.selected = n
+	s.drawMenu()
+}
+
+// uninsert takes out the n bytes before the cursor.
+func (s *session) uninsert(n int) {
+	s.buf.cursor -= n
+	s.buf.length -= n
+}
+
+// closeMenu erases the menu, keeping the candidate selected in the line
+// unless keep is false.
+func (s *session) closeMenu(keep bool) {
+	if !keep {
+		s.uninsert(s.menu.inserted)
+	}
+	s.menu = nil
+	if s.hintLines > 0 {
+		s.clearHints()
+	} else {
+		s.drawline()
+	}
+}
+
+// description returns what the handler says about the selected candidate,
+// asking it only the first time.
+func (s *session) description() string {
+	m := s.menu
+	d, ok := s.handler.(Describer)
+	if !ok || m.selected < 0 {
+		return ""
+	}
+	c := m.candidates[m.selected]
+	text, seen := m.described[c]
+	if !seen {
+		text = strings.TrimRight(d.Describe(c), "\n")
+		m.described[c] = text
+	}
+	return text
+}
+
+// drawMenu draws the menu below the line being edited, with the selected
+// candidate in reverse video and its description beside or below it,
+// leaving the cursor where it was.
+func (s *session) drawMenu() {
+	m := s.menu
+	cols := s.cols
+	if cols <= 0 {
+		cols = 80
+	}
+	rows := clamp(len(m.candidates), 0, maxMenuLines)
+	if m.selected >= 0 && m.selected < m.first {
+		m.first = m.selected
+	} else if m.selected >= m.first+rows {
+		m.first = m.selected - rows + 1
+	}
+	width := 0
+	for _, c := range m.candidates {
+		width = clamp(displayWidth([]byte(c)), width, cols-1)
+	}
+	var preview []string
+	if text := s.description(); text != "" {
+		preview = strings.Split(text, "\n")
+	}
+	side := cols-1-width-3 >= minPreviewWidth
+	if !side && len(preview) > maxPreviewLines {
+		preview = preview[:maxPreviewLines]
+	}
+	shown := rows
+	if side {
+		shown = clamp(len(preview), rows, maxMenuLines)
+	}
+	s.flush()
+	frame := s.frame[:0]
+	lines := 0
+	for i := m.first; i < m.first+shown; i++ {
+		frame = append(frame, RETURN, NEWLINE)
+		if i >= m.first+rows {
+			frame = append(frame, strings.Repeat(" ", width)...)
+		} else {
+			c := truncateStyled([]byte(m.candidates[i]), width)
+			cell := append(c[:len(c):len(c)], strings.Repeat(" ", width-displayWidth(c))...)
+			if i == m.selected {
+				//reverse video, even with color off, which is all that shows the selection
+				frame = append(append(append(frame, "\033[7m"...), cell...), sgrReset...)
+			} else {
+				frame = s.appendStyled(frame, s.theme.Completion, cell)
+			}
+		}
+		if side && i-m.first < len(preview) {
+			frame = append(frame, "   "...)
+			frame = s.appendStyled(frame, s.theme.Hint, truncateStyled([]byte(preview[i-m.first]), cols-1-width-3))
+		}
+		frame = append(frame, ESCAPE, '[', 'K')
+		lines++
+	}
+	if !side {
+		for _, line := range preview {
+			frame = append(frame, RETURN, NEWLINE)
+			frame = s.appendStyled(frame, s.theme.Hint, truncateStyled([]byte(line), cols-1))
+			frame = append(frame, ESCAPE, '[', 'K')
+			lines++
+		}
+	}
+	frame = append(frame, ESCAPE, '[', 'J', ESCAPE, '[')
+	frame = strconv.AppendInt(frame, int64(lines), 10)
+	frame = append(frame, 'A')
+	s.frame = frame
+	s.putChars(frame)
+	s.hintLines = lines
+	s.hintShown++ //so that no hints timed out earlier clear the menu
+	s.damagedBelow()
+	s.drawn = false
+	s.drawline()
+}
@@ -0,0 +1,65 @@
+package repl
+
+// Messages holds the text that sessions show the user, so that it can be
+// translated or rebranded. To change some of it, copy DefaultMessages and set
+// the fields to be changed. Fields that are shown with a value hold a fmt
+// verb for it.
+type Messages struct {
+	Interrupt        string //shown when Ctrl-C interrupts the line being edited
+	Error            string //put before the message of an error from Eval
+	SessionPrompt    string //asks for a session name, on a detachable Server
+	SessionAttached  string //reports resuming the detached session %s
+	SessionStarted   string //reports starting the new session %s
+	Candidates       string //reports that completion found %d candidates
+	EOF              string //says %d more Ctrl-Ds will end the session
+	IdleExit         string //shown when a session ends for being idle
+	Locked           string //shown when a session locks for being idle
+	Passphrase       string //asks for the passphrase to unlock the session
+	LineTooLong      string //reports rejecting a line over the maximum length
+	Password         string //asks a network client for its password
+	AuthFailed       string //reports that a network client's credentials were refused
+	RecordingPaused  string //reports pausing the recording of the session
+	RecordingResumed string //reports resuming the recording of the session
+	Interrupting     string //shown when Ctrl-C interrupts an evaluation
+	Abandoned        string //shown when a second Ctrl-C abandons an evaluation
+	DidYouMean       string //suggests the words %s when completion finds nothing
+	Pinned           string //reports pinning the line to the history
+	Unpinned         string //reports unpinning the line from the history
+	NoHistoryEntry   string //reports that there is no history entry %s
+	RerunOfRerun     string //reports refusing to run history entry %s, itself a :r, again
+	HistoryUsage     string //shows how to use :history
+}
+
+// DefaultMessages are the messages sessions use unless told otherwise.
+var DefaultMessages = Messages{
+	Interrupt:        "*** Interrupt",
+	Error:            "***",
+	SessionPrompt:    "Session: ",
+	SessionAttached:  "[attached to session %s]",
+	SessionStarted:   "[session %s]",
+	Candidates:       "[%d candidates]",
+	EOF:              "[%d more Ctrl-D to exit]",
+	IdleExit:         "[idle timeout]",
+	Locked:           "[session locked]",
+	Passphrase:       "Passphrase: ",
+	LineTooLong:      "*** Line too long",
+	Password:         "Password: ",
+	AuthFailed:       "Authentication failed",
+	RecordingPaused:  "[recording paused]",
+	RecordingResumed: "[recording resumed]",
+	Interrupting:     "[interrupting; Ctrl-C again to abandon]",
+	Abandoned:        "*** Abandoned; the handler's state may be inconsistent",
+	DidYouMean:       "[did you mean %s?]",
+	Pinned:           "[pinned]",
+	Unpinned:         "[unpinned]",
+	NoHistoryEntry:   "*** No history entry %s",
+	RerunOfRerun:     "*** History entry %s is a :r itself",
+	HistoryUsage:     "usage: :history [pinned | pin N | unpin N]",
+}
+
+// WithMessages sets the text the session shows the user.
+func WithMessages(m Messages) Option {
+	return func(s *session) {
+		s.messages = m
+	}
+}
@@ -0,0 +1,67 @@
+package repl
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives measurements from sessions, for long running programs that
+// want to export them to a monitoring system. Each session calls its Metrics
+// from its own goroutine, so one shared by several sessions must be safe for
+// concurrent use.
+type Metrics interface {
+	// Keystroke is called for every key the session acts on. An escape
+	// sequence counts as one key.
+	Keystroke()
+	// Eval is called after the handler evaluates a line, with the time it
+	// took and the error it returned.
+	Eval(d time.Duration, err error)
+	// Complete is called after the handler offers completions, with the time
+	// it took.
+	Complete(d time.Duration)
+	// Redraw is called whenever the session updates the line being edited,
+	// with the number of bytes it wrote to the terminal to do so.
+	Redraw(bytes int)
+}
+
+// WithMetrics reports the session's activity to m.
+func WithMetrics(m Metrics) Option {
+	return func(s *session) {
+		s.metrics = m
+	}
+}
+
+// ExpvarMetrics is a Metrics that keeps running totals in an expvar.Map, so
+// that they are served with the program's other variables at /debug/vars.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics publishes a map of session metrics as the expvar variable
+// name, holding the totals keystrokes, evals, eval_errors, eval_seconds,
+// completions, completion_seconds, and redraw_bytes. Like expvar.Publish, it
+// panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+func (m *ExpvarMetrics) Keystroke() {
+	m.vars.Add("keystrokes", 1)
+}
+
+func (m *ExpvarMetrics) Eval(d time.Duration, err error) {
+	m.vars.Add("evals", 1)
+	if err != nil {
+		m.vars.Add("eval_errors", 1)
+	}
+	m.vars.AddFloat("eval_seconds", d.Seconds())
+}
+
+func (m *ExpvarMetrics) Complete(d time.Duration) {
+	m.vars.Add("completions", 1)
+	m.vars.AddFloat("completion_seconds", d.Seconds())
+}
+
+func (m *ExpvarMetrics) Redraw(bytes int) {
+	m.vars.Add("redraw_bytes", int64(bytes))
+}
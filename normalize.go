@@ -0,0 +1,67 @@
+package repl
+
+import (
+	"strings"
+)
+
+// WithNormalizer sets a function that text is normalized with as it goes
+// into the line, by pasting or from the clipboard, and that each line is
+// normalized with when it is entered, so that the same identifier entered in
+// different ways, such as with dead keys or from macOS, which decomposes
+// accented letters, reaches the handler and history the same. Pass
+// norm.NFC.String, from golang.org/x/text/unicode/norm, for full NFC, or
+// another form, or ComposeAccents for the common case without that
+// dependency. By default text is left as it is.
+func WithNormalizer(fn func(string) string) Option {
+	return func(s *session) {
+		s.normalize = fn
+	}
+}
+
+// normalized returns text normalized as the session has been told to.
+func (s *session) normalized(text string) string {
+	if s.normalize == nil {
+		return text
+	}
+	return s.normalize(text)
+}
+
+// accents maps each combining accent to the letters it composes with, and
+// the letters they compose to, rune for rune.
+var accents = map[rune][2]string{
+	0x0300: {"AEIOUaeiou", "ÀÈÌÒÙàèìòù"},                             //grave accent
+	0x0301: {"AEIOUYaeiouyCcLlNnRrSsZz", "ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹź"}, //acute accent
+	0x0302: {"AEIOUaeiouCcGgHhJjSsWwYy", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷ"}, //circumflex accent
+	0x0303: {"ANOanoIiUu", "ÃÑÕãñõĨĩŨũ"},                             //tilde
+	0x0304: {"AaEeIiOoUu", "ĀāĒēĪīŌōŪū"},                             //macron
+	0x0306: {"AaEeGgIiOoUu", "ĂăĔĕĞğĬĭŎŏŬŭ"},                         //breve
+	0x0307: {"CcEeGgIZz", "ĊċĖėĠġİŻż"},                               //dot above
+	0x0308: {"AEIOUaeiouyY", "ÄËÏÖÜäëïöüÿŸ"},                         //diaeresis
+	0x030a: {"AaUu", "ÅåŮů"},                                         //ring above
+	0x030b: {"OoUu", "ŐőŰű"},                                         //double acute accent
+	0x030c: {"CcDdEeLlNnRrSsTtZz", "ČčĎďĚěĽľŇňŘřŠšŤťŽž"},             //caron
+	0x0327: {"CcGgKkLlNnRrSsTt", "ÇçĢģĶķĻļŅņŖŗŞşŢţ"},                 //cedilla
+	0x0328: {"AaEeIiUu", "ĄąĘęĮįŲų"},                                 //ogonek
+}
+
+// ComposeAccents composes the Latin letters followed by combining accents in
+// text into the accented letters of Latin-1 and Latin Extended-A, as NFC
+// does, leaving everything else alone. It is a subset of NFC that covers the
+// Western and Central European languages.
+func ComposeAccents(text string) string {
+	if strings.IndexFunc(text, func(r rune) bool { return r >= 0x300 && r <= 0x36f }) < 0 {
+		return text
+	}
+	runes := []rune(text)
+	out := runes[:0]
+	for _, r := range runes {
+		if pair, ok := accents[r]; ok && len(out) > 0 {
+			if i := strings.IndexRune(pair[0], out[len(out)-1]); i >= 0 {
+				out[len(out)-1] = []rune(pair[1])[i]
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
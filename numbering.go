@@ -0,0 +1,54 @@
+package repl
+
+import "fmt"
+
+// Numbered may be implemented by a handler that numbers its evaluations, as
+// IPython does, to keep its results in variables that match the numbers the
+// session shows. SetNumber is called before each Eval of a complete entry
+// with its number, counting from 1.
+type Numbered interface {
+	SetNumber(n int)
+}
+
+// WithNumbering numbers the entries evaluated, showing the number before the
+// prompt in the format in, and before each result in the format out, with a
+// %d for the number, such as "In [%d]: " and "Out[%d]: ". Either may be
+// empty. Numbers go up with each complete entry evaluated, including those
+// that fail, but not empty lines.
+func WithNumbering(in, out string) Option {
+	return func(s *session) {
+		s.inFormat, s.outFormat = in, out
+	}
+}
+
+// mainPrompt returns the prompt for a new entry: the handler's, after the
+// entry's number, if the session numbers them. The styles of a handler's
+// StyledPrompt are kept for drawing it.
+func (s *session) mainPrompt() string {
+	var prompt StyledText
+	if sp, ok := s.handler.(StyledPrompter); ok {
+		prompt = sp.StyledPrompt()
+	} else {
+		prompt = Text(s.handler.Prompt())
+	}
+	if s.inFormat != "" {
+		prompt = Text(fmt.Sprintf(s.inFormat, s.number())).Append(prompt)
+	}
+	s.promptText = prompt
+	s.promptPlain = prompt.Plain()
+	return s.promptPlain
+}
+
+// number returns the number of the entry being edited.
+func (s *session) number() int {
+	return s.evaluated + 1
+}
+
+// numberedResult returns the result of the entry just evaluated, after its
+// number, if the session numbers them.
+func (s *session) numberedResult(result string) string {
+	if s.outFormat == "" {
+		return result
+	}
+	return fmt.Sprintf(s.outFormat, s.evaluated) + result
+}
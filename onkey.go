@@ -0,0 +1,83 @@
+package repl
+
+import (
+	"bytes"
+	"time"
+)
+
+// WithOnKey sets fn to see each key typed, decoded as a KeyReader decodes
+// it, before the session acts on it, so that a program can watch what is
+// typed, for a tutorial overlay say, or add shortcuts of its own without
+// taking over the key loop. fn returns the key to act on and true, or false
+// to swallow the key. To act on another key in its place, fn returns that key
+// with the Bytes the terminal would send for it, which may be several keys,
+// or a whole line to be typed in; they are read as if typed, without going
+// through fn again. A key returned with no Bytes is swallowed. The keys of a
+// chord, such as the C-x and the p of C-x p, are passed one at a time, while
+// replies from the terminal, and the text of a bracketed paste, aren't
+// passed at all.
+func WithOnKey(fn func(key KeyEvent) (KeyEvent, bool)) Option {
+	return func(s *session) {
+		s.onKey = fn
+	}
+}
+
+// keySequenceWait is how long to wait for the rest of a key whose bytes
+// arrive apart, such as an escape sequence split across reads.
+const keySequenceWait = 10 * time.Millisecond
+
+// hookKey passes the key that starts with ch to the OnKey function, and
+// returns true if the session should go on to act on ch. The rest of the
+// key's bytes are left pending, to be read without going through the
+// function again.
+func (s *session) hookKey(ch byte) bool {
+	n, name, r := s.decodePending(ch)
+	for (n == 0 || ch == ESCAPE && len(s.pending) == 0) && s.awaitInput(keySequenceWait) {
+		n, name, r = s.decodePending(ch)
+	}
+	if n == 0 {
+		n, name, r = 1, keyName(ch), 0
+	}
+	key := KeyEvent{Name: name, Rune: r, Bytes: append([]byte(nil), s.keyDecode[:n]...)}
+	act, ok := s.onKey(key)
+	switch {
+	case !ok:
+		s.pending = s.pending[n-1:]
+		return false
+	case bytes.Equal(act.Bytes, key.Bytes):
+		s.keyBytes = n - 1
+		return true
+	}
+	s.pending = append(append([]byte(nil), act.Bytes...), s.pending[n-1:]...)
+	s.keyBytes = len(act.Bytes)
+	return false
+}
+
+// maxKeyLength is the most bytes of pending input looked at to decode a key,
+// so that a long paste isn't copied again for every key in it.
+const maxKeyLength = 64
+
+// decodePending decodes the key that starts with ch and goes on with the
+// pending input.
+func (s *session) decodePending(ch byte) (int, string, rune) {
+	s.keyDecode = append(append(s.keyDecode[:0], ch), s.pending[:clamp(len(s.pending), 0, maxKeyLength)]...)
+	return decodeKey(s.keyDecode)
+}
+
+// awaitInput adds the next input to arrive to what is pending, and returns
+// false if none arrives within the given time.
+func (s *session) awaitInput(d time.Duration) bool {
+	if s.input == nil {
+		return false
+	}
+	select {
+	case chunk := <-s.input:
+		s.pending = append(s.pending, chunk...)
+		if s.trace != nil && !s.secret {
+			s.traceInput(chunk)
+		}
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,183 @@
+package repl
+
+import (
+	"time"
+)
+
+// Option configures a session. Options are passed to REPL and the other
+// functions that start sessions.
+type Option func(*session)
+
+// Bandwidth says how hard the REPL should work to keep down the amount it
+// writes to the terminal.
+type Bandwidth int
+
+const (
+	// BandwidthAuto starts out assuming a fast terminal, and switches to low
+	// bandwidth mode if writes to the terminal turn out to be slow.
+	BandwidthAuto Bandwidth = iota
+	// BandwidthHigh assumes a fast terminal, such as a local one.
+	BandwidthHigh
+	// BandwidthLow is for slow, high latency connections. The matching bracket
+	// is not flashed, insertions and deletions in the middle of the line use
+	// the terminal's own insert and delete operations instead of rewriting the
+	// rest of the line, and cursor motion is sent once typing pauses rather
+	// than after every keystroke. Characters typed at the end of the line are
+	// still echoed immediately.
+	BandwidthLow
+)
+
+// slowWrite is how long a write to the terminal may take before it counts
+// towards switching to low bandwidth mode, and slowWrites is how many such
+// writes it takes.
+const slowWrite = 50 * time.Millisecond
+const slowWrites = 3
+
+// cursorDelay is how long low bandwidth mode waits for more keystrokes before
+// sending a cursor motion.
+const cursorDelay = 30 * time.Millisecond
+
+// WithBandwidth sets the bandwidth mode of the session. The default is
+// BandwidthAuto.
+func WithBandwidth(b Bandwidth) Option {
+	return func(s *session) {
+		s.bandwidth = b
+		s.lowBandwidth = b == BandwidthLow
+	}
+}
+
+// WithColor turns the colors of results and errors on or off. By default the
+// console session uses color unless the environment says otherwise (see
+// REPL), and other sessions always do.
+func WithColor(on bool) Option {
+	return func(s *session) {
+		s.noColor = !on
+	}
+}
+
+// MatchFlash says how the session shows the bracket that matches one just
+// typed.
+type MatchFlash int
+
+const (
+	// MatchJump moves the cursor to the matching bracket for a moment, and
+	// shows the bracket in the theme's Match style, if it has one.
+	MatchJump MatchFlash = iota
+	// MatchColor leaves the cursor where it is, hidden, and shows the
+	// matching bracket in the theme's Match style, or in reverse video if the
+	// theme has none.
+	MatchColor
+	// MatchNone does not show the matching bracket, and does not ring the
+	// bell for a bracket that has no match.
+	MatchNone
+)
+
+// matchFlashTime is how long the matching bracket is shown by default.
+const matchFlashTime = 500 * time.Millisecond
+
+// WithMatchFlash sets how the bracket matching one just typed is shown, and
+// for how long, d, or for the default of half a second if d is 0. The
+// bracket is shown only until the next key is typed, however long d is. The
+// default is MatchJump.
+func WithMatchFlash(m MatchFlash, d time.Duration) Option {
+	return func(s *session) {
+		s.matchFlash = m
+		s.matchTime = d
+	}
+}
+
+// Ambiguous says what the session does when completion finds more than one
+// candidate. Pressing TAB again lists them, whatever the setting.
+type Ambiguous int
+
+const (
+	// AmbiguousBell rings the bell.
+	AmbiguousBell Ambiguous = iota
+	// AmbiguousSilent does nothing.
+	AmbiguousSilent
+	// AmbiguousCount shows how many candidates there are below the line,
+	// until the next key is typed.
+	AmbiguousCount
+	// AmbiguousList lists the candidates straight away.
+	AmbiguousList
+	// AmbiguousMenu shows the candidates in a menu below the line, which TAB
+	// and the arrow keys move through, with a description of the one
+	// selected, if the handler is a Describer.
+	AmbiguousMenu
+)
+
+// WithAmbiguousCompletion sets what the session does when completion is
+// ambiguous. The default is AmbiguousBell, except in accessible mode, where
+// the candidates are always listed.
+func WithAmbiguousCompletion(a Ambiguous) Option {
+	return func(s *session) {
+		s.ambiguous = a
+	}
+}
+
+// WithCompletionSpace sets whether a space goes after a word that completion
+// finishes, when there is only one candidate, as it does by default.
+// Handlers whose candidates end in a space of their own, or in a character
+// such as a slash that more is typed after, can turn it off.
+func WithCompletionSpace(on bool) Option {
+	return func(s *session) {
+		s.bareCompletion = !on
+	}
+}
+
+// WithEOF sets how many Ctrl-Ds in a row, on an empty line, it takes to end
+// the session. Until the last, each shows a message saying how many more are
+// needed. 0 stops Ctrl-D ending the session at all, which suits consoles that
+// should only be left deliberately. The default is 1.
+func WithEOF(n int) Option {
+	return func(s *session) {
+		s.noEOF = n <= 0
+		s.eofPresses = n
+	}
+}
+
+// Bell says how the session gets the user's attention, when a key can't do
+// anything or completion is ambiguous.
+type Bell int
+
+const (
+	// BellAudible sends the terminal's bell character.
+	BellAudible Bell = iota
+	// BellVisual flashes the screen in reverse video instead.
+	BellVisual
+	// BellNone does nothing.
+	BellNone
+)
+
+// visualBellTime is how long the visual bell shows the screen in reverse
+// video.
+const visualBellTime = 100 * time.Millisecond
+
+// WithBell sets the kind of bell the session uses. The default is
+// BellAudible.
+func WithBell(b Bell) Option {
+	return func(s *session) {
+		s.bellStyle = b
+		s.bellFunc = nil
+	}
+}
+
+// WithBellFunc calls fn in place of the bell, so the program can alert the
+// user in a way of its own. fn is called on the session's goroutine.
+func WithBellFunc(fn func()) Option {
+	return func(s *session) {
+		s.bellFunc = fn
+	}
+}
+
+// WithAccessibleMode makes the session usable with screen readers and braille
+// displays. Edits are echoed linearly, without moving the cursor back into the
+// line: a change anywhere but at the end of the line prints the whole line
+// again on a new line. Completion candidates are announced one per line as
+// soon as completion is ambiguous, and there are no transient highlights or
+// other effects that depend on timing.
+func WithAccessibleMode(on bool) Option {
+	return func(s *session) {
+		s.accessible = on
+	}
+}
@@ -0,0 +1,69 @@
+package repl
+
+import (
+	"os"
+	"strings"
+)
+
+// Passthrough is the terminal multiplexer, if any, that the session's output
+// goes through on its way to the terminal. Multiplexers keep escape
+// sequences they don't understand, or won't pass on, from the terminal
+// outside, so the session wraps those, such as the OSC 52 that sets the
+// clipboard, in the multiplexer's passthrough sequence.
+type Passthrough int
+
+const (
+	// PassthroughNone writes escape sequences as they are.
+	PassthroughNone Passthrough = iota
+	// PassthroughTmux wraps them for tmux, which passes them on only with its
+	// allow-passthrough option turned on.
+	PassthroughTmux
+	// PassthroughScreen wraps them for GNU screen, in pieces short enough
+	// for it to pass on.
+	PassthroughScreen
+)
+
+// screenChunk is how much of a sequence goes in each piece passed through
+// screen, which has a limit on the length of its DCS strings.
+const screenChunk = 76
+
+// WithPassthrough says which multiplexer, if any, the session's output goes
+// through. REPL and Wrap set it from the environment: TMUX for tmux, and STY,
+// or a TERM starting with screen, for screen. Network sessions have it off.
+func WithPassthrough(p Passthrough) Option {
+	return func(s *session) {
+		s.passthrough = p
+	}
+}
+
+// envPassthrough returns the multiplexer the environment says the program
+// is running in.
+func envPassthrough() Passthrough {
+	switch {
+	case os.Getenv("TMUX") != "":
+		return PassthroughTmux
+	case os.Getenv("STY") != "" || strings.HasPrefix(os.Getenv("TERM"), "screen"):
+		return PassthroughScreen
+	}
+	return PassthroughNone
+}
+
+// putExtended writes seq, an escape sequence that a multiplexer wouldn't
+// pass on to the terminal by itself, wrapped in the multiplexer's
+// passthrough.
+func (s *session) putExtended(seq string) {
+	switch s.passthrough {
+	case PassthroughTmux:
+		s.putString("\033Ptmux;" + strings.ReplaceAll(seq, "\033", "\033\033") + "\033\\")
+	case PassthroughScreen:
+		var sb strings.Builder
+		for len(seq) > 0 {
+			n := clamp(len(seq), 0, screenChunk)
+			sb.WriteString("\033P" + seq[:n] + "\033\\")
+			seq = seq[n:]
+		}
+		s.putString(sb.String())
+	default:
+		s.putString(seq)
+	}
+}
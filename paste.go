@@ -0,0 +1,188 @@
+package repl
+
+import (
+	"strings"
+)
+
+// PasteMode says what the session does with text pasted into the terminal
+// that has more than one line.
+type PasteMode int
+
+const (
+	// PasteBlock shows the pasted lines and evaluates them as one string,
+	// newlines and all, when the last line is entered. If the text ends with
+	// a newline, it is entered straight away; otherwise the last line can be
+	// edited first.
+	PasteBlock PasteMode = iota
+	// PasteLines evaluates the pasted lines one at a time, as if each had
+	// been entered in turn, but without a prompt before each.
+	PasteLines
+	// PasteTyped treats pasted text as if it had been typed, so that each
+	// newline enters the line before it.
+	PasteTyped
+)
+
+// maxPaste is the most pasted text the session takes in at once.
+const maxPaste = 1 << 20
+
+// WithPasteMode sets what the session does with text pasted in, which it
+// recognizes in terminals that support bracketed paste. The default is
+// PasteBlock.
+func WithPasteMode(m PasteMode) Option {
+	return func(s *session) {
+		s.pasteMode = m
+	}
+}
+
+// startPaste asks the terminal to bracket pasted text, so it can be told
+// apart from typing, and returns the function that stops it.
+func (s *session) startPaste() func() {
+	if s.pasteMode == PasteTyped {
+		return func() {}
+	}
+	s.putString("\033[?2004h")
+	return func() {
+		s.putString("\033[?2004l")
+	}
+}
+
+// bracketedPaste reads a sequence that starts ESC [ 2, which is the start of
+// pasted text if it goes on 0 0 ~, and takes the text in, up to the sequence
+// that ends it, ESC [ 2 0 1 ~. Other keys, such as Insert, ring the bell.
+// If the input ends part way through, whatever was read is dropped, and the
+// end is left for run to find on its next read.
+func (s *session) bracketedPaste(ch byte) {
+	seq := []byte{}
+	for len(seq) < 4 {
+		ch, ok := s.getChar()
+		if !ok {
+			return
+		}
+		seq = append(seq, ch)
+		if ch < '0' || ch > '9' {
+			break
+		}
+	}
+	if string(seq) != "00~" {
+		s.thisCommand = "undefined"
+		s.bell()
+		return
+	}
+	var text []byte
+	for {
+		ch, ok := s.getChar()
+		if !ok {
+			return
+		}
+		text = append(text, ch)
+		if ch == '~' && len(text) >= 6 && string(text[len(text)-6:]) == "\033[201~" {
+			text = text[:len(text)-6]
+			break
+		}
+		if len(text) > maxPaste+6 {
+			//drop the excess, but keep looking for the end
+			copy(text[maxPaste:], text[maxPaste+1:])
+			text = text[:maxPaste+6]
+		}
+	}
+	s.paste(string(text))
+}
+
+// pasteText cleans up pasted text, turning CR and CRLF line endings into
+// newlines and leaving out other control characters. Tabs become spaces,
+// which is how the line shows them.
+func pasteText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if r < SPACE && r != NEWLINE || r == DELETE {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// paste puts pasted text into the line at the cursor. Text of several lines
+// is shown, and entered, as the paste mode says.
+func (s *session) paste(text string) {
+	buf := s.buf
+	text = s.normalized(pasteText(text))
+	if !strings.Contains(text, "\n") || s.pasteMode == PasteTyped {
+		buf.InsertString(strings.ReplaceAll(text, "\n", " "))
+		s.drawline()
+		return
+	}
+	lines := strings.Split(text, "\n")
+	after := string(buf.buf[buf.cursor+buf.gap() : len(buf.buf)])
+	buf.length = buf.cursor
+	buf.InsertString(lines[0])
+	last := lines[len(lines)-1] + after
+	if s.pasteMode == PasteLines {
+		s.pasteLines(lines[1:len(lines)-1], last)
+		return
+	}
+	s.pasted = append(s.pasted, buf.String())
+	s.drawline()
+	s.flush()
+	for _, line := range lines[1 : len(lines)-1] {
+		s.putString("\n" + line)
+		s.pasted = append(s.pasted, line)
+	}
+	s.putString("\n")
+	buf.Clear()
+	buf.InsertString(last)
+	s.prompt = ""
+	s.drawn = false
+	if last == "" {
+		commands["accept-line"].run(s, RETURN)
+	} else {
+		s.drawline()
+	}
+}
+
+// pasteLines enters the line being edited, and then each of lines, leaving
+// last to be edited. The lines are shown without prompts before them.
+func (s *session) pasteLines(lines []string, last string) {
+	buf := s.buf
+	s.pasting = true
+	s.drawline()
+	commands["accept-line"].run(s, RETURN)
+	for _, line := range lines {
+		prompt := s.prompt
+		s.prompt = ""
+		buf.InsertString(line)
+		s.drawline()
+		s.flush()
+		s.prompt = prompt
+		commands["accept-line"].run(s, RETURN)
+	}
+	s.pasting = false
+	s.drawn = false
+	s.showPrompt()
+	buf.InsertString(last)
+	s.drawline()
+}
+
+// acceptedLines adds the lines entered to history, and returns them as one
+// string: the line being edited, after any pasted ahead of it.
+func (s *session) acceptedLines() string {
+	buf := s.buf
+	line := s.normalized(buf.String())
+	buf.Clear()
+	if len(s.pasted) == 0 {
+		buf.AddToHistory(line)
+		return line
+	}
+	for _, l := range s.pasted {
+		buf.AddToHistory(l)
+	}
+	lines := s.pasted
+	if line != "" {
+		buf.AddToHistory(line)
+		lines = append(lines, line)
+	}
+	text := strings.Join(lines, "\n")
+	s.pasted = s.pasted[:0]
+	return text
+}
@@ -0,0 +1,212 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Pinned history entries are favorites the user keeps at hand, such as long
+// operational commands. They are kept apart from the rest of the history, so
+// that they are never trimmed by WithHistorySize, and in a file of their own
+// next to the history file, if there is one, named like it with ".pinned"
+// added. Prefix search finds them before anything else.
+
+// pinnedPath returns the path of the file that keeps the pinned entries of
+// the history in use, or "" if they are kept only in memory.
+func (s *session) pinnedPath() string {
+	if path := s.historyPath(); path != "" {
+		return path + ".pinned"
+	}
+	return ""
+}
+
+// loadPinned reads the pinned entries from their file.
+func (s *session) loadPinned() {
+	f, err := os.Open(s.pinnedPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			s.buf.pinned = append(s.buf.pinned, line)
+		}
+	}
+}
+
+// savePinned writes the pinned entries to their file, replacing what was
+// there.
+func (s *session) savePinned() {
+	path := s.pinnedPath()
+	if path == "" {
+		return
+	}
+	tmp := path + ".tmp"
+	data := strings.Join(s.buf.pinned, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// pinIndex returns where line is among the pinned entries, or -1 if it
+// isn't pinned.
+func (lb *lineBuf) pinIndex(line string) int {
+	for i, p := range lb.pinned {
+		if p == line {
+			return i
+		}
+	}
+	return -1
+}
+
+// pin pins line, or unpins it if it is pinned already, and reports whether
+// it is now pinned.
+func (s *session) pin(line string) bool {
+	lb := s.buf
+	pinned := false
+	if i := lb.pinIndex(line); i >= 0 {
+		lb.pinned = append(lb.pinned[:i], lb.pinned[i+1:]...)
+	} else {
+		lb.pinned = append(lb.pinned, line)
+		pinned = true
+	}
+	s.savePinned()
+	return pinned
+}
+
+// pinLine pins the line being edited, or unpins it if it is pinned already.
+func (s *session) pinLine(ch byte) {
+	line := s.buf.String()
+	if line == "" {
+		s.bell()
+		return
+	}
+	msg := s.messages.Unpinned
+	if s.pin(line) {
+		msg = s.messages.Pinned
+	}
+	s.showBelow([]string{msg})
+}
+
+// historyCommand carries out :history. Without arguments it lists the
+// history, numbered, with pinned entries marked; "pin N" and "unpin N" pin
+// and unpin entry N, and "pinned" lists the pinned entries.
+func (s *session) historyCommand(args string) {
+	lb := s.buf
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		for i, line := range lb.allHistory() {
+			mark := " "
+			if lb.pinIndex(line) >= 0 {
+				mark = "*"
+			}
+			s.putString(fmt.Sprintf("%5d%s %s\n", i+1, mark, line))
+		}
+	case len(fields) == 1 && fields[0] == "pinned":
+		for _, line := range lb.pinned {
+			s.putString("* " + line + "\n")
+		}
+	case len(fields) == 2 && (fields[0] == "pin" || fields[0] == "unpin"):
+		entries := lb.allHistory()
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 || n > len(entries) {
+			s.putString(fmt.Sprintf(s.messages.NoHistoryEntry+"\n", fields[1]))
+			return
+		}
+		line := entries[n-1]
+		if (lb.pinIndex(line) >= 0) != (fields[0] == "unpin") {
+			return //already as asked
+		}
+		s.pin(line)
+	default:
+		s.putString(s.messages.HistoryUsage + "\n")
+	}
+}
+
+// historySearch is the state of a prefix search through history, kept
+// between presses of the search keys.
+type historySearch struct {
+	prefix  string   //the text before the cursor when the search began
+	line    string   //the line as it was then
+	found   []string //the matches found so far, pinned entries first
+	at      int      //the match shown, or -1 for the line as it was
+	pinned  int      //how many pinned entries have been looked at
+	back    int      //how far back in history the search has looked
+	checked bool     //whether there are no more matches to find
+}
+
+// searchHistoryPrefix recalls the previous entry, pinned entries first, that
+// starts with the text before the cursor, or with the next one if forward
+// is set. Pressed again, it carries on from the last match.
+func (s *session) searchHistoryPrefix(forward bool) {
+	lb := s.buf
+	h := &s.histSearch
+	if s.lastCommand != "history-search-backward" && s.lastCommand != "history-search-forward" {
+		*h = historySearch{prefix: string(lb.buf[:lb.cursor]), line: lb.String(), at: -1}
+	}
+	if forward {
+		if h.at < 0 {
+			s.bell()
+			return
+		}
+		h.at--
+	} else {
+		if h.at+1 == len(h.found) && !h.nextMatch(lb) {
+			s.bell()
+			return
+		}
+		h.at++
+	}
+	line := h.line
+	if h.at >= 0 {
+		line = h.found[h.at]
+	}
+	lb.Clear()
+	lb.InsertString(line)
+	lb.moveTo(len(h.prefix))
+	s.drawline()
+}
+
+// nextMatch finds the next older entry that starts with the prefix, looking
+// through the pinned entries, most recently pinned first, then the history,
+// and reports whether there was one.
+func (h *historySearch) nextMatch(lb *lineBuf) bool {
+	seen := func(line string) bool {
+		for _, f := range h.found {
+			if f == line {
+				return true
+			}
+		}
+		return line == h.line
+	}
+	for !h.checked {
+		var line string
+		if h.pinned < len(lb.pinned) {
+			h.pinned++
+			line = lb.pinned[len(lb.pinned)-h.pinned]
+		} else {
+			entry, ok := lb.historyEntry(h.back + 1)
+			if !ok {
+				h.checked = true
+				break
+			}
+			h.back++
+			line = entry
+		}
+		if strings.HasPrefix(line, h.prefix) && !seen(line) {
+			h.found = append(h.found, line)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package repl
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Pipe runs a REPL session for handler in a new goroutine, attached to an
+// in-process pipe instead of a terminal, and returns the other end of the
+// pipe. Bytes written to it reach the session as keystrokes, and reading it
+// returns everything the session draws, with newlines sent as CRLF as a
+// terminal would receive them. The session's output is buffered, so it never
+// waits for the caller to read it.
+//
+// Pipe lets host applications embed the interactive loop and lets tests drive
+// it programmatically. Closing the returned pipe ends the session as if its
+// terminal had gone away; once the session has ended, reads return io.EOF and
+// writes fail.
+func Pipe(handler ReplHandler, options ...Option) io.ReadWriteCloser {
+	pr, pw := io.Pipe()
+	p := &replPipe{in: pw}
+	p.cond = sync.NewCond(&p.mu)
+	s := newSession(handler, crlfWriter{pipeOutput{p}}, options...)
+	go s.feed(pr)
+	go func() {
+		s.run()
+		pr.CloseWithError(io.ErrClosedPipe)
+		p.mu.Lock()
+		p.closed = true
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}()
+	return p
+}
+
+// replPipe is the caller's end of a Pipe.
+type replPipe struct {
+	in     *io.PipeWriter
+	mu     sync.Mutex
+	cond   *sync.Cond
+	out    bytes.Buffer
+	closed bool
+}
+
+func (p *replPipe) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.out.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.out.Read(b)
+}
+
+func (p *replPipe) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+func (p *replPipe) Close() error {
+	return p.in.Close()
+}
+
+// pipeOutput is the session's end of a Pipe.
+type pipeOutput struct {
+	p *replPipe
+}
+
+func (po pipeOutput) Write(b []byte) (int, error) {
+	po.p.mu.Lock()
+	defer po.p.mu.Unlock()
+	po.p.out.Write(b)
+	po.p.cond.Broadcast()
+	return len(b), nil
+}
@@ -0,0 +1,230 @@
+package repl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProgressReporter may be implemented by a handler that reports the progress
+// of long evaluations, or of work going on in the background. SetProgress is
+// called before the handler's Start, and again whenever a detached session is
+// resumed, with the Progress to report to.
+type ProgressReporter interface {
+	SetProgress(p *Progress)
+}
+
+// Progress shows how the handler's work is going: a progress bar while it
+// evaluates a line, and any spinners it has started, whether it is evaluating
+// or the user is editing. During an evaluation they are drawn at the cursor,
+// and erased before the result is printed; otherwise spinners are drawn below
+// the line being edited. Its methods, and those of its spinners, may be
+// called from any goroutine.
+type Progress struct {
+	mu       sync.Mutex
+	s        *session
+	active   bool   //whether Eval is running
+	bar      string //the progress bar, or empty
+	spinners []*Spinner
+	shown    int  //how many lines are drawn at the cursor, during Eval
+	posted   bool //whether drawing the spinners below the line has been posted to the session
+	below    int  //how many lines are drawn below the line, used only by the session's goroutine
+	cols     int  //the width of the screen, copied from the session's goroutine
+}
+
+// Update shows label and a bar filled to done out of total. It only has an
+// effect during a call to Eval.
+func (p *Progress) Update(label string, done int64, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.active {
+		return
+	}
+	cols := p.cols
+	if cols <= 0 {
+		cols = 80
+	}
+	bar := progressBar(label, done, total, cols-1)
+	if bar == p.bar {
+		return
+	}
+	p.bar = bar
+	p.draw()
+}
+
+// Clear erases the progress bar.
+func (p *Progress) Clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bar = ""
+	if p.active {
+		p.draw()
+	}
+}
+
+// lines returns the lines of the status area: a line for each running
+// spinner, followed by the progress bar, if asked for.
+func (p *Progress) lines(withBar bool) []string {
+	var lines []string
+	for _, sp := range p.spinners {
+		lines = append(lines, sp.line())
+	}
+	if withBar && p.bar != "" {
+		lines = append(lines, p.bar)
+	}
+	return lines
+}
+
+// draw redraws the status area at the cursor, during Eval, leaving the
+// cursor at its start.
+func (p *Progress) draw() {
+	s := p.s
+	lines := p.lines(true)
+	var sb strings.Builder
+	sb.WriteString("\r")
+	for i, line := range lines {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(s.style(s.theme.Hint) + line + s.resetStyle() + "\033[K")
+	}
+	if len(lines) < p.shown {
+		if len(lines) > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\033[J")
+		if len(lines) > 0 {
+			sb.WriteString("\033[A")
+		}
+	}
+	if len(lines) > 1 {
+		sb.WriteString("\033[" + strconv.Itoa(len(lines)-1) + "A")
+	}
+	//the handler's own output is in the Output style, so return to it
+	sb.WriteString("\r" + s.style(s.theme.Output))
+	s.putString(sb.String())
+	p.shown = len(lines)
+}
+
+// begin lets the progress bar be shown, while the handler evaluates a line.
+func (p *Progress) begin() {
+	p.mu.Lock()
+	p.active = true
+	p.cols = p.s.cols
+	p.mu.Unlock()
+	p.s.eraseBelow()
+	p.mu.Lock()
+	if len(p.spinners) > 0 {
+		p.draw()
+	}
+	p.mu.Unlock()
+}
+
+// end erases the status area, and stops the progress bar being shown again
+// until the next evaluation.
+func (p *Progress) end() {
+	p.mu.Lock()
+	p.active = false
+	p.bar = ""
+	shown := p.shown
+	if shown > 0 {
+		p.s.putString("\r\033[J")
+		p.shown = 0
+	}
+	p.mu.Unlock()
+	if shown > 0 {
+		p.s.damagedBelow()
+	}
+}
+
+// resized notes the new width of the screen, for a progress bar drawn from
+// another goroutine.
+func (p *Progress) resized(cols int) {
+	p.mu.Lock()
+	p.cols = cols
+	p.mu.Unlock()
+}
+
+// changed redraws the status area after a spinner has moved on.
+func (p *Progress) changed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active {
+		p.draw()
+	} else if !p.posted {
+		p.posted = true
+		go p.s.post(func() {
+			p.mu.Lock()
+			p.posted = false
+			p.mu.Unlock()
+			p.s.drawBelow()
+		})
+	}
+}
+
+// drawBelow draws the running spinners on the lines below the line being
+// edited, leaving the cursor where it was. Hints take precedence.
+func (s *session) drawBelow() {
+	p := s.progress
+	if p == nil || s.hintLines > 0 || s.accessible {
+		return
+	}
+	p.mu.Lock()
+	lines := p.lines(false)
+	p.mu.Unlock()
+	if len(lines) == 0 && p.below == 0 {
+		return
+	}
+	s.flush()
+	var sb strings.Builder
+	//make sure there are rows below the line before saving the cursor
+	sb.WriteString(strings.Repeat("\033D", len(lines)) + strings.Repeat("\033M", len(lines)) + "\0337")
+	for _, line := range lines {
+		sb.WriteString("\033E" + s.style(s.theme.Hint) + line + s.resetStyle() + "\033[K")
+	}
+	for i := len(lines); i < p.below; i++ {
+		sb.WriteString("\033E\033[2K")
+	}
+	sb.WriteString("\0338")
+	s.putString(sb.String())
+	p.below = len(lines)
+}
+
+// eraseBelow erases the spinners drawn below the line being edited.
+func (s *session) eraseBelow() {
+	p := s.progress
+	if p == nil || p.below == 0 {
+		return
+	}
+	s.putString("\0337" + strings.Repeat("\033E\033[2K", p.below) + "\0338")
+	p.below = 0
+}
+
+// damagedBelow notes that everything below the line being edited has been
+// erased, including the toolbar, so that they are drawn again.
+func (s *session) damagedBelow() {
+	if s.progress != nil {
+		s.progress.below = 0
+	}
+	s.toolbarCols = -1
+}
+
+// progressBar returns label followed by a bar showing done out of total, and
+// the percentage, in width columns.
+func progressBar(label string, done int64, total int64, width int) string {
+	percent := 0
+	if total > 0 {
+		percent = clamp(int(done*100/total), 0, 100)
+	}
+	suffix := fmt.Sprintf(" %3d%%", percent)
+	room := width - len(label) - len(suffix) - 3
+	if room < 10 {
+		return label + suffix
+	}
+	if room > 40 {
+		room = 40
+	}
+	filled := room * percent / 100
+	return label + " [" + strings.Repeat("#", filled) + strings.Repeat(".", room-filled) + "]" + suffix
+}
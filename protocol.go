@@ -0,0 +1,131 @@
+package repl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// ProtocolRequest is a request sent to a protocol server, one JSON object per
+// line. Op is one of "eval", "complete", "interrupt", or "describe"; Code is
+// the expression to evaluate or the text to complete. The ID is copied into
+// the response so that clients can match them up.
+type ProtocolRequest struct {
+	ID   string `json:"id,omitempty"`
+	Op   string `json:"op"`
+	Code string `json:"code,omitempty"`
+}
+
+// ProtocolResponse is the reply to a ProtocolRequest. Status includes "done"
+// when the request has been handled, "error" if it failed, and "need-input"
+// when an eval is incomplete and the handler is waiting for more code.
+type ProtocolResponse struct {
+	ID         string          `json:"id,omitempty"`
+	Value      string          `json:"value,omitempty"`
+	Err        string          `json:"err,omitempty"`
+	Completion string          `json:"completion,omitempty"`
+	Candidates []string        `json:"candidates,omitempty"`
+	Ops        map[string]bool `json:"ops,omitempty"`
+	Status     []string        `json:"status"`
+}
+
+// ServeProtocol accepts connections on l and answers requests from programs,
+// such as editors and IDEs, rather than people. It drives the given handler,
+// which may be the same one serving a terminal session, so that tools can
+// evaluate code in the running program alongside the user. Requests from all
+// connections are handled one at a time, and answered in the order each
+// connection sent them; to also serialize them with a terminal session, pass
+// a handler from the same SessionManager. An interrupt request is passed on
+// to the handler's Interrupt as soon as it arrives, if the handler is an
+// Interrupter, so that it can cut short an eval that is running, and then
+// resets the handler in its turn.
+func ServeProtocol(l net.Listener, handler ReplHandler) error {
+	var mu sync.Mutex
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveProtocolConn(conn, handler, &mu)
+	}
+}
+
+// maxProtocolQueue is the most requests a connection can send ahead of the
+// one being handled before the server stops reading them.
+const maxProtocolQueue = 64
+
+// serveProtocolConn reads the requests sent on conn, while they are handled
+// in turn on a goroutine of their own, so that an interrupt can be read, and
+// passed on, while an eval runs.
+func serveProtocolConn(conn net.Conn, handler ReplHandler, mu *sync.Mutex) {
+	queue := make(chan *ProtocolRequest, maxProtocolQueue)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer conn.Close()
+		enc := json.NewEncoder(conn)
+		for req := range queue {
+			mu.Lock()
+			resp := handleProtocolRequest(handler, req)
+			mu.Unlock()
+			if err := enc.Encode(resp); err != nil {
+				return
+			}
+		}
+	}()
+	defer close(queue)
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		req := &ProtocolRequest{}
+		if err := dec.Decode(req); err != nil {
+			return
+		}
+		if req.Op == "interrupt" {
+			interruptHandler(handler)
+		}
+		select {
+		case queue <- req:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// interruptHandler asks h to cut short the Eval it is running, if it is an
+// Interrupter. It is called without the lock that the Eval holds.
+func interruptHandler(h ReplHandler) {
+	if i, ok := h.(Interrupter); ok {
+		i.Interrupt()
+	}
+}
+
+func handleProtocolRequest(handler ReplHandler, req *ProtocolRequest) *ProtocolResponse {
+	resp := &ProtocolResponse{ID: req.ID}
+	switch req.Op {
+	case "eval":
+		result, more, err := handler.Eval(req.Code)
+		if err != nil {
+			resp.Err = err.Error()
+			resp.Status = []string{"error", "done"}
+		} else if more {
+			resp.Status = []string{"need-input", "done"}
+		} else {
+			resp.Value = result
+			resp.Status = []string{"done"}
+		}
+	case "complete":
+		resp.Completion, resp.Candidates = handler.Complete(req.Code)
+		resp.Status = []string{"done"}
+	case "interrupt":
+		resetHandler(handler, ResetInterrupt, "")
+		resp.Status = []string{"done"}
+	case "describe":
+		resp.Ops = map[string]bool{"eval": true, "complete": true, "interrupt": true, "describe": true}
+		resp.Status = []string{"done"}
+	default:
+		resp.Err = "Unknown op: " + req.Op
+		resp.Status = []string{"error", "unknown-op", "done"}
+	}
+	return resp
+}
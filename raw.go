@@ -0,0 +1,243 @@
+package repl
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// RawTaker may be implemented by a handler that takes the terminal over now
+// and then, to run a pager, an editor or a game of its own. SetRaw is called
+// before the handler's Start, and again whenever a detached session is
+// resumed, with the Raw to take it over with.
+type RawTaker interface {
+	SetRaw(r *Raw)
+}
+
+// Raw hands the session's terminal over to its handler during Eval.
+type Raw struct {
+	mu         sync.Mutex
+	s          *session
+	evaluating chan struct{} //closed when Eval returns, nil when it isn't running
+	take       chan rawTakeover
+}
+
+// rawTakeover is a call to Take or RunInteractive, waiting for the session
+// to hand the terminal over to fn.
+type rawTakeover struct {
+	fn   func(s *session) error
+	done chan error
+}
+
+// errNotEvaluating is returned by Take when the handler isn't in Eval.
+var errNotEvaluating = errors.New("Terminal can only be taken during Eval")
+
+// errNoTTY is returned by RunInteractive on a session without a tty.
+var errNoTTY = errors.New("Session has no terminal to run a program on")
+
+// Take hands the terminal over to fn, and returns what fn returns. It is to
+// be called during Eval, on Eval's goroutine or another. While fn runs, the
+// terminal is in raw mode and the session stops reading keys, leaving
+// everything typed, Ctrl-C included, to fn, which reads the bytes the
+// terminal sends from rw, starting with any typed ahead, and writes to rw
+// what it draws, exactly as it is. The session's toolbar, bracketed paste
+// and cursor shape are taken down first. Once fn returns they are put back,
+// the terminal returns to the mode the session edits in, and anything typed
+// that fn didn't read is kept for the session. Sessions with no terminal of
+// their own, such as network sessions, hand over their input and output
+// without a change of mode.
+func (r *Raw) Take(fn func(rw io.ReadWriter) error) error {
+	return r.takeover(func(s *session) error {
+		if s.terminal != nil {
+			s.terminal.SetMode(ModeRaw)
+			defer s.terminal.SetMode(ModeCbreak)
+		}
+		rw := &rawIO{s: s, in: s.pending}
+		s.pending = nil
+		defer func() { s.pending = append(s.pending, rw.in...) }()
+		return fn(rw)
+	})
+}
+
+// RunInteractive runs cmd, an interactive program such as vi, less or ssh,
+// on the session's terminal, and waits for it to finish, during Eval as
+// Take is. The terminal is put back in the mode it was in before the
+// session started, and the session stops reading it, while cmd has it.
+// Standard input, output and error that cmd doesn't set are connected to
+// the terminal, and Ctrl-C interrupts cmd rather than the program running
+// the session. Keys typed ahead before RunInteractive are kept for the
+// session. It returns an error without running cmd on a session with no tty
+// of its own, such as a network session.
+func (r *Raw) RunInteractive(cmd *exec.Cmd) error {
+	return r.takeover(func(s *session) error {
+		return s.runInteractive(cmd)
+	})
+}
+
+// runInteractive runs cmd on the session's tty, once it has been handed
+// over, as RunInteractive describes.
+func (s *session) runInteractive(cmd *exec.Cmd) error {
+	tty, ok := s.terminal.(*ttyTerminal)
+	if !ok {
+		return errNoTTY
+	}
+	defer s.holdInput()()
+	tty.SetMode(ModeNormal)
+	defer tty.SetMode(ModeCbreak)
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	return runConnected(cmd, tty.in, tty.out)
+}
+
+// runConnected runs cmd with any of its standard input, output and error
+// that it doesn't set connected to the terminal in and out.
+func runConnected(cmd *exec.Cmd, in *os.File, out *os.File) error {
+	if cmd.Stdin == nil {
+		cmd.Stdin = in
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = out
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = out
+	}
+	return cmd.Run()
+}
+
+// takeover has the session run fn on its goroutine, with the terminal
+// handed over, and returns what fn returns.
+func (r *Raw) takeover(fn func(s *session) error) error {
+	r.mu.Lock()
+	evaluating := r.evaluating
+	r.mu.Unlock()
+	if evaluating == nil {
+		return errNotEvaluating
+	}
+	t := rawTakeover{fn: fn, done: make(chan error, 1)}
+	select {
+	case r.take <- t:
+	case <-evaluating:
+		return errNotEvaluating
+	}
+	return <-t.done
+}
+
+// begin lets the terminal be taken, while the handler evaluates a line.
+func (r *Raw) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evaluating = make(chan struct{})
+}
+
+// end stops the terminal being taken until the next evaluation.
+func (r *Raw) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.evaluating)
+	r.evaluating = nil
+}
+
+// takeRaw takes down what the session draws for editing, runs a takeover of
+// the terminal, and puts it back.
+func (s *session) takeRaw(t rawTakeover) {
+	if s.progress != nil {
+		s.progress.end()
+	}
+	s.removeToolbar()
+	s.setCursorShape(CursorDefault)
+	if s.pasteMode != PasteTyped {
+		s.putString("\033[?2004l")
+	}
+	s.flush()
+	err := t.fn(s)
+	s.startPaste()
+	s.startCursor()
+	s.drawToolbar()
+	if s.progress != nil {
+		s.progress.begin()
+	}
+	t.done <- err
+}
+
+// rawIO is the terminal as a handler sees it while it has taken it over.
+type rawIO struct {
+	s  *session
+	in []byte //input read from the terminal but not yet by the handler
+}
+
+func (rw *rawIO) Read(p []byte) (int, error) {
+	s := rw.s
+	for len(rw.in) == 0 {
+		select {
+		case chunk := <-s.input:
+			rw.in = chunk
+			if s.trace != nil && !s.secret {
+				s.traceInput(chunk)
+			}
+		case <-s.closing:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, rw.in)
+	rw.in = rw.in[n:]
+	return n, nil
+}
+
+func (rw *rawIO) Write(p []byte) (int, error) {
+	if err := rw.s.putChars(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// holdInput stops the session reading its terminal, canceling any read
+// already waiting, and returns the function that lets it read again. Input
+// the session had read before it stopped is kept as typed.
+func (s *session) holdInput() func() {
+	c, ok := s.terminal.(readCanceler)
+	if !ok {
+		return func() {}
+	}
+	s.holdMu.Lock()
+	held, paused := make(chan struct{}), make(chan struct{})
+	s.held, s.paused = held, paused
+	s.holdMu.Unlock()
+	c.cancelRead(true)
+	timeout := time.After(readCancelWait)
+	for waiting := true; waiting; {
+		select {
+		case chunk := <-s.input:
+			s.pending = append(s.pending, chunk...)
+		case <-paused:
+			waiting = false
+		case <-timeout:
+			waiting = false
+		}
+	}
+	c.cancelRead(false)
+	return func() {
+		s.holdMu.Lock()
+		s.held, s.paused = nil, nil
+		s.holdMu.Unlock()
+		close(held)
+	}
+}
+
+// waitHeld waits, if the session's input is being held, until it is let go,
+// and reports whether it was held.
+func (s *session) waitHeld() bool {
+	s.holdMu.Lock()
+	held, paused := s.held, s.paused
+	s.holdMu.Unlock()
+	if held == nil {
+		return false
+	}
+	close(paused)
+	<-held
+	return true
+}
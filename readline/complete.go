@@ -0,0 +1,134 @@
+package readline
+
+import "strings"
+
+// AutoCompleter completes the line being edited, as chzyer/readline's does.
+// Do is given the line and the position of the cursor in it, and returns the
+// candidates, each as what it adds to the last length characters before the
+// cursor.
+type AutoCompleter interface {
+	Do(line []rune, pos int) (newLine [][]rune, length int)
+}
+
+// DynamicCompleteFunc returns the names a dynamic item of a
+// PrefixCompleter offers, given the whole line.
+type DynamicCompleteFunc func(line string) []string
+
+// PrefixCompleter is an AutoCompleter made of a tree of items, each of which
+// completes a word and then hands the rest of the line to its children.
+type PrefixCompleter struct {
+	Name     []rune
+	Dynamic  bool
+	Callback DynamicCompleteFunc
+	Children []*PrefixCompleter
+}
+
+// NewPrefixCompleter returns a PrefixCompleter offering the items given.
+func NewPrefixCompleter(pc ...*PrefixCompleter) *PrefixCompleter {
+	return PcItem("", pc...)
+}
+
+// PcItem returns an item that completes name, followed by the items given.
+func PcItem(name string, pc ...*PrefixCompleter) *PrefixCompleter {
+	name += " "
+	return &PrefixCompleter{Name: []rune(name), Children: pc}
+}
+
+// PcItemDynamic returns an item that completes any of the names callback
+// returns, followed by the items given.
+func PcItemDynamic(callback DynamicCompleteFunc, pc ...*PrefixCompleter) *PrefixCompleter {
+	return &PrefixCompleter{Dynamic: true, Callback: callback, Children: pc}
+}
+
+// Do completes the line up to pos.
+func (p *PrefixCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	return p.do(line[:pos], line)
+}
+
+// names returns the names the item completes.
+func (p *PrefixCompleter) names(line []rune) [][]rune {
+	if !p.Dynamic {
+		return [][]rune{p.Name}
+	}
+	var names [][]rune
+	for _, name := range p.Callback(string(line)) {
+		names = append(names, []rune(name+" "))
+	}
+	return names
+}
+
+// do completes line, the part of the whole line still to be completed, with
+// p's children, going on down the tree while there is only one way to go.
+func (p *PrefixCompleter) do(line []rune, whole []rune) (newLine [][]rune, length int) {
+	line = []rune(strings.TrimLeft(string(line), " "))
+	var next *PrefixCompleter
+	goNext := false
+	for _, child := range p.Children {
+		for _, name := range child.names(whole) {
+			switch {
+			case len(line) >= len(name) && hasPrefix(line, name):
+				if len(line) == len(name) {
+					newLine = append(newLine, []rune{' '})
+				} else {
+					newLine = append(newLine, name)
+				}
+				length = len(name)
+				next, goNext = child, true
+			case len(line) < len(name) && hasPrefix(name, line):
+				newLine = append(newLine, name[len(line):])
+				length = len(line)
+				next = child
+			}
+		}
+	}
+	if len(newLine) != 1 {
+		return newLine, length
+	}
+	for i := length; i < len(line); i++ {
+		if line[i] != ' ' {
+			return next.do(line[i:], whole)
+		}
+	}
+	if goNext {
+		return next.do(nil, whole)
+	}
+	return newLine, length
+}
+
+// hasPrefix reports whether r starts with prefix.
+func hasPrefix(r []rune, prefix []rune) bool {
+	if len(r) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if r[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// complete returns the function that completes a line with ac, for a
+// repl.LineEditor: what all of the candidates add to the word before the
+// cursor, and the candidates in full.
+func complete(ac AutoCompleter) func(line string) (string, []string) {
+	return func(line string) (string, []string) {
+		runes := []rune(line)
+		suffixes, length := ac.Do(runes, len(runes))
+		if len(suffixes) == 0 || length > len(runes) {
+			return "", nil
+		}
+		word := string(runes[len(runes)-length:])
+		common := suffixes[0]
+		candidates := make([]string, len(suffixes))
+		for i, suffix := range suffixes {
+			candidates[i] = strings.TrimRight(word+string(suffix), " ")
+			n := 0
+			for n < len(common) && n < len(suffix) && common[n] == suffix[n] {
+				n++
+			}
+			common = common[:n]
+		}
+		return string(common), candidates
+	}
+}
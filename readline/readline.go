@@ -0,0 +1,398 @@
+// Package readline offers the commonly used part of the API of
+// github.com/chzyer/readline on top of the repl package's line editor, so
+// that a program written for chzyer/readline can switch to this one by
+// changing its import path:
+//
+//	rl, err := readline.NewEx(&readline.Config{
+//		Prompt:       "> ",
+//		HistoryFile:  "/tmp/history",
+//		AutoComplete: readline.NewPrefixCompleter(readline.PcItem("help")),
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer rl.Close()
+//	for {
+//		line, err := rl.Readline()
+//		if err != nil { // io.EOF or readline.ErrInterrupt
+//			break
+//		}
+//		...
+//	}
+//
+// Lines are edited with the keys, history and rendering of a repl session.
+// Vi mode, listeners, painters and the other hooks of chzyer/readline's
+// Config have no counterpart here; the engine's own features can be turned
+// on with Config.Options instead.
+package readline
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/boynton/repl"
+)
+
+// ErrInterrupt is returned by Readline when the user presses Ctrl-C.
+var ErrInterrupt = errors.New("Interrupt")
+
+// Config configures an Instance, as chzyer/readline's Config does.
+type Config struct {
+	// Prompt is shown before the line being edited.
+	Prompt string
+
+	// HistoryFile, if set, is where history is loaded from and saved to.
+	HistoryFile string
+	// HistoryLimit is the most history entries kept: 500 if it is 0, and
+	// none at all if it is -1.
+	HistoryLimit int
+	// DisableAutoSaveHistory leaves the lines entered out of history, for
+	// the program to add with SaveHistory.
+	DisableAutoSaveHistory bool
+
+	// AutoComplete, if set, completes the line when TAB is pressed.
+	AutoComplete AutoCompleter
+
+	// InterruptPrompt and EOFPrompt are accepted for compatibility, but not
+	// shown: the line is ended as a repl session ends it.
+	InterruptPrompt string
+	EOFPrompt       string
+
+	// EnableMask and MaskRune say how ReadPassword echoes what is typed:
+	// nothing unless EnableMask is set, and MaskRune, or '*', if it is.
+	EnableMask bool
+	MaskRune   rune
+
+	// FuncFilterInputRune, if set, is called with each character typed,
+	// control characters included, and returns the character to act on
+	// instead, and false to ignore it.
+	FuncFilterInputRune func(r rune) (rune, bool)
+
+	// Stdin, Stdout and Stderr are the streams the Instance uses, by default
+	// the process's own. When Stdin is a terminal, it is put into the mode
+	// for editing and its size is used, while the line being edited is drawn
+	// on Stdout, unless Stdout is a terminal too, in which case it is drawn
+	// on Stdin's.
+	Stdin  io.ReadCloser
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Options are passed on to the line editor, for features of the repl
+	// package that Config has no field for.
+	Options []repl.Option
+}
+
+// defaultHistoryLimit is the HistoryLimit used when it is 0.
+const defaultHistoryLimit = 500
+
+// init fills in the defaults of the fields left unset.
+func (c *Config) init() {
+	if c.HistoryLimit == 0 {
+		c.HistoryLimit = defaultHistoryLimit
+	}
+	if c.Stdin == nil {
+		c.Stdin = os.Stdin
+	}
+	if c.Stdout == nil {
+		c.Stdout = os.Stdout
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	if c.MaskRune == 0 {
+		c.MaskRune = '*'
+	}
+}
+
+// Instance reads lines, as chzyer/readline's Instance does.
+type Instance struct {
+	Config *Config
+
+	mu      sync.Mutex
+	t       repl.Terminal
+	editor  *repl.LineEditor
+	history []string
+}
+
+// New returns an Instance that shows prompt, with the default Config.
+func New(prompt string) (*Instance, error) {
+	return NewEx(&Config{Prompt: prompt})
+}
+
+// NewEx returns an Instance with the given Config, loading its history file,
+// if it has one.
+func NewEx(cfg *Config) (*Instance, error) {
+	cfg.init()
+	t := terminal(cfg.Stdin, cfg.Stdout)
+	//candidates carry their own spaces, as PcItem's do
+	options := append([]repl.Option{repl.WithCompletionSpace(false)}, cfg.Options...)
+	if cfg.FuncFilterInputRune != nil {
+		options = append(options[:len(options):len(options)], repl.WithOnKey(filterKey(cfg.FuncFilterInputRune)))
+	}
+	rl := &Instance{Config: cfg, t: t, editor: repl.NewLineEditor(t, options...)}
+	if cfg.AutoComplete != nil {
+		rl.editor.Complete = complete(cfg.AutoComplete)
+	}
+	if err := rl.loadHistory(); err != nil {
+		return nil, err
+	}
+	return rl, nil
+}
+
+// Line reads a line with a one-off Instance that shows prompt.
+func Line(prompt string) (string, error) {
+	rl, err := New(prompt)
+	if err != nil {
+		return "", err
+	}
+	defer rl.Close()
+	return rl.Readline()
+}
+
+// Readline returns the next line entered, io.EOF if the user presses Ctrl-D
+// on an empty line or the input ends, or ErrInterrupt if the user presses
+// Ctrl-C.
+func (rl *Instance) Readline() (string, error) {
+	return rl.ReadlineWithDefault("")
+}
+
+// ReadlineWithDefault is Readline with what as the line to start editing
+// from.
+func (rl *Instance) ReadlineWithDefault(what string) (string, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	//the editor adds every line to its history, which is left to SaveHistory
+	rl.editor.History = rl.history
+	line, err := rl.editor.Edit(rl.Config.Prompt, what)
+	if err == repl.ErrInterrupted {
+		return "", ErrInterrupt
+	}
+	if err != nil {
+		return "", err
+	}
+	if !rl.Config.DisableAutoSaveHistory {
+		rl.saveHistory(line)
+	}
+	return line, nil
+}
+
+// ReadPassword shows prompt and returns what is typed up to the end of the
+// line, echoing it as the Config's EnableMask and MaskRune say.
+func (rl *Instance) ReadPassword(prompt string) ([]byte, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if err := rl.t.SetMode(repl.ModeCbreak); err != nil {
+		return nil, err
+	}
+	defer rl.t.SetMode(repl.ModeNormal)
+	io.WriteString(rl.t, prompt)
+	var password []byte
+	echo := func(s string) {
+		if rl.Config.EnableMask {
+			io.WriteString(rl.t, s)
+		}
+	}
+	var b [1]byte //read a byte at a time, so as to take nothing past the line
+	for {
+		if _, err := rl.t.Read(b[:]); err != nil {
+			io.WriteString(rl.t, "\n")
+			return nil, err
+		}
+		switch ch := b[0]; ch {
+		case '\r', '\n':
+			io.WriteString(rl.t, "\n")
+			return password, nil
+		case 3: //Ctrl-C
+			io.WriteString(rl.t, "\n")
+			return nil, ErrInterrupt
+		case 4: //Ctrl-D
+			if len(password) == 0 {
+				io.WriteString(rl.t, "\n")
+				return nil, io.EOF
+			}
+		case 8, 127: //backspace
+			if len(password) > 0 {
+				_, n := utf8.DecodeLastRune(password)
+				password = password[:len(password)-n]
+				echo("\b \b")
+			}
+		default:
+			if ch >= ' ' {
+				password = append(password, ch)
+				if utf8.RuneStart(ch) {
+					echo(string(rl.Config.MaskRune))
+				}
+			}
+		}
+	}
+}
+
+// SetPrompt changes the prompt shown for the lines read after it.
+func (rl *Instance) SetPrompt(prompt string) {
+	rl.Config.Prompt = prompt
+}
+
+// SaveHistory adds content to history, and to the history file, if there
+// is one.
+func (rl *Instance) SaveHistory(content string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.saveHistory(content)
+}
+
+// ResetHistory forgets the history, leaving the history file as it is.
+func (rl *Instance) ResetHistory() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.history = nil
+}
+
+// Stdout returns the writer for output that goes with the lines read.
+func (rl *Instance) Stdout() io.Writer {
+	return rl.Config.Stdout
+}
+
+// Stderr returns the writer for errors.
+func (rl *Instance) Stderr() io.Writer {
+	return rl.Config.Stderr
+}
+
+// Write writes b to Stdout.
+func (rl *Instance) Write(b []byte) (int, error) {
+	return rl.Config.Stdout.Write(b)
+}
+
+// Close finishes with the Instance. The terminal is already back in its
+// normal mode whenever Readline isn't running, so there is nothing to undo.
+func (rl *Instance) Close() error {
+	return nil
+}
+
+// loadHistory reads the history file, keeping the most recent entries.
+func (rl *Instance) loadHistory() error {
+	if rl.Config.HistoryFile == "" || rl.Config.HistoryLimit < 0 {
+		return nil
+	}
+	f, err := os.Open(rl.Config.HistoryFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var history []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			history = append(history, line)
+		}
+	}
+	if n := len(history) - rl.Config.HistoryLimit; n > 0 {
+		history = history[n:]
+	}
+	rl.history = history
+	return scanner.Err()
+}
+
+// saveHistory adds content to history and the history file.
+func (rl *Instance) saveHistory(content string) error {
+	if rl.Config.HistoryLimit < 0 || strings.TrimSpace(content) == "" {
+		return nil
+	}
+	history := append(rl.history[:len(rl.history):len(rl.history)], content)
+	if n := len(history) - rl.Config.HistoryLimit; n > 0 {
+		history = history[n:]
+	}
+	rl.history = history
+	if rl.Config.HistoryFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(rl.Config.HistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(content + "\n")
+	return err
+}
+
+// filterKey returns the function that passes the characters typed through
+// filter, as a repl.WithOnKey hook.
+func filterKey(filter func(r rune) (rune, bool)) func(key repl.KeyEvent) (repl.KeyEvent, bool) {
+	return func(key repl.KeyEvent) (repl.KeyEvent, bool) {
+		r := key.Rune
+		if r == 0 && len(key.Bytes) == 1 {
+			r = rune(key.Bytes[0]) //a control character
+		}
+		if r == 0 {
+			return key, true
+		}
+		to, ok := filter(r)
+		if !ok {
+			return key, false
+		}
+		if to != r {
+			return repl.KeyEvent{Bytes: []byte(string(to))}, true
+		}
+		return key, true
+	}
+}
+
+// terminal returns the Terminal made of in and out: the tty itself if in is
+// one, drawing on out unless that is a tty as well, or a plain stream
+// otherwise.
+func terminal(in io.Reader, out io.Writer) repl.Terminal {
+	f, ok := in.(*os.File)
+	if !ok || !isTTY(f) {
+		return &streamTerminal{in: in, out: out}
+	}
+	if o, ok := out.(*os.File); ok && isTTY(o) {
+		return repl.TTY(f)
+	}
+	return &ttyOutput{Terminal: repl.TTY(f), out: out}
+}
+
+// isTTY reports whether f is a terminal.
+func isTTY(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ttyOutput is a tty whose output goes to another writer.
+type ttyOutput struct {
+	repl.Terminal
+	out io.Writer
+}
+
+func (t *ttyOutput) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+// streamTerminal is a Terminal made of a reader and a writer, with no size or
+// modes.
+type streamTerminal struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (st *streamTerminal) Read(p []byte) (int, error) {
+	return st.in.Read(p)
+}
+
+func (st *streamTerminal) Write(p []byte) (int, error) {
+	return st.out.Write(p)
+}
+
+func (st *streamTerminal) Size() (int, int) {
+	return 0, 0
+}
+
+func (st *streamTerminal) SetMode(mode repl.TerminalMode) error {
+	return nil
+}
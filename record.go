@@ -0,0 +1,90 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// WithRecording records everything the session writes to the terminal to w,
+// with its timing, in asciicast v2 format, so that the session can be played
+// back with asciinema or embedded in documentation. The toggle-recording
+// command, bound to C-x C-t, pauses and resumes the recording.
+func WithRecording(w io.Writer) Option {
+	return func(s *session) {
+		rec := &recorder{w: w, s: s}
+		s.recorder = rec
+		s.out = recordingWriter{s.out, rec}
+	}
+}
+
+// asciicastHeader is the first line of an asciicast v2 recording.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// recorder writes output events in asciicast v2 format.
+type recorder struct {
+	w      io.Writer
+	s      *session
+	start  time.Time
+	paused bool
+}
+
+// record notes that p was written to the terminal, writing the asciicast
+// header first if this is the first event.
+func (rec *recorder) record(p []byte) {
+	if rec.paused || len(p) == 0 {
+		return
+	}
+	now := time.Now()
+	enc := json.NewEncoder(rec.w)
+	enc.SetEscapeHTML(false)
+	if rec.start.IsZero() {
+		rec.start = now
+		cols, rows := rec.s.cols, rec.s.rows
+		if cols <= 0 {
+			cols = 80
+		}
+		if rows <= 0 {
+			rows = 24
+		}
+		enc.Encode(asciicastHeader{2, cols, rows, now.Unix(), map[string]string{"TERM": os.Getenv("TERM")}})
+	}
+	//the terminal turns newlines into CRLF itself, but a player won't
+	data := string(bytes.Replace(p, []byte{'\n'}, []byte{'\r', '\n'}, -1))
+	enc.Encode([]interface{}{json.Number(strconv.FormatFloat(now.Sub(rec.start).Seconds(), 'f', 6, 64)), "o", data})
+}
+
+// recordingWriter passes writes on to the terminal, and records them.
+type recordingWriter struct {
+	w   io.Writer
+	rec *recorder
+}
+
+func (rw recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	rw.rec.record(p[:n])
+	return n, err
+}
+
+// toggleRecording pauses or resumes the recording.
+func (s *session) toggleRecording() {
+	if s.recorder == nil {
+		s.bell()
+		return
+	}
+	msg := s.messages.RecordingPaused
+	if s.recorder.paused {
+		msg = s.messages.RecordingResumed
+	}
+	s.showBelow([]string{msg})
+	s.recorder.paused = !s.recorder.paused
+}
@@ -1,13 +1,25 @@
 package repl
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"syscall"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-	"unsafe"
+	"unicode/utf8"
 )
 
+// ReplHandler evaluates the lines entered in a session and supplies its
+// prompts and completions. A session calls its handler one call at a time,
+// so a handler used by only one session needs no locking, apart from an
+// Interrupter's Interrupt, which comes while Eval is running, and an Eval the
+// user has abandoned, which carries on alongside later calls. A handler
+// shared by several sessions that run at once, such as a local terminal and
+// network sessions, must either be safe for concurrent use or be shared
+// through a SessionManager.
 type ReplHandler interface {
 	Eval(expr string) (string, bool, error)
 	Complete(expr string) (string, []string)
@@ -17,166 +29,453 @@ type ReplHandler interface {
 	Stop(history []string)
 }
 
-var input chan byte
-var lastIn byte
-var lastInOk bool
-var state *termState
-
-func REPL(handler ReplHandler) error {
-	var err error
-	input = make(chan byte, 1)
-	go func() {
-		var ch [1]byte
-		for {
-			n, err := syscall.Read(syscall.Stdin, ch[:])
-			if err != nil || n == 0 {
-				panic("Problem reading stdin")
-			} else {
-				input <- ch[0]
-				if ch[0] == 0 {
-					return
-				}
-			}
-		}
-	}()
-	state, err = MakeCbreak(syscall.Stdin)
-	if err == nil {
-		defer Restore(syscall.Stdin, state)
-		err = repl(handler)
-		return err
-	} else {
-		return err
+// ExitConfirmer may be implemented by a handler that wants a say before the
+// user ends a session with Ctrl-D, to warn about unsaved work or an open
+// transaction. ConfirmExit is called on a fresh line and may print whatever it
+// likes; if it returns false, the session carries on with a new prompt.
+type ExitConfirmer interface {
+	ConfirmExit() bool
+}
+
+// ResetReason says why a handler is being reset.
+type ResetReason int
+
+const (
+	// ResetInterrupt means the user pressed Ctrl-C, or a client sent an
+	// interrupt.
+	ResetInterrupt ResetReason = iota
+	// ResetError means Eval returned an error.
+	ResetError
+)
+
+// Resetter may be implemented by a handler that wants to know why it is being
+// reset, to clean up the state of a multi-line entry precisely. ResetFor is
+// called instead of Reset, with the input that was discarded: the lines of an
+// unfinished entry and the line being edited, or the line that failed,
+// joined by newlines. Unlike Reset, it is also called when Eval returns an
+// error.
+type Resetter interface {
+	ResetFor(reason ResetReason, discarded string)
+}
+
+// AutoCompleter may be implemented by a handler that wants completion to
+// happen without TAB being pressed, such as after the "." of a member access.
+// CompletionTriggers returns the characters that, when typed, ask the handler
+// to complete the line up to and including them. Candidates are shown as
+// they are for TAB, but the bell isn't rung, and TAB straight afterwards
+// lists them.
+type AutoCompleter interface {
+	CompletionTriggers() string
+}
+
+// Resizer may be implemented by a handler that formats its output to the
+// terminal's width, such as tables or plots. Resized is called, between
+// keystrokes, when the size of the terminal changes.
+type Resizer interface {
+	Resized(cols, rows int)
+}
+
+// resetHandler resets h, telling it why if it is a Resetter. Handlers that
+// aren't are only reset on an interrupt, as they always have been.
+func resetHandler(h ReplHandler, reason ResetReason, discarded string) {
+	if r, ok := h.(Resetter); ok {
+		r.ResetFor(reason, discarded)
+	} else if reason == ResetInterrupt {
+		h.Reset()
 	}
 }
 
+// session holds the editing state for one interactive terminal: where its
+// keystrokes come from, where its output goes, and the line being edited.
+type session struct {
+	handler          ReplHandler
+	input            chan []byte
+	pending          []byte //input received but not yet consumed
+	async            chan func()
+	out              io.Writer
+	buf              *lineBuf
+	prompt           string
+	promptText       StyledText //the main prompt with its styles
+	promptPlain      string     //the text of promptText, which is the prompt while it is shown
+	shown            []byte     //the prompt and line currently on the screen
+	shownCursor      int
+	drawn            bool   //whether shown is known to be accurate
+	deferred         bool   //whether a redraw has been put off
+	target           []byte //scratch space for drawline
+	frame            []byte
+	char             [1]byte
+	cols             int
+	rows             int
+	bandwidth        Bandwidth
+	lowBandwidth     bool
+	slowWrites       int
+	done             bool
+	detachable       bool
+	trace            io.Writer
+	metrics          Metrics
+	noColor          bool
+	colorDepth       ColorDepth
+	sgrCache         map[string]string //theme styles, downgraded to the colors the terminal has
+	theme            Theme
+	autoTheme        bool //pick the theme from the terminal's background color
+	bellStyle        Bell
+	bellFunc         func()
+	ambiguous        Ambiguous
+	noEOF            bool
+	eofPresses       int
+	eofCount         int //Ctrl-Ds in a row so far
+	idleTimeout      time.Duration
+	unlock           func(passphrase string) bool
+	locked           bool //whether the session is locked for being idle
+	secret           bool //whether input is a password, not to be traced
+	maxLineLength    int
+	inputRate        int
+	inputBurst       int
+	auth             func(c Credentials) bool
+	origins          []string //origins besides its own a web page may open a WebSocket from
+	plainOutput      bool
+	transcript       io.Writer //gets the lines entered and their results, as plain text
+	recorder         *recorder
+	toolbar          func() string
+	toolbarText      StyledText //the text of a styled toolbar
+	styledToolbar    bool
+	toolbarRefresh   time.Duration
+	toolbarShown     string //the text of the toolbar as drawn
+	toolbarRows      int    //the rows of the terminal when the toolbar was set up, or 0
+	toolbarCols      int
+	progress         *Progress
+	raw              *Raw     //for the handler to take the terminal over, if it is a RawTaker
+	terminal         Terminal //the terminal the session runs on, if it has one of its own
+	holdMu           sync.Mutex
+	held             chan struct{} //closed to let the terminal be read again, while it is held for a subprocess
+	paused           chan struct{} //closed once reading has stopped for the hold
+	matchFlash       MatchFlash
+	matchTime        time.Duration
+	accessible       bool
+	messages         Messages
+	historyFile      string
+	historyNamespace func(c Credentials) string
+	historySize      int
+	historyName      string                   //the name of the history in use, for a HistoryNamer
+	histories        map[string]*savedHistory //the histories of the handler's other modes
+	osc52            bool
+	oscUntil         time.Time //when the terminal's answer to an OSC query stops being expected
+	localClipboard   bool
+	editor           string
+	result           string //the last result, for copying to the clipboard
+	keymap           map[string]string
+	chord            string //the keys of a chord read so far
+	osc              []byte //an OSC reply from the terminal, while it is being read
+	thisCommand      string
+	lastCommand      string
+	completions      []string        //the candidates from the last completion
+	menu             *completionMenu //the completion menu, while it is open
+	bareCompletion   bool            //whether no space goes after a word completed in full
+	exit             bool
+	noBuiltins       bool
+	noKeyHints       bool
+	hintLines        int //how many lines of hints are shown below the line
+	hintShown        int //how many times hints have been shown
+	interruptGrace   time.Duration
+	partial          []string //the lines of an unfinished entry
+	triggers         string   //the characters that invoke completion when typed
+	dabbrev          dabbrevState
+	histSearch       historySearch
+	lastArg          lastArgState
+	historyExpansion bool
+	historyVerify    bool //leave recalled and expanded lines to be run with RET
+	suggest          bool
+	inFormat         string      //the format of the number before the prompt, if entries are numbered
+	outFormat        string      //the format of the number before a result
+	evaluated        int         //how many entries have been evaluated
+	tokenizer        Tokenizer   //the handler, if it divides the line into tokens to color
+	kinds            []TokenKind //the kinds of the bytes of the line being drawn, if it is
+	shownKinds       []TokenKind //the kinds of the bytes of the line on the screen
+	arg              int         //the numeric argument for the next command
+	hasArg           bool        //whether one has been typed
+	aliases          map[string]string
+	aliasDisplay     AliasDisplay
+	abbreviations    map[string]string
+	pasteMode        PasteMode
+	pasted           []string //lines pasted ahead of the one being edited
+	pasting          bool     //whether pasted lines are being entered one by one
+	heredoc          string   //what introduces a heredoc, or empty
+	heredocEnd       string   //the word that ends the heredoc being entered
+	heredocLines     []string //the lines of the heredoc so far
+	balanceIndicator bool
+	balanceCol       int //the column the balance marker is drawn at, or 0
+	tick             time.Duration
+	readInput        func() ([]byte, error) //reads input directly, when there is no input channel
+	editing          bool                   //whether the session is editing a line for a LineEditor
+	lineMode         bool                   //whether the client edits and echoes lines itself
+	edited           bool
+	editedLine       string
+	editErr          error
+	cursorShape      CursorShape //the shape the cursor should be
+	cursorShown      CursorShape //the shape it has been set to
+	bidi             BidiMode
+	passthrough      Passthrough
+	preeditFunc      func(string) (string, string)
+	preedit          string //text being composed, shown at the cursor but not yet in the line
+	onKey            func(KeyEvent) (KeyEvent, bool)
+	keyStart         bool   //whether the byte last read starts a key, rather than continuing one seen by onKey
+	keyBytes         int    //how many of the pending bytes continue a key seen by onKey
+	keyDecode        []byte //the bytes of the key being decoded for onKey
+	wordModel        WordModel
+	normalize        func(string) string
+	noAbandon        bool
+	abandoned        []chan evalResult //where the evaluations given up on send their results
+	stopped          chan struct{}
+	control          *Control
+	closing          chan struct{} //closed by the Control to end the session, nil without one
+}
+
+func newSession(handler ReplHandler, out io.Writer, options ...Option) *session {
+	s := &session{handler: handler, input: make(chan []byte), async: make(chan func()), out: out, stopped: make(chan struct{}), theme: DefaultTheme, messages: DefaultMessages, keymap: newKeymap()}
+	for _, option := range options {
+		option(s)
+	}
+	if s.plainOutput {
+		s.out = &plainWriter{w: s.out}
+	}
+	return s
+}
+
+// console is the session attached to the process's own stdin and stdout.
+var console = newSession(nil, os.Stdout)
+
+func REPL(handler ReplHandler, options ...Option) error {
+	console = newSession(handler, os.Stdout, userOptions(options)...)
+	return runConsole(console)
+}
+
+// runConsole runs s on the process's own terminal.
+func runConsole(s *session) error {
+	return runTerminal(s, stdTTY)
+}
+
 func Exit(code int) {
-	if state != nil {
-		Restore(syscall.Stdin, state)
+	if stdTTY.state != nil {
+		stdTTY.SetMode(ModeNormal)
 		black := "\033[0;0m"
-		fmt.Printf(black)
+		fmt.Print(black)
 	}
 	os.Exit(1)
 }
 
 func GetChar() byte {
-	if lastInOk {
-		lastInOk = false
-		return lastIn
-	}
-	return <-input
+	ch, _ := console.getChar()
+	return ch
 }
 
 func Pause(millis time.Duration) {
-	if !lastInOk {
-		select {
-		case ch := <-input:
-			lastIn = ch
-			lastInOk = true
-		case <-time.After(millis):
-		}
-	}
+	console.pause(millis)
 }
 
 func PutChar(b byte) error {
-	var ch [1]byte
-	ch[0] = b
-	_, err := syscall.Write(syscall.Stdout, ch[:])
-	return err
+	return console.putChar(b)
 }
 
 func PutChars(b []byte) error {
-	_, err := syscall.Write(syscall.Stdout, b)
-	return err
+	return console.putChars(b)
 }
 
 func PeekChar() (byte, bool) {
-	if lastInOk {
-		return lastIn, true
-	}
-	select {
-	case ch := <-input:
-		lastIn = ch
-		lastInOk = true
-		return lastIn, true
-	case <-time.After(10 * time.Millisecond):
-		return 0, false
-	}
+	return console.peekChar()
 }
 
-// State contains the state of a terminal.
-type termState struct {
-	termios syscall.Termios
+// getChar returns the next input byte, running any asynchronous work posted
+// to the session while it waits. It returns false if that work ended the
+// session, or its Control closed it, and once the input has ended it goes on
+// returning false, so that a key cut short by the end tells run in turn.
+func (s *session) getChar() (byte, bool) {
+	if s.isClosing() || s.done && len(s.pending) == 0 {
+		return 0, false
+	}
+	if len(s.pending) == 0 {
+		s.flush()
+		if s.toolbar != nil {
+			s.drawToolbar()
+		}
+		s.drawBalance()
+	}
+	var idle <-chan time.Time
+	if s.idleTimeout > 0 && !s.locked {
+		timer := time.NewTimer(s.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	var tick <-chan time.Time
+	if s.tick > 0 {
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for len(s.pending) == 0 && s.readInput != nil {
+		chunk, err := s.readInput()
+		s.pending = chunk
+		if s.trace != nil && !s.secret && len(chunk) > 0 {
+			s.traceInput(chunk)
+		}
+		if err != nil && len(chunk) == 0 {
+			s.done = true
+			return 0, false
+		}
+	}
+	for len(s.pending) == 0 {
+		select {
+		case chunk := <-s.input:
+			s.pending = chunk
+			if s.trace != nil && !s.secret {
+				s.traceInput(chunk)
+			}
+		case fn := <-s.async:
+			fn()
+			if s.done {
+				return 0, false
+			}
+		case <-s.closing:
+			return 0, false
+		case <-tick:
+			s.ticked()
+			if s.done || s.exit {
+				return 0, false
+			}
+		case <-idle:
+			if !s.idle() {
+				return 0, false
+			}
+			timer := time.NewTimer(s.idleTimeout)
+			defer timer.Stop()
+			idle = timer.C
+		}
+	}
+	ch := s.pending[0]
+	s.pending = s.pending[1:]
+	s.keyStart = s.keyBytes == 0
+	if !s.keyStart {
+		s.keyBytes--
+	}
+	return ch, true
 }
 
-// MakeRaw put the terminal connected to the given file descriptor into raw
-// mode and returns the previous state of the terminal so that it can be
-// restored.
-func MakeRaw(fd int) (*termState, error) {
-	var oldState termState
-	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(getTermios), uintptr(unsafe.Pointer(&oldState.termios)), 0, 0, 0); err != 0 {
-		return nil, err
+// pause waits until more input is available, or the given time has passed.
+// It does not wait if no more input can come, which is the case when the
+// input channel is nil.
+func (s *session) pause(millis time.Duration) {
+	if len(s.pending) == 0 && s.input != nil {
+		select {
+		case chunk := <-s.input:
+			s.pending = chunk
+			if s.trace != nil {
+				s.traceInput(chunk)
+			}
+		case <-time.After(millis):
+		}
 	}
+}
 
-	newState := oldState.termios
-	newState.Iflag &^= syscall.ISTRIP | syscall.INLCR | syscall.ICRNL | syscall.IGNCR | syscall.IXON | syscall.IXOFF
-	newState.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
-	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&newState)), 0, 0, 0); err != 0 {
-		return nil, err
+// peekChar returns the next input byte without consuming it, if one arrives
+// within a few milliseconds.
+func (s *session) peekChar() (byte, bool) {
+	s.pause(10 * time.Millisecond)
+	if len(s.pending) == 0 {
+		return 0, false
 	}
+	return s.pending[0], true
+}
 
-	return &oldState, nil
+func (s *session) putChar(b byte) error {
+	s.char[0] = b
+	return s.putChars(s.char[:])
 }
 
-func MakeCbreak(fd int) (*termState, error) {
-	var oldState termState
-	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(getTermios), uintptr(unsafe.Pointer(&oldState.termios)), 0, 0, 0); err != 0 {
-		return nil, err
+// putChars writes b to the terminal. In BandwidthAuto mode it also watches
+// how long the writes take, and switches to low bandwidth mode if they are
+// repeatedly slow.
+func (s *session) putChars(b []byte) error {
+	if s.bandwidth != BandwidthAuto || s.lowBandwidth {
+		_, err := s.out.Write(b)
+		return err
 	}
-
-	newState := oldState.termios
-	newState.Iflag &^= syscall.ISTRIP | syscall.INLCR | syscall.ICRNL | syscall.IGNCR | syscall.IXON | syscall.IXOFF
-	newState.Lflag &^= syscall.ECHO | syscall.ICANON
-	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&newState)), 0, 0, 0); err != 0 {
-		return nil, err
+	start := time.Now()
+	_, err := s.out.Write(b)
+	if time.Since(start) > slowWrite {
+		s.slowWrites++
+		s.lowBandwidth = s.slowWrites >= slowWrites
 	}
+	return err
+}
 
-	return &oldState, nil
+func (s *session) putString(str string) error {
+	return s.putChars([]byte(str))
 }
 
-// Restore restores the terminal connected to the given file descriptor to a
-// previous state.
-func Restore(fd int, state *termState) error {
-	_, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&state.termios)), 0, 0, 0)
-	return err
+// post runs fn on the session's own goroutine, between keystrokes. It
+// returns false if the session has already finished.
+func (s *session) post(fn func()) bool {
+	select {
+	case s.async <- fn:
+		return true
+	case <-s.stopped:
+		return false
+	}
 }
 
-func PutString(s string) error {
-	return PutChars([]byte(s))
+// resize notes the new size of the terminal, and tells the handler, if it
+// wants to know.
+func (s *session) resize(cols, rows int) {
+	if cols == s.cols && rows == s.rows {
+		return
+	}
+	s.cols, s.rows = cols, rows
+	if s.progress != nil {
+		s.progress.resized(cols)
+	}
+	if r, ok := s.handler.(Resizer); ok {
+		r.Resized(cols, rows)
+	}
 }
 
-func cursorBackward() error {
-	chars := []byte{27, '[', '1', 'D'}
-	return PutChars(chars)
+// printAbove writes text on its own lines above the line being edited, then
+// redraws the prompt and the edit buffer beneath it.
+func (s *session) printAbove(text string) {
+	s.putString("\r\033[K")
+	s.putString(text)
+	s.drawn = false
+	s.drawline()
 }
 
-func cursorForward() error {
-	chars := []byte{27, '[', '1', 'C'}
-	return PutChars(chars)
+func PutString(s string) error {
+	return console.putString(s)
 }
 
+// lineBuf is the line being edited, kept in a gap buffer: the text before
+// the cursor is at the start of buf, the text after it at the end, and the
+// unused space between them moves with the cursor, so that typing and
+// deleting never shift the rest of the line.
 type lineBuf struct {
-	length       int
-	cursor       int
-	buf          []byte
-	yanked       string
-	yanking      bool
-	history      []string
-	historyIndex int
+	length      int
+	cursor      int
+	buf         []byte
+	yanked      []byte
+	yanking     bool
+	history     []string
+	historyBack int            //how far back in history the line came from, or 0
+	edits       map[int]string //lines recalled from history and edited, by how far back
+	stash       string         //the new line being typed, while history is being browsed
+	stashCursor int
+	initial     string //what the line started as, if it didn't come from history
+	historyFile *historyFile
+	historySize int
+	pinned      []string //the pinned entries, oldest first
+	maxLength   int
+	words       WordModel
 }
 
 func newLineBuf(capacity int) *lineBuf {
 	storage := make([]byte, capacity)
-	lb := lineBuf{0, 0, storage[:], "", false, nil, -1}
+	lb := lineBuf{buf: storage[:]}
 	return &lb
 }
 
@@ -190,35 +489,103 @@ func (lb *lineBuf) Clear() {
 	lb.yanking = false
 }
 
+// gap returns the size of the unused space at the cursor.
+func (lb *lineBuf) gap() int {
+	return len(lb.buf) - lb.length
+}
+
+// at returns the character at position i of the line.
+func (lb *lineBuf) at(i int) byte {
+	if i < lb.cursor {
+		return lb.buf[i]
+	}
+	return lb.buf[i+lb.gap()]
+}
+
+// previousChar returns the position of the character before position i,
+// which is more than one byte back if the character is not ASCII.
+func (lb *lineBuf) previousChar(i int) int {
+	i--
+	for i > 0 && !utf8.RuneStart(lb.at(i)) {
+		i--
+	}
+	return i
+}
+
+// nextChar returns the position of the character after the one at i.
+func (lb *lineBuf) nextChar(i int) int {
+	i++
+	for i < lb.length && !utf8.RuneStart(lb.at(i)) {
+		i++
+	}
+	return i
+}
+
+// moveTo puts the cursor, and the gap, at position pos.
+func (lb *lineBuf) moveTo(pos int) {
+	gap := lb.gap()
+	if pos < lb.cursor {
+		copy(lb.buf[pos+gap:lb.cursor+gap], lb.buf[pos:lb.cursor])
+	} else if pos > lb.cursor {
+		copy(lb.buf[lb.cursor:pos], lb.buf[lb.cursor+gap:pos+gap])
+	}
+	lb.cursor = pos
+}
+
+// reserve makes the gap at least n bytes long, growing the buffer if needed.
+func (lb *lineBuf) reserve(n int) {
+	if lb.gap() >= n {
+		return
+	}
+	size := 2 * len(lb.buf)
+	if size < lb.length+n {
+		size = lb.length + n
+	}
+	target := make([]byte, size)
+	copy(target, lb.buf[:lb.cursor])
+	copy(target[size-(lb.length-lb.cursor):], lb.buf[lb.cursor+lb.gap():])
+	lb.buf = target
+}
+
+// appendTo appends the text of the line to b.
+func (lb *lineBuf) appendTo(b []byte) []byte {
+	b = append(b, lb.buf[:lb.cursor]...)
+	return append(b, lb.buf[lb.cursor+lb.gap():]...)
+}
+
 func (lb *lineBuf) Insert(ch byte) {
 	lb.yanking = false
-	n := len(lb.buf)
-	if lb.length == n {
-		target := make([]byte, n+10)
-		copy(target, lb.buf[:n])
-		lb.buf = target
-	}
-	if lb.cursor == lb.length {
-		lb.buf[lb.cursor] = ch
-	} else {
-		copy(lb.buf[lb.cursor+1:], lb.buf[lb.cursor:])
-		lb.buf[lb.cursor] = ch
+	if lb.fit(1) == 0 {
+		return
 	}
+	lb.reserve(1)
+	lb.buf[lb.cursor] = ch
 	lb.cursor = lb.cursor + 1
 	lb.length = lb.length + 1
 }
 
 func (lb *lineBuf) InsertBytes(chs []byte) {
-	for _, ch := range chs {
-		lb.Insert(ch)
-	}
+	lb.yanking = false
+	chs = chs[:lb.fit(len(chs))]
+	lb.reserve(len(chs))
+	copy(lb.buf[lb.cursor:], chs)
+	lb.cursor = lb.cursor + len(chs)
+	lb.length = lb.length + len(chs)
+}
+
+func (lb *lineBuf) InsertString(str string) {
+	lb.yanking = false
+	str = str[:lb.fit(len(str))]
+	lb.reserve(len(str))
+	copy(lb.buf[lb.cursor:], str)
+	lb.cursor = lb.cursor + len(str)
+	lb.length = lb.length + len(str)
 }
 
 func (lb *lineBuf) Delete() bool {
 	lb.yanking = false
 	if lb.cursor < lb.length {
-		copy(lb.buf[lb.cursor:], lb.buf[lb.cursor+1:])
-		lb.length = lb.length - 1
+		lb.length = lb.length - (lb.nextChar(lb.cursor) - lb.cursor)
 		return true
 	} else {
 		return false
@@ -229,9 +596,9 @@ func (lb *lineBuf) KillToEnd() int {
 	n := lb.length - lb.cursor
 	//for now, a single yank buffer, not a stack
 	if lb.yanking {
-		lb.yanked = lb.yanked + string(lb.buf[lb.cursor:lb.length])
+		lb.yanked = append(lb.yanked, lb.buf[lb.cursor+lb.gap():]...)
 	} else {
-		lb.yanked = string(lb.buf[lb.cursor:lb.length])
+		lb.yanked = append(lb.yanked[:0], lb.buf[lb.cursor+lb.gap():]...)
 	}
 	lb.length = lb.cursor
 	lb.yanking = false
@@ -251,12 +618,12 @@ func (lb *lineBuf) DeleteRange(begin int, end int) int {
 	}
 	n := end - begin
 	if n > 0 {
+		lb.moveTo(end)
 		if lb.yanking {
-			lb.yanked = lb.yanked + string(lb.buf[begin:end])
+			lb.yanked = append(lb.yanked, lb.buf[begin:end]...)
 		} else {
-			lb.yanked = string(lb.buf[begin:end])
+			lb.yanked = append(lb.yanked[:0], lb.buf[begin:end]...)
 		}
-		copy(lb.buf[begin:], lb.buf[end:])
 		lb.length = lb.length - n
 		lb.cursor = begin
 	}
@@ -279,14 +646,14 @@ func (lb *lineBuf) previousWordBoundary() int {
 		if i == 0 {
 			return 0
 		}
-		for isWordDelimiter(lb.buf[i]) {
+		for isWordDelimiter(lb.at(i)) {
 			i--
 			if i < 0 {
 				return 0
 			}
 		}
 		if i > 0 {
-			for !isWordDelimiter(lb.buf[i]) {
+			for !isWordDelimiter(lb.at(i)) {
 				i--
 				if i < 0 {
 					return 0
@@ -299,18 +666,24 @@ func (lb *lineBuf) previousWordBoundary() int {
 
 func (lb *lineBuf) WordBackspace() int {
 	i := lb.previousWordBoundary()
+	if lb.words == WordsReadline {
+		i = lb.previousWordStart()
+	}
 	return lb.DeleteRange(i, lb.cursor)
 }
 
 func (lb *lineBuf) WordDelete() int {
+	if lb.words == WordsReadline {
+		return lb.DeleteRange(lb.cursor, lb.nextWordEnd())
+	}
 	var i int
 	for i = lb.cursor - 1; i < lb.length; i++ {
-		if lb.buf[i] != SPACE {
+		if i >= 0 && lb.at(i) != SPACE {
 			break
 		}
 	}
 	for ; i < lb.length; i++ {
-		if lb.buf[i] == SPACE {
+		if lb.at(i) == SPACE {
 			return lb.DeleteRange(lb.cursor, i)
 		}
 	}
@@ -318,28 +691,64 @@ func (lb *lineBuf) WordDelete() int {
 }
 
 func (lb *lineBuf) WordForward() {
+	if lb.words == WordsReadline {
+		lb.moveTo(lb.nextWordEnd())
+		return
+	}
 	i := lb.cursor
 	for ; i < lb.length; i++ {
-		if lb.buf[i] != SPACE {
+		if lb.at(i) != SPACE {
 			break
 		}
 	}
 	for ; i < lb.length; i++ {
-		if lb.buf[i] == SPACE {
-			lb.cursor = i
+		if lb.at(i) == SPACE {
+			lb.moveTo(i)
 			return
 		}
 	}
-	lb.cursor = lb.length
+	lb.moveTo(lb.length)
 }
 
 func (lb *lineBuf) WordBackward() {
-	lb.cursor = lb.previousWordBoundary()
+	if lb.words == WordsReadline {
+		lb.moveTo(lb.previousWordStart())
+		return
+	}
+	lb.moveTo(lb.previousWordBoundary())
+}
+
+// spaceAround returns where the run of spaces around the cursor starts and
+// ends.
+func (lb *lineBuf) spaceAround() (int, int) {
+	begin, end := lb.cursor, lb.cursor
+	for begin > 0 && lb.at(begin-1) == SPACE {
+		begin--
+	}
+	for end < lb.length && lb.at(end) == SPACE {
+		end++
+	}
+	return begin, end
+}
+
+func (lb *lineBuf) DeleteHorizontalSpace() int {
+	//unlike the kill commands, this leaves what was killed last alone
+	begin, end := lb.spaceAround()
+	lb.yanking = false
+	lb.moveTo(end)
+	lb.length -= end - begin
+	lb.cursor = begin
+	return end - begin
+}
+
+func (lb *lineBuf) JustOneSpace() {
+	lb.DeleteHorizontalSpace()
+	lb.Insert(SPACE)
 }
 
 func (lb *lineBuf) Yank() int {
 	lb.yanking = true
-	lb.InsertBytes([]byte(lb.yanked))
+	lb.InsertBytes(lb.yanked)
 	return len(lb.yanked)
 
 }
@@ -347,7 +756,7 @@ func (lb *lineBuf) Yank() int {
 func (lb *lineBuf) Backward() bool {
 	lb.yanking = false
 	if lb.cursor > 0 {
-		lb.cursor = lb.cursor - 1
+		lb.moveTo(lb.previousChar(lb.cursor))
 		return true
 	} else {
 		return false
@@ -357,7 +766,7 @@ func (lb *lineBuf) Backward() bool {
 func (lb *lineBuf) Forward() bool {
 	lb.yanking = false
 	if lb.cursor < lb.length {
-		lb.cursor = lb.cursor + 1
+		lb.moveTo(lb.nextChar(lb.cursor))
 		return true
 	} else {
 		return false
@@ -366,38 +775,58 @@ func (lb *lineBuf) Forward() bool {
 
 func (lb *lineBuf) Begin() {
 	lb.yanking = false
-	lb.cursor = 0
+	lb.moveTo(0)
 }
 
 func (lb *lineBuf) End() {
 	lb.yanking = false
-	lb.cursor = lb.length
+	lb.moveTo(lb.length)
 }
 
 func (lb *lineBuf) AddToHistory(line string) {
 	if len(line) > 0 {
 		lb.history = append(lb.history, line)
+		if lb.historySize > 0 && len(lb.history) > lb.historySize {
+			lb.history = lb.history[len(lb.history)-lb.historySize:]
+		}
+		if lb.historyFile != nil {
+			lb.historyFile.add(line)
+		}
 	}
-	lb.historyIndex = -1
+	lb.leaveHistory()
+}
+
+// leaveHistory starts the next line afresh, forgetting the edits made to
+// lines recalled from history and the line stashed while browsing it.
+func (lb *lineBuf) leaveHistory() {
+	lb.historyBack, lb.edits = 0, nil
+	lb.stash, lb.stashCursor = "", 0
+}
+
+// historyEntry returns the entry back places before the end of the history,
+// where 1 is the most recent. Entries older than those held in memory come
+// from the history file, if there is one.
+func (lb *lineBuf) historyEntry(back int) (string, bool) {
+	if lb.historySize > 0 && back > lb.historySize {
+		return "", false
+	}
+	if back <= len(lb.history) {
+		return lb.history[len(lb.history)-back], true
+	}
+	if lb.historyFile != nil {
+		return lb.historyFile.entry(back - len(lb.history) - 1)
+	}
+	return "", false
 }
 
 func (lb *lineBuf) PrevInHistory() int {
 	n := lb.length
-	if lb.history != nil {
-		if lb.historyIndex < 0 {
-			lb.historyIndex = len(lb.history) - 1
-		} else {
-			lb.historyIndex--
-		}
-		if lb.historyIndex >= 0 {
-			lb.length = 0
-			lb.cursor = 0
-			lb.InsertBytes([]byte(lb.history[lb.historyIndex]))
-			if lb.length > n {
-				n = lb.length
-			}
-		} else {
-			lb.historyIndex = 0
+	if line, ok := lb.historyEntry(lb.historyBack + 1); ok {
+		lb.keepEdit()
+		lb.historyBack++
+		lb.recall(line)
+		if lb.length > n {
+			n = lb.length
 		}
 	}
 	return n
@@ -405,26 +834,80 @@ func (lb *lineBuf) PrevInHistory() int {
 
 func (lb *lineBuf) NextInHistory() int {
 	n := lb.length
-	if lb.history != nil {
-		if lb.historyIndex >= 0 {
-			lb.historyIndex++
-			if lb.historyIndex < len(lb.history) {
-				lb.length = 0
-				lb.cursor = 0
-				lb.InsertBytes([]byte(lb.history[lb.historyIndex]))
-				if lb.length > n {
-					n = lb.length
-				}
-			} else {
-				lb.historyIndex--
-			}
+	if lb.historyBack > 0 {
+		lb.keepEdit()
+		lb.historyBack--
+		line := ""
+		if lb.historyBack > 0 {
+			line, _ = lb.historyEntry(lb.historyBack)
+		}
+		lb.recall(line)
+		if lb.length > n {
+			n = lb.length
 		}
 	}
 	return n
 }
 
+// keepEdit notes the line, before moving away from it through history, if
+// it has been edited since it was recalled, so that moving back to it brings
+// back the edits. The new line being typed is stashed, with the cursor where
+// it was, to be brought back exactly as it was by moving forward past the
+// newest entry.
+func (lb *lineBuf) keepEdit() {
+	if lb.historyBack == 0 {
+		lb.stash, lb.stashCursor = lb.String(), lb.cursor
+		return
+	}
+	original, _ := lb.historyEntry(lb.historyBack)
+	if lb.equals(original) {
+		delete(lb.edits, lb.historyBack)
+		return
+	}
+	if lb.edits == nil {
+		lb.edits = make(map[int]string)
+	}
+	lb.edits[lb.historyBack] = lb.String()
+}
+
+// recall replaces the line with the entry historyBack places back, line, or
+// the edits made to it since it was last recalled, or with the stashed new
+// line if historyBack is 0.
+func (lb *lineBuf) recall(line string) {
+	if edited, ok := lb.edits[lb.historyBack]; ok {
+		line = edited
+	}
+	lb.length = 0
+	lb.cursor = 0
+	if lb.historyBack == 0 {
+		lb.InsertString(lb.stash)
+		lb.moveTo(lb.stashCursor)
+		return
+	}
+	lb.InsertString(line)
+}
+
+// equals reports whether the line is str.
+func (lb *lineBuf) equals(str string) bool {
+	after := lb.buf[lb.cursor+lb.gap():]
+	return lb.length == len(str) && string(lb.buf[:lb.cursor]) == str[:lb.cursor] && string(after) == str[lb.cursor:]
+}
+
+// Revert puts the line back as it was before it was edited: the history entry
+// it was recalled from, or what it started as, which is usually nothing.
+func (lb *lineBuf) Revert() {
+	line := lb.initial
+	if lb.historyBack > 0 {
+		line, _ = lb.historyEntry(lb.historyBack)
+	}
+	delete(lb.edits, lb.historyBack)
+	lb.length = 0
+	lb.cursor = 0
+	lb.InsertString(line)
+}
+
 func (lb *lineBuf) String() string {
-	return string(lb.buf[0:lb.length])
+	return string(lb.appendTo(make([]byte, 0, lb.length)))
 }
 
 const CTRL_A = 1
@@ -467,33 +950,96 @@ func matching(ch byte) byte {
 	}
 }
 
-func highlightMatch(lb *lineBuf, prompt string, chOpen byte, chClose byte) {
+func (s *session) highlightMatch(chOpen byte, chClose byte) {
+	lb := s.buf
 	var i = lb.cursor - 1
 	count := 1
+	d := s.matchTime
+	if d == 0 {
+		d = matchFlashTime
+	}
 	for i > 0 {
 		i--
-		if lb.buf[i] == chOpen {
+		if lb.at(i) == chOpen {
 			count--
 			if count == 0 {
+				sgr := s.theme.Match
+				hide := false
+				if s.matchFlash == MatchColor {
+					if sgr == "" {
+						sgr = "7"
+					}
+					hide = s.style(sgr) != "" //without color, fall back to the jump
+				}
+				if hide {
+					s.putString("\033[?25l")
+				}
 				tmp := lb.cursor
-				lb.cursor = i
-				drawline(prompt, lb, 0)
-				Pause(500 * time.Millisecond)
-				lb.cursor = tmp
-				drawline(prompt, lb, 0)
+				lb.moveTo(i)
+				s.drawline()
+				s.flashMatch(chOpen, sgr)
+				s.pause(d)
+				s.flashMatch(chOpen, s.theme.Input)
+				lb.moveTo(tmp)
+				s.drawline()
+				if hide {
+					s.flush()
+					s.putString("\033[?25h")
+				}
 				return
 			}
-		} else if lb.buf[i] == chClose {
+		} else if lb.at(i) == chClose {
 			count++
 		}
 	}
-	PutChar(BEEP)
+	s.bell()
+}
+
+// listOptions shows the completion candidates one per line, below the line
+// being edited, then redraws the line beneath them.
+func (s *session) listOptions(options []string) {
+	s.flush()
+	for _, opt := range options {
+		s.putChar(NEWLINE)
+		s.putChars(s.appendStyled(nil, s.theme.Completion, []byte(opt)))
+	}
+	s.putChar(NEWLINE)
+	s.drawn = false
+	s.drawline()
+}
+
+// bell gets the user's attention, in the way the session is configured to.
+func (s *session) bell() {
+	switch {
+	case s.bellFunc != nil:
+		s.bellFunc()
+	case s.bellStyle == BellAudible, s.bellStyle == BellVisual && s.accessible:
+		s.putChar(BEEP)
+	case s.bellStyle == BellVisual:
+		s.flush()
+		s.putString("\033[?5h")
+		s.pause(visualBellTime)
+		s.putString("\033[?5l")
+	}
+}
+
+// flashMatch redraws the bracket ch under the cursor in the given style, if
+// the theme gives the matching bracket a style of its own, or the session
+// shows matches by color alone.
+func (s *session) flashMatch(ch byte, sgr string) {
+	if s.matchFlash != MatchColor && s.style(s.theme.Match) == "" {
+		return
+	}
+	s.char[0] = ch
+	s.frame = s.appendStyled(s.frame[:0], sgr, s.char[:])
+	s.frame = append(s.frame, BACKSPACE)
+	s.putChars(s.frame)
 }
 
 func dump(prompt string, lb lineBuf, extra int) {
 	fmt.Println("\ncursor =", lb.cursor, "length =", lb.length)
 	for i := 0; i < lb.length; i++ {
-		PutChar(lb.buf[i])
+		PutChar(lb.at(i))
 	}
 	PutChar(NEWLINE)
 	for i := 0; i < lb.length; i++ {
@@ -509,204 +1055,425 @@ func dump(prompt string, lb lineBuf, extra int) {
 	PutChar(NEWLINE)
 }
 
-func drawline(prompt string, lb *lineBuf, extra int) {
-	PutChar(13)
-	PutString(prompt)
-	PutString(lb.String())
-	for i := 0; i < extra; i++ {
-		PutChar(SPACE)
+// drawline brings the terminal up to date with the prompt and the edit
+// buffer. If more input has already arrived, as it does when keys repeat
+// quickly or text is pasted, the redraw is put off until that input has been
+// handled, so that a whole batch of keystrokes is drawn at once.
+func (s *session) drawline() {
+	if len(s.pending) > 0 {
+		s.deferred = true
+		return
+	}
+	s.paint()
+}
+
+// flush does any redraw that has been put off, before other output is
+// written to the terminal.
+func (s *session) flush() {
+	if s.deferred {
+		s.paint()
 	}
-	cursor := lb.length + extra
-	for cursor > lb.cursor {
-		cursorBackward()
-		cursor = cursor - 1
+}
+
+// paint compares the prompt and the edit buffer with what is already on the
+// screen and sends only the changed tail of the line and the cursor movement,
+// in a single write. A line with right-to-left text is drawn in the order the
+// session's BidiMode says.
+func (s *session) paint() {
+	if s.accessible {
+		s.paintLinear()
+		return
+	}
+	lb := s.buf
+	target := append(s.target[:0], s.prompt...)
+	target = lb.appendTo(target)
+	pos := lb.cursor
+	if s.preedit != "" {
+		after := append([]byte(nil), target[len(s.prompt)+pos:]...)
+		target = append(append(target[:len(s.prompt)+pos], s.preedit...), after...)
+		pos += len(s.preedit)
+	}
+	cursor := len(s.prompt) + pos
+	s.kinds = s.kinds[:0]
+	if s.bidi == BidiReorder && hasRTL(target[len(s.prompt):]) {
+		visual, at := visualOrder(nil, target[len(s.prompt):], pos)
+		target = append(target[:len(s.prompt)], visual...)
+		cursor = len(s.prompt) + at
+	} else if s.tokenizer != nil && s.preedit == "" {
+		s.kinds = s.appendKinds(s.kinds, string(target[len(s.prompt):]))
+	}
+	frame := s.frame[:0]
+	common := 0
+	if !s.drawn {
+		frame = append(frame, RETURN)
+		s.shown = s.shown[:0]
+		s.shownKinds = s.shownKinds[:0]
+		s.shownCursor = 0
+	} else {
+		for common < len(target) && common < len(s.shown) && target[common] == s.shown[common] && kindAt(s.kinds, common) == kindAt(s.shownKinds, common) {
+			common++
+		}
+		for common > 0 && common < len(target) && !utf8.RuneStart(target[common]) {
+			common-- //back to the start of the character that differs
+		}
+	}
+	//positions in the line are bytes, but the cursor moves in columns
+	shownCol, commonCol, cursorCol := displayWidth(s.shown[:s.shownCursor]), displayWidth(target[:common]), displayWidth(target[:cursor])
+	if s.lowBandwidth && s.tokenizer == nil && len(target) == len(s.shown)+1 && common < len(s.shown) && target[common] < utf8.RuneSelf && bytes.Equal(target[common+1:], s.shown[common:]) {
+		frame = appendCursorMove(frame, shownCol, commonCol)
+		frame = append(frame, ESCAPE, '[', '@')
+		frame = s.appendLine(frame, target, common, common+1)
+		frame = appendCursorMove(frame, commonCol+1, cursorCol)
+	} else if s.lowBandwidth && s.tokenizer == nil && len(target)+1 == len(s.shown) && s.shown[common] < utf8.RuneSelf && bytes.Equal(target[common:], s.shown[common+1:]) {
+		frame = appendCursorMove(frame, shownCol, commonCol)
+		frame = append(frame, ESCAPE, '[', 'P')
+		frame = appendCursorMove(frame, commonCol, cursorCol)
+	} else if common < len(target) || common < len(s.shown) {
+		frame = appendCursorMove(frame, shownCol, commonCol)
+		frame = s.appendLine(frame, target, common, len(target))
+		if len(s.shown) > len(target) {
+			frame = append(frame, ESCAPE, '[', 'K')
+		}
+		frame = appendCursorMove(frame, displayWidth(target), cursorCol)
+	} else {
+		frame = appendCursorMove(frame, shownCol, cursorCol)
+	}
+	s.shown = append(s.shown[:0], target...)
+	s.shownKinds = append(s.shownKinds[:0], s.kinds...)
+	s.shownCursor = cursor
+	s.drawn = true
+	s.deferred = false
+	s.target, s.frame = target, frame
+	if len(frame) > 0 {
+		s.putChars(frame)
+		if s.metrics != nil {
+			s.metrics.Redraw(len(frame))
+		}
+	}
+}
+
+// paintLinear is paint for accessible mode. It never moves the cursor back
+// into the line: characters added at the end are echoed and those deleted from
+// the end are backspaced over, and any other change prints the whole line
+// afresh on a new line, where a screen reader will read it out.
+func (s *session) paintLinear() {
+	s.kinds, s.shownKinds = s.kinds[:0], s.shownKinds[:0]
+	target := append(s.target[:0], s.prompt...)
+	target = s.buf.appendTo(target)
+	frame := s.frame[:0]
+	switch {
+	case !s.drawn:
+		frame = append(frame, RETURN)
+		frame = s.appendLine(frame, target, 0, len(target))
+	case len(target) >= len(s.shown) && bytes.Equal(target[:len(s.shown)], s.shown):
+		frame = s.appendLine(frame, target, len(s.shown), len(target))
+	case len(target) >= len(s.prompt) && bytes.Equal(s.shown[:len(target)], target):
+		for i := displayWidth(s.shown[len(target):]); i > 0; i-- {
+			frame = append(frame, BACKSPACE, SPACE, BACKSPACE)
+		}
+	default:
+		frame = append(frame, NEWLINE)
+		frame = s.appendLine(frame, target, 0, len(target))
+	}
+	s.shown = append(s.shown[:0], target...)
+	s.shownCursor = len(target)
+	s.drawn = true
+	s.deferred = false
+	s.target, s.frame = target, frame
+	if len(frame) > 0 {
+		s.putChars(frame)
+		if s.metrics != nil {
+			s.metrics.Redraw(len(frame))
+		}
 	}
 }
 
-func repl(handler ReplHandler) error {
+// echoInsert is the fast path for the most common edit, typing at the end of
+// the line: the new character is simply echoed. It returns false, having done
+// nothing, if ch was inserted anywhere else.
+func (s *session) echoInsert(ch byte) bool {
+	lb := s.buf
+	if len(s.pending) > 0 || !s.drawn || s.tokenizer != nil || lb.cursor != lb.length || s.shownCursor != len(s.shown) || len(s.shown) != len(s.prompt)+lb.length-1 {
+		return false
+	}
+	s.char[0] = ch
+	s.frame = s.appendStyled(s.frame[:0], s.theme.Input, s.char[:])
+	s.putChars(s.frame)
+	s.shown = append(s.shown, ch)
+	s.shownCursor++
+	if s.metrics != nil {
+		s.metrics.Redraw(len(s.frame))
+	}
+	return true
+}
+
+// echoBackspace is the fast path for deleting the last character of the
+// line. It returns false, having done nothing, if the deletion was anywhere
+// else.
+func (s *session) echoBackspace() bool {
+	lb := s.buf
+	if len(s.pending) > 0 || !s.drawn || s.tokenizer != nil || lb.cursor != lb.length || s.shownCursor != len(s.shown) || len(s.shown) != len(s.prompt)+lb.length+1 {
+		return false
+	}
+	s.frame = append(s.frame[:0], BACKSPACE, SPACE, BACKSPACE)
+	s.putChars(s.frame)
+	s.shown = s.shown[:len(s.shown)-1]
+	s.shownCursor--
+	if s.metrics != nil {
+		s.metrics.Redraw(len(s.frame))
+	}
+	return true
+}
+
+// drawCursor redraws after a change that only moved the cursor. In low
+// bandwidth mode it first waits briefly for more keystrokes, and leaves the
+// drawing until they stop, so that a run of cursor motions is sent as one.
+func (s *session) drawCursor() {
+	if s.lowBandwidth {
+		s.pause(cursorDelay)
+	}
+	s.drawline()
+}
+
+// showPrompt writes the prompt at the start of a fresh line.
+func (s *session) showPrompt() {
+	if s.pasting {
+		//the next pasted line is shown without one
+		s.drawn = false
+		return
+	}
+	s.shown = append(s.shown[:0], s.prompt...)
+	s.shownKinds = s.shownKinds[:0]
+	s.frame = s.appendLine(s.frame[:0], s.shown, 0, len(s.shown))
+	s.putChars(s.frame)
+	s.shownCursor = len(s.prompt)
+	s.drawn = true
+}
+
+// appendCursorMove appends the escape sequence that moves the cursor from
+// column from to column to.
+func appendCursorMove(frame []byte, from int, to int) []byte {
+	if to == from {
+		return frame
+	}
+	if to == 0 {
+		return append(frame, RETURN)
+	}
+	frame = append(frame, ESCAPE, '[')
+	if to < from {
+		frame = strconv.AppendInt(frame, int64(from-to), 10)
+		return append(frame, 'D')
+	}
+	frame = strconv.AppendInt(frame, int64(to-from), 10)
+	return append(frame, 'C')
+}
+
+func (s *session) run() error {
+	defer close(s.stopped)
+	s.begin()
+	s.startToolbar()
+	defer s.removeToolbar()
+	defer s.startPaste()()
+	defer s.startCursor()()
+	for !s.exit {
+		ch, ok := s.getChar()
+		if !ok {
+			if s.exit {
+				return nil
+			}
+			return s.end()
+		}
+		if s.osc != nil {
+			s.osc = s.oscByte(s.osc, ch)
+			continue
+		}
+		if s.oscReplyStart(ch) {
+			s.osc = make([]byte, 0, 64)
+			continue
+		}
+		if s.onKey != nil && s.keyStart && !s.hookKey(ch) {
+			continue
+		}
+		s.dispatch(ch)
+	}
+	return nil
+}
+
+// eof ends the session, when Ctrl-D is typed on an empty line, unless it
+// takes more Ctrl-Ds than that.
+func (s *session) eof() {
+	if s.noEOF {
+		s.bell()
+		return
+	}
+	if s.lastCommand != s.thisCommand {
+		s.eofCount = 0
+	}
+	s.eofCount++
+	if s.eofCount < s.eofPresses {
+		msg := fmt.Sprintf(s.messages.EOF, s.eofPresses-s.eofCount)
+		if s.lineMode {
+			s.putString(msg + "\n")
+			s.showPrompt()
+		} else {
+			s.showBelow([]string{msg})
+		}
+		return
+	}
+	s.flush()
+	s.putString("\n")
+	if c, ok := s.handler.(ExitConfirmer); ok && !c.ConfirmExit() {
+		s.showPrompt()
+		return
+	}
+	s.handler.Stop(s.buf.history)
+	s.exit = true
+}
+
+// begin starts the handler and shows the first prompt, or, for a session
+// that is resuming the state of a detached one, redraws the prompt and the
+// line that was being edited.
+func (s *session) begin() *lineBuf {
+	s.adopt()
+	if s.buf != nil {
+		s.drawn = false
+		s.drawline()
+		return s.buf
+	}
 	buf := newLineBuf(1024)
-	hist := handler.Start()
+	s.buf = buf
+	hist := s.handler.Start()
 	if hist != nil {
 		buf.history = hist
 	}
-	prompt := handler.Prompt()
-	PutString(prompt)
-	meta := false
-	metaExt := false
-	var lastChar byte
-	var options []string
-	for true {
-		ch := GetChar()
-		if metaExt {
-			metaExt = false
-			switch ch {
-			case 'D':
-				if buf.Backward() {
-					cursorBackward()
-					drawline(prompt, buf, 0)
-				}
-			case 'C':
-				if buf.Forward() {
-					cursorForward()
-					drawline(prompt, buf, 0)
-				}
-			case 'B':
-				n := buf.NextInHistory()
-				drawline(prompt, buf, n)
-			case 'A':
-				n := buf.PrevInHistory()
-				drawline(prompt, buf, n)
-			default:
-				PutChar(BEEP)
-			}
-		} else if meta {
-			meta = false
-			switch ch {
-			case DELETE:
-				n := buf.WordBackspace()
-				drawline(prompt, buf, n)
-			case 'd':
-				n := buf.WordDelete()
-				drawline(prompt, buf, n)
-			case 'b':
-				buf.WordBackward()
-				drawline(prompt, buf, 0)
-			case 'f':
-				buf.WordForward()
-				drawline(prompt, buf, 0)
-			case OPEN_BRACKET:
-				metaExt = true
-			default:
-				PutChar(BEEP)
-			}
+	s.historyName = historyName(s.handler)
+	if path := s.historyPath(); path != "" {
+		buf.historyFile = openHistoryFile(path)
+		s.loadPinned()
+	}
+	buf.historySize = s.historySize
+	buf.maxLength = s.maxLineLength
+	buf.words = s.wordModel
+	if s.autoTheme && s.input != nil {
+		s.queryBackground()
+	}
+	s.prompt = s.mainPrompt()
+	s.showPrompt()
+	return buf
+}
+
+// adopt sets the session up for the optional interfaces its handler
+// implements, which are called through the handler, even if it is one
+// standing in for a shared handler.
+func (s *session) adopt() {
+	s.progress, s.raw, s.tokenizer, s.triggers = nil, nil, nil, ""
+	h := implemented(s.handler)
+	if _, ok := h.(ProgressReporter); ok {
+		s.progress = &Progress{s: s}
+		s.handler.(ProgressReporter).SetProgress(s.progress)
+	}
+	if _, ok := h.(RawTaker); ok {
+		s.raw = &Raw{s: s, take: make(chan rawTakeover)}
+		s.handler.(RawTaker).SetRaw(s.raw)
+	}
+	if _, ok := h.(Tokenizer); ok {
+		s.tokenizer = s.handler.(Tokenizer)
+	}
+	if _, ok := h.(AutoCompleter); ok {
+		s.triggers = s.handler.(AutoCompleter).CompletionTriggers()
+	}
+}
+
+// end finishes a session whose input has gone away. A detachable session
+// leaves its handler running, to be resumed later.
+func (s *session) end() error {
+	s.flush()
+	if s.isClosing() {
+		s.putString("\n")
+		s.handler.Stop(s.buf.history)
+		return ErrClosed
+	}
+	if s.detachable {
+		return errDetached
+	}
+	s.putString("\n")
+	s.handler.Stop(s.buf.history)
+	return nil
+}
+
+// eval hands a completed line to the handler and prints the result, followed
+// by the next prompt unless the handler is waiting for more input.
+func (s *session) eval(str string) {
+	handler := s.handler
+	red := s.style(s.theme.Error)
+	green := s.style(s.theme.Result)
+	blue := s.style(s.theme.Output)
+	black := s.resetStyle()
+	out := s.out
+	if s.transcript != nil {
+		io.WriteString(s.transcript, s.prompt+str+"\n")
+		out = io.MultiWriter(s.out, s.transcript)
+	}
+	fmt.Fprint(out, blue) //all eval output in blue
+	start := time.Now()
+	if s.progress != nil {
+		s.progress.begin()
+	}
+	if s.raw != nil {
+		s.raw.begin()
+	}
+	//an empty line isn't numbered, unless it ends an entry of several
+	numbered := str != "" || len(s.partial) > 0
+	if n, ok := handler.(Numbered); ok && numbered && len(s.partial) == 0 {
+		n.SetNumber(s.number())
+	}
+	result, more, err := s.evaluate(str)
+	if s.raw != nil {
+		s.raw.end()
+	}
+	if s.progress != nil {
+		s.progress.end()
+	}
+	if s.metrics != nil {
+		s.metrics.Eval(time.Since(start), err)
+	}
+	fmt.Fprint(out, black)
+	if numbered && (!more || err != nil) {
+		s.evaluated++
+	}
+	if err == errAbandoned {
+		fmt.Fprintln(out, red+s.messages.Abandoned+black)
+		s.partial = s.partial[:0]
+		s.buf.Clear()
+		s.prompt = s.mainPrompt()
+		s.showPrompt()
+	} else if err != nil {
+		if red == "" {
+			fmt.Fprintln(out, s.messages.Error, err)
 		} else {
-			switch ch {
-			case ESCAPE:
-				meta = true
-			case CTRL_D:
-				if buf.IsEmpty() {
-					PutString("\n")
-					handler.Stop(buf.history)
-					input <- 0 //to stop the goroutine
-					return nil
-				} else {
-					buf.Delete()
-					drawline(prompt, buf, 1)
-				}
-			case CTRL_A:
-				buf.Begin()
-				drawline(prompt, buf, 0)
-			case CTRL_E:
-				buf.End()
-				drawline(prompt, buf, 0)
-			case CTRL_F:
-				if buf.Forward() {
-					cursorForward()
-					drawline(prompt, buf, 0)
-				}
-			case CTRL_B:
-				if buf.Backward() {
-					cursorBackward()
-					drawline(prompt, buf, 0)
-				}
-			case CTRL_C:
-				PutString("*** Interrupt\n")
-				buf.Clear()
-				handler.Reset()
-				prompt = handler.Prompt()
-				PutString(prompt)
-			case CTRL_K:
-				n := buf.KillToEnd()
-				drawline(prompt, buf, n)
-			case CTRL_Y:
-				n := buf.Yank()
-				drawline(prompt, buf, n)
-			case CTRL_L:
-				//dump(prompt, buf, 0);
-				PutString("\n")
-				drawline(prompt, buf, 0)
-			case CTRL_N:
-				n := buf.NextInHistory()
-				drawline(prompt, buf, n)
-			case CTRL_P:
-				n := buf.PrevInHistory()
-				drawline(prompt, buf, n)
-			case TAB:
-				if _, ok := PeekChar(); ok {
-					//pasting text in, don't do the tab completion
-					ch = 0
-				} else if lastChar == TAB {
-					if options != nil {
-						for _, opt := range options {
-							PutChar(NEWLINE)
-							PutString(opt)
-						}
-						PutChar(NEWLINE)
-						drawline(prompt, buf, 0)
-					}
-					PutChar(BEEP)
-				} else {
-					addendum, opt := handler.Complete(string(buf.buf[0:buf.cursor]))
-					if len(addendum) > 0 {
-						buf.InsertBytes([]byte(addendum))
-					}
-					if len(opt) == 1 {
-						buf.Insert(' ')
-						options = nil
-					} else {
-						options = opt
-						PutChar(BEEP)
-					}
-					drawline(prompt, buf, 0)
-				}
-			case DELETE:
-				if buf.Backward() {
-					buf.Delete()
-					drawline(prompt, buf, 1)
-				} else {
-					PutChar(BEEP)
-				}
-			case RETURN:
-				if !buf.IsEmpty() {
-					PutChar('\n')
-				}
-				s := buf.String()
-				buf.AddToHistory(s)
-				buf.Clear()
-				red := "\033[0;31m"
-				green := "\033[0;32m"
-				blue := "\033[0;34m"
-				black := "\033[0;0m"
-				fmt.Printf(blue) //all eval output in blue
-				result, more, err := handler.Eval(s)
-				fmt.Printf(black)
-				if err != nil {
-					fmt.Println(red, "***", err, black) //error result in red
-					buf.Clear()
-					prompt = handler.Prompt()
-					PutString(prompt)
-				} else if more {
-					prompt = ""
-				} else {
-					fmt.Println(green + result + black) //non-error result in green
-					prompt = handler.Prompt()
-					PutString(prompt)
-				}
-			default:
-				if ch >= SPACE && ch < 127 {
-					buf.Insert(ch)
-					drawline(prompt, buf, 0)
-					match := matching(ch)
-					if match != 0 {
-						highlightMatch(buf, prompt, match, ch)
-					}
-				} else {
-					PutChar(BEEP)
-				}
-			}
+			fmt.Fprintln(out, red, s.messages.Error, err, black) //error result in red
 		}
-		lastChar = ch
-
+		resetHandler(handler, ResetError, strings.Join(append(s.partial, str), "\n"))
+		s.partial = s.partial[:0]
+		s.buf.Clear()
+		s.prompt = s.mainPrompt()
+		s.showPrompt()
+	} else if more {
+		s.partial = append(s.partial, str)
+		s.prompt = ""
+	} else {
+		s.partial = s.partial[:0]
+		if !numbered {
+			fmt.Fprintln(out, green+result+black)
+		} else if result != "" {
+			fmt.Fprintln(out, green+s.numberedResult(result)+black) //non-error result in green
+		}
+		s.result = result
+		s.prompt = s.mainPrompt()
+		s.showPrompt()
+	}
+	s.switchHistory()
+	if h := s.control.take(); h != nil {
+		s.swapHandler(h)
 	}
-	return nil //never happens
 }
@@ -6,3 +6,9 @@ import (
 
 var getTermios = syscall.TIOCGETA
 var setTermios = syscall.TIOCSETA
+
+// selectRead waits until one of the descriptors in set, all below nfd, is
+// readable, leaving only those that are in set.
+func selectRead(nfd int, set *syscall.FdSet) error {
+	return syscall.Select(nfd, set, nil, nil, nil)
+}
@@ -6,3 +6,10 @@ import (
 
 var getTermios = syscall.TCGETS
 var setTermios = syscall.TCSETS
+
+// selectRead waits until one of the descriptors in set, all below nfd, is
+// readable, leaving only those that are in set.
+func selectRead(nfd int, set *syscall.FdSet) error {
+	_, err := syscall.Select(nfd, set, nil, nil, nil)
+	return err
+}
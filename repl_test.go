@@ -0,0 +1,571 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type benchHandler struct{}
+
+func (bh benchHandler) Eval(expr string) (string, bool, error)  { return expr, false, nil }
+func (bh benchHandler) Complete(expr string) (string, []string) { return "", nil }
+func (bh benchHandler) Reset()                                  {}
+func (bh benchHandler) Prompt() string                          { return "> " }
+func (bh benchHandler) Start() []string                         { return nil }
+func (bh benchHandler) Stop(history []string)                   {}
+
+func benchSession(line string) *session {
+	s := newSession(benchHandler{}, io.Discard)
+	s.buf = newLineBuf(1024)
+	s.prompt = "> "
+	s.buf.InsertString(line)
+	return s
+}
+
+func TestLineBufGap(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		edit     func(lb *lineBuf)
+		want     string
+		cursor   int
+	}{
+		{"insert into empty", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+		}, "abc", 3},
+		{"insert at start", 16, func(lb *lineBuf) {
+			lb.InsertString("bc")
+			lb.Begin()
+			lb.Insert('a')
+		}, "abc", 1},
+		{"insert before gap end", 16, func(lb *lineBuf) {
+			lb.InsertString("ac")
+			lb.Backward()
+			lb.Insert('b')
+		}, "abc", 2},
+		{"grow with gap in middle", 4, func(lb *lineBuf) {
+			lb.InsertString("abcd")
+			lb.Backward()
+			lb.Backward()
+			lb.InsertString("XY")
+		}, "abXYcd", 4},
+		{"grow with gap left over", 5, func(lb *lineBuf) {
+			lb.InsertString("abcd")
+			lb.Backward()
+			lb.Backward()
+			lb.InsertString("XYZ")
+		}, "abXYZcd", 5},
+		{"grow with gap at start", 2, func(lb *lineBuf) {
+			lb.InsertString("ab")
+			lb.Begin()
+			lb.InsertString("xyz")
+		}, "xyzab", 3},
+		{"grow from nothing", 0, func(lb *lineBuf) {
+			lb.Insert('a')
+			lb.InsertBytes([]byte("bc"))
+		}, "abc", 3},
+		{"delete at end", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+			lb.Delete()
+		}, "abc", 3},
+		{"delete at start", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+			lb.Begin()
+			lb.Delete()
+		}, "bc", 0},
+		{"delete after gap", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+			lb.Backward()
+			lb.Backward()
+			lb.Delete()
+		}, "ac", 1},
+		{"delete multibyte", 16, func(lb *lineBuf) {
+			lb.InsertString("aéb")
+			lb.Begin()
+			lb.Forward()
+			lb.Delete()
+		}, "ab", 1},
+		{"backward over multibyte", 16, func(lb *lineBuf) {
+			lb.InsertString("aé")
+			lb.Backward()
+			lb.Insert('x')
+		}, "axé", 2},
+		{"forward at end", 16, func(lb *lineBuf) {
+			lb.InsertString("ab")
+			lb.Forward()
+		}, "ab", 2},
+		{"backward at start", 16, func(lb *lineBuf) {
+			lb.InsertString("ab")
+			lb.Begin()
+			lb.Backward()
+		}, "ab", 0},
+		{"move gap back and forth", 16, func(lb *lineBuf) {
+			lb.InsertString("hello")
+			lb.Begin()
+			lb.End()
+			lb.Backward()
+			lb.Backward()
+			lb.Forward()
+			lb.Insert('!')
+		}, "hell!o", 5},
+		{"kill to end from middle", 16, func(lb *lineBuf) {
+			lb.InsertString("abcdef")
+			lb.Backward()
+			lb.Backward()
+			lb.Backward()
+			lb.KillToEnd()
+		}, "abc", 3},
+		{"yank at start", 16, func(lb *lineBuf) {
+			lb.InsertString("abcdef")
+			lb.Backward()
+			lb.Backward()
+			lb.Backward()
+			lb.KillToEnd()
+			lb.Begin()
+			lb.Yank()
+		}, "defabc", 3},
+		{"delete range across gap", 16, func(lb *lineBuf) {
+			lb.InsertString("abcdef")
+			lb.Begin()
+			lb.Forward()
+			lb.Forward()
+			lb.DeleteRange(1, 4)
+		}, "aef", 1},
+		{"delete range clamped", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+			lb.DeleteRange(-1, 10)
+		}, "", 0},
+		{"clear with gap in middle", 16, func(lb *lineBuf) {
+			lb.InsertString("abc")
+			lb.Backward()
+			lb.Clear()
+			lb.Insert('x')
+		}, "x", 1},
+	}
+	for _, test := range tests {
+		lb := newLineBuf(test.capacity)
+		test.edit(lb)
+		if got := lb.String(); got != test.want || lb.cursor != test.cursor {
+			t.Errorf("%s: got %q with the cursor at %d, want %q at %d", test.name, got, lb.cursor, test.want, test.cursor)
+			continue
+		}
+		for i := 0; i < lb.length; i++ {
+			if lb.at(i) != test.want[i] {
+				t.Errorf("%s: at(%d) is %q, want %q", test.name, i, lb.at(i), test.want[i])
+			}
+		}
+	}
+}
+
+func TestExpandHistory(t *testing.T) {
+	s := benchSession("")
+	for _, line := range []string{"echo one two three", "ls -l", "cat file"} {
+		s.buf.AddToHistory(line)
+	}
+	tests := []struct {
+		line    string
+		want    string
+		changed bool
+		err     string //what the error says, if there is one
+	}{
+		{"no references", "no references", false, ""},
+		{"!!", "cat file", true, ""},
+		{"x !!", "x cat file", true, ""},
+		{"!! x", "cat file x", true, ""},
+		{"!-2", "ls -l", true, ""},
+		{"!-3:1", "one", true, ""},
+		{"!-4", "", false, "event not found"},
+		{"!-0", "", false, "event not found"},
+		{"!1", "echo one two three", true, ""},
+		{"!9", "", false, "event not found"},
+		{"!ec:$", "three", true, ""},
+		{"!?two? four", "echo one two three four", true, ""},
+		{"!nothing", "", false, "event not found"},
+		{"!$", "file", true, ""},
+		{"!^", "file", true, ""},
+		{"!*", "file", true, ""},
+		{"!!:5", "", false, "bad word specifier"},
+		{"wow!", "wow!", false, ""},
+		{"a ! b", "a ! b", false, ""},
+		{"x != y", "x != y", false, ""},
+		{"f!(x)", "f!(x)", false, ""},
+		{"\\!!", "\\!!", false, ""},
+		{"\\!! !!", "\\!! cat file", true, ""},
+		{"^cat^dog", "dog file", true, ""},
+		{"^cat^dog^s", "dog files", true, ""},
+		{"^fish^dog", "", false, "substitution failed"},
+		{"^^dog", "", false, "substitution failed"},
+		{"^cat", "^cat", false, ""},
+	}
+	for _, test := range tests {
+		got, changed, err := s.expandHistory(test.line)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: error %v, want %q", test.line, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want || changed != test.changed {
+			t.Errorf("%q: got %q, %v, %v, want %q, %v", test.line, got, changed, err, test.want, test.changed)
+		}
+	}
+}
+
+// discardConn is a connection whose writes go nowhere.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestTelnetParse(t *testing.T) {
+	long := "\xff\xfa\x1f" + strings.Repeat("x", 1000)
+	tests := []struct {
+		name       string
+		reads      []string
+		want       string
+		cols, rows int
+	}{
+		{"data", []string{"abc"}, "abc", 0, 0},
+		{"CR LF", []string{"a\r\nb"}, "a\rb", 0, 0},
+		{"CR NUL", []string{"a\r\x00b"}, "a\rb", 0, 0},
+		{"CR LF split", []string{"a\r", "\nb"}, "a\rb", 0, 0},
+		{"LF alone", []string{"a\nb"}, "a\nb", 0, 0},
+		{"doubled IAC", []string{"a\xff\xffb"}, "a\xffb", 0, 0},
+		{"doubled IAC split", []string{"a\xff", "\xffb"}, "a\xffb", 0, 0},
+		{"option split", []string{"a\xff", "\xfd", "\x01b"}, "ab", 0, 0},
+		{"other command", []string{"a\xff\xf1b"}, "ab", 0, 0},
+		{"NAWS", []string{"\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0ok"}, "ok", 80, 24},
+		{"NAWS split", []string{"\xff\xfa\x1f\x00", "\x50\x00\x18\xff", "\xf0ok"}, "ok", 80, 24},
+		{"NAWS with IAC", []string{"\xff\xfa\x1f\x00\xff\xff\x00\x18\xff\xf0"}, "", 255, 24},
+		{"SB without SE", []string{long, long}, "", 0, 0},
+		{"SB ended at last", []string{long, "\xff\xf0ok"}, "ok", 0, 0},
+	}
+	for _, test := range tests {
+		tn := newTelnet(discardConn{})
+		var data []byte
+		for _, read := range test.reads {
+			data = append(data, tn.parse([]byte(read))...)
+		}
+		if string(data) != test.want || tn.cols != test.cols || tn.rows != test.rows {
+			t.Errorf("%s: got %q at %dx%d, want %q at %dx%d", test.name, data, tn.cols, tn.rows, test.want, test.cols, test.rows)
+		}
+		if len(tn.sub) > maxTelnetSub {
+			t.Errorf("%s: %d bytes of subnegotiation kept", test.name, len(tn.sub))
+		}
+	}
+}
+
+// captureConn is a connection that keeps what is written to it.
+type captureConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *captureConn) Write(p []byte) (int, error) { return c.written.Write(p) }
+
+// wsFrame returns a WebSocket frame, masked as a browser masks it if masked is
+// set, with its length in ext extra bytes: 0, 2 or 8.
+func wsFrame(fin bool, opcode byte, payload string, masked bool, ext int) []byte {
+	frame := []byte{opcode, 0}
+	if fin {
+		frame[0] |= 0x80
+	}
+	switch ext {
+	case 0:
+		frame[1] = byte(len(payload))
+	case 2:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	case 8:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if !masked {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	big := strings.Repeat("x", 70000)
+	tests := []struct {
+		name    string
+		in      []byte
+		want    []string
+		err     bool //whether reading ends in an error other than io.EOF
+		written []byte
+	}{
+		{"unmasked", wsFrame(true, wsText, "hi", false, 0), []string{"hi"}, false, nil},
+		{"masked", wsFrame(true, wsBinary, "hello", true, 0), []string{"hello"}, false, nil},
+		{"empty", wsFrame(true, wsText, "", true, 0), []string{""}, false, nil},
+		{"16-bit length", wsFrame(true, wsText, big[:300], true, 2), []string{big[:300]}, false, nil},
+		{"64-bit length", wsFrame(true, wsText, big, true, 8), []string{big}, false, nil},
+		{"64-bit length, short", wsFrame(true, wsText, "abc", true, 8), []string{"abc"}, false, nil},
+		{"two messages", join(wsFrame(true, wsText, "a", true, 0), wsFrame(true, wsText, "b", true, 0)), []string{"a", "b"}, false, nil},
+		{"fragmented", join(
+			wsFrame(false, wsText, "ab", true, 0),
+			wsFrame(false, wsContinuation, "cd", true, 0),
+			wsFrame(true, wsContinuation, "ef", true, 0)), []string{"abcdef"}, false, nil},
+		{"ping mid-message", join(
+			wsFrame(false, wsText, "ab", true, 0),
+			wsFrame(true, wsPing, "p", true, 0),
+			wsFrame(true, wsPong, "q", true, 0),
+			wsFrame(true, wsContinuation, "cd", true, 0)), []string{"abcd"}, false, wsFrame(true, wsPong, "p", false, 0)},
+		{"close mid-message", join(
+			wsFrame(false, wsText, "ab", true, 0),
+			wsFrame(true, wsClose, "", true, 0)), nil, false, wsFrame(true, wsClose, "", false, 0)},
+		{"truncated length", []byte{0x81, 0xfe, 0x01}, nil, true, nil},
+		{"truncated payload", wsFrame(true, wsText, "hello", true, 0)[:8], nil, true, nil},
+		{"frame too large", []byte{0x81, 0x7f, 0, 0, 0, 0, 0, 0x20, 0, 0}, nil, true, nil},
+		{"unknown opcode", wsFrame(true, 3, "x", true, 0), nil, true, nil},
+	}
+	for _, test := range tests {
+		conn := &captureConn{}
+		ws := &webSocket{conn: conn, r: bufio.NewReader(bytes.NewReader(test.in))}
+		var got []string
+		var err error
+		for {
+			var msg []byte
+			if msg, err = ws.readMessage(); err != nil {
+				break
+			}
+			got = append(got, string(msg))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got messages %.40q, want %.40q", test.name, got, test.want)
+		}
+		if (err != io.EOF) != test.err {
+			t.Errorf("%s: reading ended with %v", test.name, err)
+		}
+		if !bytes.Equal(conn.written.Bytes(), test.written) {
+			t.Errorf("%s: wrote %q, want %q", test.name, conn.written.Bytes(), test.written)
+		}
+	}
+}
+
+func TestReadGRPCInput(t *testing.T) {
+	//grpcMessage frames msg as an uncompressed gRPC message
+	grpcMessage := func(msg ...byte) []byte {
+		return append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+	}
+	overlong := append([]byte{0x18}, bytes.Repeat([]byte{0xff}, 11)...)
+	tests := []struct {
+		name string
+		in   []byte
+		kind int
+		text string
+		err  bool
+	}{
+		{"line", grpcMessage(0x0a, 2, 'h', 'i'), 1, "hi", false},
+		{"complete", grpcMessage(0x12, 1, 'x'), 2, "x", false},
+		{"interrupt", grpcMessage(0x18, 1), 3, "", false},
+		{"interrupt false", grpcMessage(0x18, 0), 0, "", false},
+		{"empty line", grpcMessage(0x0a, 0), 1, "", false},
+		{"no fields", grpcMessage(), 0, "", false},
+		{"last field wins", grpcMessage(0x0a, 1, 'a', 0x12, 1, 'b'), 2, "b", false},
+		{"unknown varint", grpcMessage(0x38, 0x96, 0x01, 0x0a, 1, 'a'), 1, "a", false},
+		{"unknown bytes", grpcMessage(0x4a, 2, 'x', 'y', 0x0a, 1, 'a'), 1, "a", false},
+		{"unknown fixed64", grpcMessage(0x51, 1, 2, 3, 4, 5, 6, 7, 8, 0x0a, 1, 'a'), 1, "a", false},
+		{"unknown fixed32", grpcMessage(0x5d, 1, 2, 3, 4, 0x0a, 1, 'a'), 1, "a", false},
+		{"line as varint", grpcMessage(0x08, 1), 0, "", false},
+		{"truncated key", grpcMessage(0x80), 0, "", true},
+		{"truncated varint", grpcMessage(0x18, 0x80), 0, "", true},
+		{"overlong varint", grpcMessage(overlong...), 0, "", true},
+		{"truncated length", grpcMessage(0x0a, 0x80), 0, "", true},
+		{"length past end", grpcMessage(0x0a, 5, 'h'), 0, "", true},
+		{"truncated fixed64", grpcMessage(0x51, 1, 2, 3), 0, "", true},
+		{"truncated fixed32", grpcMessage(0x5d, 1), 0, "", true},
+		{"group", grpcMessage(0x0b), 0, "", true},
+		{"compressed", []byte{1, 0, 0, 0, 0}, 0, "", true},
+		{"too large", []byte{0, 0xff, 0xff, 0xff, 0xff}, 0, "", true},
+		{"truncated message", []byte{0, 0, 0, 0, 4, 0x0a, 2}, 0, "", true},
+		{"truncated header", []byte{0, 0, 0}, 0, "", true},
+	}
+	for _, test := range tests {
+		in, err := readGRPCInput(bytes.NewReader(test.in))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil || in.kind != test.kind || in.text != test.text {
+			t.Errorf("%s: got %+v, %v, want kind %d and %q", test.name, in, err, test.kind, test.text)
+		}
+	}
+}
+
+func TestHTTPSessions(t *testing.T) {
+	he := HTTPHandler(func() ReplHandler { return benchHandler{} })
+	do := func(method, target, session, addr string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if method == "POST" {
+			body = strings.NewReader("(+ 1 2)")
+		}
+		r := httptest.NewRequest(method, target, body)
+		r.Header.Set("Content-Type", "application/octet-stream")
+		r.RemoteAddr = addr + ":1234"
+		if session != "" {
+			r.Header.Set(HTTPSessionHeader, session)
+		}
+		w := httptest.NewRecorder()
+		he.ServeHTTP(w, r)
+		return w
+	}
+	if w := do("GET", "/complete?code=x", "", "10.0.0.1"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /complete without a session: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := do("POST", "/eval", "", "10.0.0.1")
+	id := w.Header().Get(HTTPSessionHeader)
+	if w.Code != http.StatusOK || id == "" {
+		t.Fatalf("POST /eval: status %d, session %q", w.Code, id)
+	}
+	if w := do("GET", "/complete?code=x", id, "10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("GET /complete in the session: status %d", w.Code)
+	}
+	if w := do("GET", "/complete?code=x", id, "10.0.0.2"); w.Code != http.StatusNotFound {
+		t.Errorf("GET /complete in another client's session: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := do("POST", "/complete", id, "10.0.0.2"); w.Header().Get(HTTPSessionHeader) == id {
+		t.Errorf("POST /complete from another client continued the session")
+	}
+}
+
+func BenchmarkDrawlineTyping(b *testing.B) {
+	s := benchSession("(define (square x) (* x x))")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.buf.Insert('a')
+		if !s.echoInsert('a') {
+			s.drawline()
+		}
+		s.buf.Backward()
+		s.buf.Delete()
+		s.drawline()
+	}
+}
+
+func BenchmarkDrawlineCursorMotion(b *testing.B) {
+	s := benchSession("(define (square x) (* x x))")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.buf.WordBackward()
+		s.drawline()
+		s.buf.End()
+		s.drawline()
+	}
+}
+
+func BenchmarkDrawlineFull(b *testing.B) {
+	s := benchSession("(define (square x) (* x x))")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.drawn = false
+		s.drawline()
+	}
+}
+
+func BenchmarkHistoryRecall(b *testing.B) {
+	lb := newLineBuf(1024)
+	for i := 0; i < 1000; i++ {
+		lb.AddToHistory(fmt.Sprintf("(history entry number %d)", i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lb.PrevInHistory()
+		if lb.historyBack == len(lb.history) {
+			lb.historyBack = 0
+		}
+	}
+}
+
+func BenchmarkHistoryFileRecall(b *testing.B) {
+	f, err := ioutil.TempFile("", "history")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(w, "(history entry number %d)\n", i)
+	}
+	w.Flush()
+	f.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lb := newLineBuf(1024)
+		lb.historyFile = openHistoryFile(f.Name())
+		for j := 0; j < 100; j++ {
+			lb.PrevInHistory()
+		}
+	}
+}
+
+func BenchmarkKillYank(b *testing.B) {
+	lb := newLineBuf(1024)
+	lb.InsertString("(define (square x) (* x x))")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lb.Begin()
+		lb.KillToEnd()
+		lb.Yank()
+	}
+}
+
+func BenchmarkPasteInsert(b *testing.B) {
+	paste := bytes.Repeat([]byte("(+ 1 2) "), 50*1024/8)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lb := newLineBuf(1024)
+		for _, ch := range paste {
+			lb.Insert(ch)
+		}
+	}
+}
+
+func BenchmarkPasteInsertMiddle(b *testing.B) {
+	paste := bytes.Repeat([]byte("(+ 1 2) "), 50*1024/8)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lb := newLineBuf(1024)
+		lb.InsertString("(list )")
+		lb.Backward()
+		for _, ch := range paste {
+			lb.Insert(ch)
+		}
+	}
+}
+
+func FuzzSession(f *testing.F) {
+	f.Add([]byte("(+ 1 2)\r"))
+	f.Add([]byte("(def\t\t\x1b[A\x1b[D\x1bb\x1bd\x0b\x19\r"))
+	f.Add([]byte("abc\x02\x02X\x7f\x10\x10\x0e\x03\x1b\x7f\x04"))
+	f.Add([]byte("\xee"))
+	f.Add([]byte("\xe2\x82"))
+	f.Add([]byte("ab\x1b[D\xe2"))
+	f.Add([]byte("\x1b[2"))
+	f.Add([]byte("\x1b[200~abc"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Fuzz(data)
+	})
+}
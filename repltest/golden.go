@@ -0,0 +1,89 @@
+package repltest
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boynton/repl"
+)
+
+// update makes ExpectScreen write golden files instead of checking them. Run
+// "go test -repltest.update" to record the screens a new or changed test
+// produces.
+var update = flag.Bool("repltest.update", false, "write repltest golden files instead of comparing with them")
+
+// Snapshot returns the text on vt's screen, without trailing blank rows,
+// followed by a line giving the position of the cursor, so that rendering
+// mistakes in cursor placement show up as well as those in the text.
+func Snapshot(vt *repl.VirtualTerminal) string {
+	row, col := vt.Cursor()
+	return fmt.Sprintf("%s\n-- cursor %d,%d --\n", vt.String(), row, col)
+}
+
+// ExpectScreen compares the screen with the snapshot in the golden file at
+// path, waiting for the session to finish drawing it, and fails the test
+// with both screens if they still differ when the timeout expires. When the
+// tests are run with -repltest.update, it writes the current screen to the
+// file instead.
+func (sc *Script) ExpectScreen(path string) *Script {
+	sc.t.Helper()
+	if *update {
+		time.Sleep(10 * time.Millisecond)
+		writeGolden(sc.t, path, Snapshot(sc.vt))
+		return sc
+	}
+	want := readGolden(sc.t, path)
+	if !sc.wait(func() bool { return Snapshot(sc.vt) == want }) {
+		sc.t.Fatalf("screen does not match %s\ngot:\n%s\nwant:\n%s", path, frame(Snapshot(sc.vt)), frame(want))
+	}
+	return sc
+}
+
+// CompareGolden compares got with the golden file at path, for tests that
+// take their own snapshots, and fails the test if they differ. When the tests
+// are run with -repltest.update, it writes got to the file instead.
+func CompareGolden(t testing.TB, path string, got string) {
+	t.Helper()
+	if *update {
+		writeGolden(t, path, got)
+		return
+	}
+	if want := readGolden(t, path); got != want {
+		t.Fatalf("screen does not match %s\ngot:\n%s\nwant:\n%s", path, frame(got), frame(want))
+	}
+}
+
+func readGolden(t testing.TB, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -repltest.update to create it)", err)
+	}
+	return string(data)
+}
+
+func writeGolden(t testing.TB, path string, snapshot string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(snapshot), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+}
+
+// frame marks the left edge of each line of a screen, to set it apart in
+// failure messages.
+func frame(snapshot string) string {
+	lines := strings.Split(strings.TrimSuffix(snapshot, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "| " + line
+	}
+	return strings.Join(lines, "\n")
+}
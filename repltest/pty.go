@@ -0,0 +1,56 @@
+package repltest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boynton/repl"
+)
+
+// PTY is a session running on a real pseudo-terminal, for integration tests
+// of the terminal handling that a VirtualTerminal cannot cover, such as the
+// switch into and out of cbreak mode.
+type PTY struct {
+	// Master is the master side of the pseudo-terminal. Writing to it types
+	// keys into the session, and reading it returns what the session writes,
+	// after the terminal's own output processing.
+	Master *os.File
+
+	// Slave is the terminal device the session runs on.
+	Slave *os.File
+
+	t    testing.TB
+	done chan error
+}
+
+// StartPTY allocates a pseudo-terminal with 80 columns and 24 rows, and runs
+// a session for handler on its slave side, as REPL would on the process's own
+// terminal. The test skips if pseudo-terminals are not available.
+func StartPTY(t testing.TB, handler repl.ReplHandler, options ...repl.Option) *PTY {
+	t.Helper()
+	master, slave, err := openPTY()
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	p := &PTY{Master: master, Slave: slave, t: t, done: make(chan error, 1)}
+	go func() {
+		p.done <- repl.RunTerminal(repl.TTY(slave), handler, options...)
+	}()
+	return p
+}
+
+// Close hangs up the pseudo-terminal, which ends the session, and waits for
+// the session to finish, returning its error.
+func (p *PTY) Close() error {
+	p.t.Helper()
+	p.Master.Close()
+	defer p.Slave.Close()
+	select {
+	case err := <-p.done:
+		return err
+	case <-time.After(DefaultTimeout):
+		p.t.Fatalf("session did not finish")
+		return nil
+	}
+}
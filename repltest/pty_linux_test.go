@@ -0,0 +1,71 @@
+package repltest
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/boynton/repl"
+)
+
+// lflag returns the local mode flags of the terminal f.
+func lflag(t *testing.T, f *os.File) uint32 {
+	var termios syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); errno != 0 {
+		t.Errorf("TCGETS: %v", errno)
+	}
+	return termios.Lflag
+}
+
+// takeHandler takes the terminal over when it evaluates "take", noting the
+// terminal's flags while it has it and once it has given it back.
+type takeHandler struct {
+	echoHandler
+	t       *testing.T
+	slave   *os.File
+	raw     *repl.Raw
+	flags   chan [2]uint32
+	takeErr error
+}
+
+func (h *takeHandler) SetRaw(r *repl.Raw) { h.raw = r }
+
+func (h *takeHandler) Eval(expr string) (string, bool, error) {
+	if expr != "take" {
+		return h.echoHandler.Eval(expr)
+	}
+	var inside uint32
+	err := h.raw.Take(func(rw io.ReadWriter) error {
+		inside = lflag(h.t, h.slave)
+		return nil
+	})
+	h.flags <- [2]uint32{inside, lflag(h.t, h.slave)}
+	return "", false, err
+}
+
+func TestTakeModes(t *testing.T) {
+	h := &takeHandler{t: t, flags: make(chan [2]uint32, 1)}
+	p := StartPTY(t, h)
+	h.slave = p.Slave
+	//keys typed before the session has the terminal in cbreak mode are cooked
+	for lflag(t, p.Slave)&syscall.ICANON != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Master.Write([]byte("take\r"))
+	var flags [2]uint32
+	select {
+	case flags = <-h.flags:
+	case <-time.After(DefaultTimeout):
+		t.Fatal("Eval did not take the terminal")
+	}
+	if inside := flags[0]; inside&(syscall.ICANON|syscall.ECHO|syscall.ISIG) != 0 {
+		t.Errorf("terminal not raw during Take: lflag %#x", inside)
+	}
+	if after := flags[1]; after&(syscall.ICANON|syscall.ECHO) != 0 || after&syscall.ISIG == 0 {
+		t.Errorf("terminal not back in cbreak mode after Take: lflag %#x", after)
+	}
+	p.Close()
+}
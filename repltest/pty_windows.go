@@ -0,0 +1,12 @@
+package repltest
+
+import (
+	"errors"
+	"os"
+)
+
+// openPTY fails, as Windows has no pseudo-terminals of the kind the tests
+// need.
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("pseudo-terminals are not supported on Windows")
+}
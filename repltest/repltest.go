@@ -0,0 +1,370 @@
+// Package repltest provides utilities for testing REPL handlers by scripting
+// interactive sessions with them.
+//
+// A Script runs a session for a handler on a virtual terminal, types keys into
+// it, and checks what the session writes and which handler calls it makes, in
+// the manner of expect:
+//
+//	sc := repltest.Start(t, newHandler())
+//	sc.Send("(+ 1 2)\r").Expect("3")
+//	sc.Send(repltest.Up + "\r").ExpectCall("Eval", "(+ 1 2)")
+//	sc.Close()
+package repltest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boynton/repl"
+)
+
+// Keys that send escape sequences or control characters, for use in scripts.
+const (
+	Up        = "\033[A"
+	Down      = "\033[B"
+	Right     = "\033[C"
+	Left      = "\033[D"
+	Tab       = "\t"
+	Enter     = "\r"
+	Backspace = "\x7f"
+	Escape    = "\033"
+	CtrlA     = "\x01"
+	CtrlB     = "\x02"
+	CtrlC     = "\x03"
+	CtrlD     = "\x04"
+	CtrlE     = "\x05"
+	CtrlF     = "\x06"
+	CtrlK     = "\x0b"
+	CtrlL     = "\x0c"
+	CtrlN     = "\x0e"
+	CtrlP     = "\x10"
+	CtrlY     = "\x19"
+)
+
+// DefaultTimeout is how long Expect and ExpectCall wait for what they are
+// looking for, unless the script's Timeout says otherwise.
+const DefaultTimeout = 5 * time.Second
+
+// Call is a call the session made to its handler. Arg is the argument to Eval,
+// Complete or Describe, the history given to Stop, one entry per line, the
+// text discarded for ResetFor, the number for SetNumber and the size for
+// Resized, as "80x24", and is empty for the other methods. The methods of the
+// optional interfaces are recorded only if the handler implements them, and
+// not at all if they only ask it for something while drawing, as Tokens does.
+type Call struct {
+	Method string
+	Arg    string
+}
+
+func (c Call) String() string {
+	if c.Arg == "" {
+		return c.Method + "()"
+	}
+	return fmt.Sprintf("%s(%q)", c.Method, c.Arg)
+}
+
+// Script is a session being driven by a test.
+type Script struct {
+	// Timeout is how long Expect and ExpectCall wait.
+	Timeout time.Duration
+
+	t       testing.TB
+	vt      *repl.VirtualTerminal
+	handler *recorder
+	done    chan error
+	seen    int //how much of the output earlier expectations have matched
+	called  int //how many of the calls earlier expectations have matched
+}
+
+// Start runs a session for handler on an 80 by 24 virtual terminal, and
+// returns the script that drives it.
+func Start(t testing.TB, handler repl.ReplHandler, options ...repl.Option) *Script {
+	return StartTerminal(t, repl.NewVirtualTerminal(80, 24), handler, options...)
+}
+
+// StartTerminal is like Start, but runs the session on vt.
+func StartTerminal(t testing.TB, vt *repl.VirtualTerminal, handler repl.ReplHandler, options ...repl.Option) *Script {
+	sc := &Script{Timeout: DefaultTimeout, t: t, vt: vt, handler: &recorder{handler: handler}, done: make(chan error, 1)}
+	go func() {
+		sc.done <- repl.RunTerminal(vt, sc.handler, options...)
+	}()
+	return sc
+}
+
+// Terminal returns the virtual terminal the session is running on.
+func (sc *Script) Terminal() *repl.VirtualTerminal {
+	return sc.vt
+}
+
+// Send types keys into the session.
+func (sc *Script) Send(keys string) *Script {
+	sc.vt.Type(keys)
+	return sc
+}
+
+// SendSlowly types keys one at a time, with delay between them, as a person
+// would rather than as a paste.
+func (sc *Script) SendSlowly(keys string, delay time.Duration) *Script {
+	for i := 0; i < len(keys); i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		sc.vt.Type(keys[i : i+1])
+	}
+	return sc
+}
+
+// Sleep pauses the script.
+func (sc *Script) Sleep(d time.Duration) *Script {
+	time.Sleep(d)
+	return sc
+}
+
+// Expect waits until text appears in the session's output, after anything
+// matched by earlier expectations, and fails the test if it does not appear
+// within the timeout. The output is matched as written, escape sequences and
+// all.
+func (sc *Script) Expect(text string) *Script {
+	sc.t.Helper()
+	ok := sc.wait(func() bool {
+		out := sc.vt.Output()
+		if i := bytes.Index(out[sc.seen:], []byte(text)); i >= 0 {
+			sc.seen += i + len(text)
+			return true
+		}
+		return false
+	})
+	if !ok {
+		sc.t.Fatalf("expected output %q, got %q", text, sc.vt.Output()[sc.seen:])
+	}
+	return sc
+}
+
+// ExpectCall waits until the handler is called with method and arg, after
+// the calls matched by earlier expectations, and fails the test if that does
+// not happen within the timeout.
+func (sc *Script) ExpectCall(method, arg string) *Script {
+	sc.t.Helper()
+	want := Call{method, arg}
+	ok := sc.wait(func() bool {
+		calls := sc.handler.list()
+		for i := sc.called; i < len(calls); i++ {
+			if calls[i] == want {
+				sc.called = i + 1
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		var rest []string
+		for _, c := range sc.handler.list()[sc.called:] {
+			rest = append(rest, c.String())
+		}
+		sc.t.Fatalf("expected call %s, got [%s]", want, strings.Join(rest, ", "))
+	}
+	return sc
+}
+
+// Calls returns every call the session has made to the handler so far.
+func (sc *Script) Calls() []Call {
+	return sc.handler.list()
+}
+
+// Close ends the session's input, as if its terminal had gone away, and waits
+// for the session to finish, returning its error.
+func (sc *Script) Close() error {
+	sc.t.Helper()
+	sc.vt.Close()
+	select {
+	case err := <-sc.done:
+		return err
+	case <-time.After(sc.Timeout):
+		sc.t.Fatalf("session did not finish")
+		return nil
+	}
+}
+
+// Wait waits for the session to finish by itself, as it does when the script
+// ends it with Ctrl-D, and returns its error.
+func (sc *Script) Wait() error {
+	sc.t.Helper()
+	select {
+	case err := <-sc.done:
+		return err
+	case <-time.After(sc.Timeout):
+		sc.t.Fatalf("session did not finish")
+		return nil
+	}
+}
+
+func (sc *Script) wait(cond func() bool) bool {
+	deadline := time.Now().Add(sc.Timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+// recorder is a ReplHandler that notes the calls made to the handler it
+// wraps. It implements every optional interface, passing on the calls to
+// those the handler implements, and is an Unwrapper, so that the session uses
+// only those.
+type recorder struct {
+	handler repl.ReplHandler
+	mu      sync.Mutex
+	calls   []Call
+}
+
+func (r *recorder) record(method, arg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{method, arg})
+}
+
+func (r *recorder) list() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+func (r *recorder) Eval(expr string) (string, bool, error) {
+	r.record("Eval", expr)
+	return r.handler.Eval(expr)
+}
+
+func (r *recorder) Complete(expr string) (string, []string) {
+	r.record("Complete", expr)
+	return r.handler.Complete(expr)
+}
+
+func (r *recorder) Reset() {
+	r.record("Reset", "")
+	r.handler.Reset()
+}
+
+func (r *recorder) Prompt() string {
+	r.record("Prompt", "")
+	return r.handler.Prompt()
+}
+
+func (r *recorder) Start() []string {
+	r.record("Start", "")
+	return r.handler.Start()
+}
+
+func (r *recorder) Stop(history []string) {
+	r.record("Stop", strings.Join(history, "\n"))
+	r.handler.Stop(history)
+}
+
+func (r *recorder) Unwrap() repl.ReplHandler {
+	return r.handler
+}
+
+func (r *recorder) Interrupt() {
+	if i, ok := r.handler.(repl.Interrupter); ok {
+		r.record("Interrupt", "")
+		i.Interrupt()
+	}
+}
+
+func (r *recorder) ResetFor(reason repl.ResetReason, discarded string) {
+	if rs, ok := r.handler.(repl.Resetter); ok {
+		r.record("ResetFor", discarded)
+		rs.ResetFor(reason, discarded)
+	} else if reason == repl.ResetInterrupt {
+		r.Reset()
+	}
+}
+
+func (r *recorder) CompletionTriggers() string {
+	if ac, ok := r.handler.(repl.AutoCompleter); ok {
+		return ac.CompletionTriggers()
+	}
+	return ""
+}
+
+func (r *recorder) Tokens(line string) []repl.Token {
+	if t, ok := r.handler.(repl.Tokenizer); ok {
+		return t.Tokens(line)
+	}
+	return nil
+}
+
+func (r *recorder) Resized(cols, rows int) {
+	if rs, ok := r.handler.(repl.Resizer); ok {
+		r.record("Resized", fmt.Sprintf("%dx%d", cols, rows))
+		rs.Resized(cols, rows)
+	}
+}
+
+func (r *recorder) ConfirmExit() bool {
+	if c, ok := r.handler.(repl.ExitConfirmer); ok {
+		r.record("ConfirmExit", "")
+		return c.ConfirmExit()
+	}
+	return true
+}
+
+func (r *recorder) SetRaw(raw *repl.Raw) {
+	if rt, ok := r.handler.(repl.RawTaker); ok {
+		rt.SetRaw(raw)
+	}
+}
+
+func (r *recorder) SetProgress(p *repl.Progress) {
+	if pr, ok := r.handler.(repl.ProgressReporter); ok {
+		pr.SetProgress(p)
+	}
+}
+
+func (r *recorder) SetNumber(n int) {
+	if nb, ok := r.handler.(repl.Numbered); ok {
+		r.record("SetNumber", fmt.Sprint(n))
+		nb.SetNumber(n)
+	}
+}
+
+func (r *recorder) HistoryName() string {
+	if hn, ok := r.handler.(repl.HistoryNamer); ok {
+		return hn.HistoryName()
+	}
+	return ""
+}
+
+func (r *recorder) Describe(candidate string) string {
+	if d, ok := r.handler.(repl.Describer); ok {
+		r.record("Describe", candidate)
+		return d.Describe(candidate)
+	}
+	return ""
+}
+
+func (r *recorder) StyledPrompt() repl.StyledText {
+	if sp, ok := r.handler.(repl.StyledPrompter); ok {
+		r.record("StyledPrompt", "")
+		return sp.StyledPrompt()
+	}
+	return repl.Text(r.Prompt())
+}
+
+func (r *recorder) Symbols() []string {
+	if sym, ok := r.handler.(repl.Symbolizer); ok {
+		return sym.Symbols()
+	}
+	return nil
+}
+
+func (r *recorder) Tick() {
+	if t, ok := r.handler.(repl.Ticker); ok {
+		t.Tick()
+	}
+}
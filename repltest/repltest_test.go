@@ -0,0 +1,202 @@
+package repltest
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boynton/repl"
+)
+
+// echoHandler returns each line as its result, and waits for more while
+// the line ends with a backslash.
+type echoHandler struct {
+	partial string
+}
+
+func (h *echoHandler) Eval(expr string) (string, bool, error) {
+	if strings.HasSuffix(expr, "\\") {
+		h.partial += strings.TrimSuffix(expr, "\\")
+		return "", true, nil
+	}
+	line := h.partial + expr
+	h.partial = ""
+	return "=" + line, false, nil
+}
+
+func (h *echoHandler) Complete(expr string) (string, []string) { return "", nil }
+func (h *echoHandler) Reset()                                  { h.partial = "" }
+func (h *echoHandler) Prompt() string                          { return "> " }
+func (h *echoHandler) Start() []string                         { return nil }
+func (h *echoHandler) Stop(history []string)                   {}
+
+// expectLine waits until the row of the screen holding the cursor reads
+// want, and the cursor is at col.
+func expectLine(t *testing.T, sc *Script, want string, col int) {
+	t.Helper()
+	vt := sc.Terminal()
+	line := func() (string, int) {
+		row, c := vt.Cursor()
+		screen := vt.Screen()
+		if row >= len(screen) {
+			return "", c
+		}
+		return screen[row], c
+	}
+	if !sc.wait(func() bool { got, c := line(); return got == want && c == col }) {
+		got, c := line()
+		t.Fatalf("line is %q with the cursor at %d, want %q at %d", got, c, want, col)
+	}
+}
+
+func TestEvalAndHistory(t *testing.T) {
+	sc := Start(t, &echoHandler{})
+	sc.Send("hello"+Enter).ExpectCall("Eval", "hello").Expect("=hello")
+	sc.Send(Up+Enter).ExpectCall("Eval", "hello").Expect("=hello")
+	sc.Send("a\\"+Enter+"b"+Enter).ExpectCall("Eval", "a\\").ExpectCall("Eval", "b").Expect("=ab")
+	sc.Send(CtrlD)
+	if err := sc.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	sc.ExpectCall("Stop", "hello\nhello\na\\\nb")
+}
+
+func TestRerunOfRerun(t *testing.T) {
+	sc := Start(t, &echoHandler{})
+	sc.Send("one" + Enter).Expect("=one")
+	sc.Send("two" + Enter).Expect("=two")
+	sc.Send(":r 3" + Enter).Expect("*** History entry 3 is a :r itself")
+	sc.Send(":r 1"+Enter).ExpectCall("Eval", "one")
+	sc.Send("three"+Enter).ExpectCall("Eval", "three")
+	sc.Close()
+}
+
+// waitHandler's Eval of "wait" runs until it is interrupted, and it asks
+// before letting the session end.
+type waitHandler struct {
+	echoHandler
+	interrupted chan struct{}
+}
+
+func (h *waitHandler) Eval(expr string) (string, bool, error) {
+	if expr == "wait" {
+		<-h.interrupted
+		return "", false, errors.New("interrupted")
+	}
+	return h.echoHandler.Eval(expr)
+}
+
+func (h *waitHandler) Interrupt()        { close(h.interrupted) }
+func (h *waitHandler) ConfirmExit() bool { return false }
+
+func TestOptionalInterfaces(t *testing.T) {
+	sc := Start(t, &waitHandler{interrupted: make(chan struct{})})
+	sc.Send("wait"+Enter).ExpectCall("Eval", "wait")
+	sc.Send(CtrlC).ExpectCall("Interrupt", "").Expect("interrupted")
+	sc.Send(CtrlD).ExpectCall("ConfirmExit", "")
+	sc.Send("still here"+Enter).ExpectCall("Eval", "still here")
+	sc.Close()
+}
+
+// hangHandler's Eval of "hang" doesn't return until the test is over.
+type hangHandler struct {
+	echoHandler
+	release chan struct{}
+}
+
+func (h *hangHandler) Eval(expr string) (string, bool, error) {
+	if expr == "hang" {
+		<-h.release
+	}
+	return h.echoHandler.Eval(expr)
+}
+
+func TestTypingAfterAbandoning(t *testing.T) {
+	h := &hangHandler{release: make(chan struct{})}
+	defer close(h.release)
+	sc := Start(t, h)
+	sc.Send("hang"+Enter).ExpectCall("Eval", "hang")
+	sc.Send(CtrlC+CtrlC+"next"+Enter).ExpectCall("Eval", "next").Expect("=next")
+	sc.Close()
+}
+
+func TestEditingRendersLine(t *testing.T) {
+	sc := Start(t, &echoHandler{})
+	sc.Send("helo wrld")
+	expectLine(t, sc, "> helo wrld", 11)
+	sc.Send(Left + Left + Left + "o")
+	expectLine(t, sc, "> helo world", 9)
+	sc.Send(CtrlA + Right + Right + Right + "l")
+	expectLine(t, sc, "> hello world", 6)
+	sc.Send(CtrlE + Backspace + CtrlB + CtrlK)
+	expectLine(t, sc, "> hello wor", 11)
+	sc.Send(Enter).ExpectCall("Eval", "hello wor")
+	sc.Close()
+}
+
+func TestInputEndingMidCharacter(t *testing.T) {
+	for _, keys := range []string{"\xe2", "ab\xe2\x82", "ab" + Left + "\xe2", "\x1b[2", "\x1b[200~abc"} {
+		sc := Start(t, &echoHandler{})
+		sc.Timeout = 2 * time.Second
+		sc.Send(keys)
+		sc.Close()
+	}
+}
+
+func TestCopyLineWithCursorInside(t *testing.T) {
+	sc := Start(t, &echoHandler{}, repl.WithOSC52(true), repl.WithLocalClipboard(false))
+	sc.Send("abcdef" + Left + Left + Left + "X" + Backspace)
+	expectLine(t, sc, "> abcdef", 5)
+	sc.Send(Escape + "w").Expect("\033]52;c;" + base64.StdEncoding.EncodeToString([]byte("abcdef")) + "\a")
+	sc.Close()
+}
+
+func TestPasteLocalClipboard(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fakes xclip")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf 'one\\033[Atwo\\a'\n"
+	if err := os.WriteFile(filepath.Join(dir, "xclip"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	t.Setenv("DISPLAY", ":0")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	sc := Start(t, &echoHandler{}, repl.WithLocalClipboard(true))
+	sc.Send("\x18" + CtrlY)
+	expectLine(t, sc, "> one[Atwo", 10)
+	sc.Send(Enter).ExpectCall("Eval", "one[Atwo")
+	sc.Close()
+}
+
+func TestPasteOSC52(t *testing.T) {
+	sc := Start(t, &echoHandler{}, repl.WithOSC52(true), repl.WithLocalClipboard(false))
+	sc.Send("\x18" + CtrlY).Expect("\033]52;c;?\a")
+	sc.Send("\033]52;c;" + base64.StdEncoding.EncodeToString([]byte("one\033[Atwo\a")) + "\a")
+	expectLine(t, sc, "> one[Atwo", 10)
+	sc.Send(Enter).ExpectCall("Eval", "one[Atwo")
+	sc.Close()
+}
+
+func TestScreen(t *testing.T) {
+	sc := Start(t, &echoHandler{})
+	sc.Send("one" + Enter).Expect("=one")
+	sc.Send("two" + Left)
+	expectLine(t, sc, "> two", 4)
+	sc.ExpectScreen("testdata/screen.golden")
+	sc.Close()
+}
+
+func TestScreenWrapped(t *testing.T) {
+	sc := StartTerminal(t, repl.NewVirtualTerminal(20, 6), &echoHandler{})
+	sc.Send(strings.Repeat("0123456789", 3))
+	expectLine(t, sc, "890123456789", 12)
+	sc.ExpectScreen("testdata/wrapped.golden")
+	sc.Close()
+}
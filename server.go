@@ -0,0 +1,384 @@
+package repl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errDetached = errors.New("Session detached")
+
+// Server runs REPL sessions on network connections, for a long running program
+// that wants to offer an admin console over a TCP or Unix socket.
+type Server struct {
+	// NewHandler returns the handler for a new session.
+	NewHandler HandlerFactory
+
+	// Telnet enables telnet option negotiation with each client, so that
+	// telnet users get character-at-a-time input and full line editing. See
+	// ServeTelnet.
+	Telnet bool
+
+	// Detachable keeps a session alive when its connection drops, with its
+	// handler, history, and any partly entered input, so that the user can
+	// reattach to it from a new connection. On connecting, the user is asked
+	// for a session name: the name of a detached session resumes it, if the
+	// client offers the credentials the session was started with, or comes
+	// from the same host when the server has no WithAuthentication. Any other
+	// name starts a new session with that name, unless it is in use, and no
+	// name starts one with a random name. Ending input with Ctrl-D still ends
+	// the session for good.
+	Detachable bool
+
+	// DetachedTimeout, if positive, is how long a detached session is kept
+	// before its handler is stopped and it is discarded.
+	DetachedTimeout time.Duration
+
+	// Options configure every session run by the server.
+	Options []Option
+
+	// TLSConfig, if not nil, makes Serve wrap each connection in TLS. To
+	// verify client certificates, set its ClientCAs and ClientAuth; the
+	// verified chain is passed to the check given to WithAuthentication.
+	TLSConfig *tls.Config
+
+	mu       sync.Mutex
+	active   map[string]bool
+	detached map[string]*detachedSession
+}
+
+type detachedSession struct {
+	s     *session
+	owner string //the owner of the credentials the session was started with
+	timer *time.Timer
+}
+
+// Serve accepts connections on l and runs a REPL session on each one, with a
+// fresh handler obtained from newHandler for every connection. It can be used
+// with a TCP or Unix socket listener to give a long running program an admin
+// console. Serve returns when Accept fails, for example because l was closed.
+//
+// Sessions run in plain line mode: the client is expected to edit and echo
+// each line itself and send it terminated by CR, LF, or CRLF, as telnet and
+// netcat do by default. The handler's results and prompts are written back to
+// the connection; anything it prints directly still goes to the server's own
+// standard output.
+func Serve(l net.Listener, newHandler HandlerFactory) error {
+	srv := &Server{NewHandler: newHandler}
+	return srv.Serve(l)
+}
+
+// Serve accepts connections on l and runs a session on each one, returning
+// when Accept fails.
+func (srv *Server) Serve(l net.Listener) error {
+	if srv.TLSConfig != nil {
+		l = tls.NewListener(l, srv.TLSConfig)
+	}
+	return srv.serve(l)
+}
+
+// serve accepts connections on l until Accept fails.
+func (srv *Server) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// ServeTLS is like Serve, but wraps each connection in TLS, with the
+// certificate and matching private key in the given PEM files, and any other
+// settings from srv.TLSConfig.
+func (srv *Server) ServeTLS(l net.Listener, certFile string, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{}
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+	}
+	config.Certificates = append(config.Certificates, cert)
+	return srv.serve(tls.NewListener(l, config))
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	creds := Credentials{RemoteAddr: conn.RemoteAddr().String()}
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 {
+			creds.Certificates = chains[0]
+		}
+	}
+	var rw io.ReadWriter = conn
+	var t *telnet
+	charMode := false
+	if srv.Telnet {
+		t = newTelnet(conn)
+		charMode = t.negotiate(time.Second)
+		rw = t
+	}
+	s := newSession(nil, crlfWriter{rw}, srv.Options...)
+	if t != nil {
+		s.cols, s.rows = t.cols, t.rows
+		t.resized = func(cols, rows int) {
+			s.post(func() { s.resize(cols, rows) })
+		}
+	}
+	go s.feed(rw)
+	if !s.authenticate(&creds, charMode) {
+		close(s.stopped)
+		return
+	}
+	name, owner := "", creds.owner()
+	if srv.Detachable {
+		var ok bool
+		if name, ok = srv.attach(s, owner, charMode); !ok {
+			close(s.stopped)
+			return
+		}
+		defer srv.release(name)
+	}
+	if s.handler == nil {
+		s.handler = srv.NewHandler()
+	}
+	s.isolate(creds, name)
+	var err error
+	if charMode {
+		err = s.run()
+	} else {
+		err = s.runLines()
+	}
+	if err == errDetached {
+		srv.detach(name, owner, s)
+	}
+}
+
+// attach asks the user for a session name and, if it names a detached
+// session of owner's, gives s that session's state. A new session is given
+// the name typed if no other session has it, and a random one otherwise. It
+// returns false if the connection goes away first.
+func (srv *Server) attach(s *session, owner string, echo bool) (string, bool) {
+	s.putString(s.messages.SessionPrompt)
+	name, ok := s.readLine(echo)
+	if !ok {
+		return "", false
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.active == nil {
+		srv.active = make(map[string]bool)
+		srv.detached = make(map[string]*detachedSession)
+	}
+	if ds, ok := srv.detached[name]; ok && ds.owner == owner {
+		if ds.timer != nil {
+			ds.timer.Stop()
+		}
+		delete(srv.detached, name)
+		s.resume(ds.s)
+		s.putString(fmt.Sprintf(s.messages.SessionAttached+"\n", name))
+	} else {
+		for name == "" || srv.active[name] || srv.detached[name] != nil {
+			name = sessionName()
+		}
+		s.putString(fmt.Sprintf(s.messages.SessionStarted+"\n", name))
+	}
+	srv.active[name] = true
+	s.detachable = true
+	return name, true
+}
+
+// resume gives s, a session on a new connection, the state of old, a
+// detached one: its handler, the line being edited and the history, any
+// unfinished entry or heredoc, the count of entries evaluated and the last
+// result, the histories of the handler's other modes, and the aliases and
+// abbreviations defined since it started.
+func (s *session) resume(old *session) {
+	s.handler = old.handler
+	s.buf = old.buf
+	s.prompt, s.promptText, s.promptPlain = old.prompt, old.promptText, old.promptPlain
+	s.partial, s.pasted = old.partial, old.pasted
+	s.heredocEnd, s.heredocLines = old.heredocEnd, old.heredocLines
+	s.evaluated, s.result = old.evaluated, old.result
+	s.historyName, s.histories = old.historyName, old.histories
+	s.aliases, s.abbreviations = old.aliases, old.abbreviations
+}
+
+// detach keeps the state of a session whose connection has dropped, for owner
+// to resume.
+func (srv *Server) detach(name string, owner string, s *session) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ds := &detachedSession{s: s, owner: owner}
+	if srv.DetachedTimeout > 0 {
+		ds.timer = time.AfterFunc(srv.DetachedTimeout, func() {
+			srv.mu.Lock()
+			expired := srv.detached[name] == ds
+			if expired {
+				delete(srv.detached, name)
+			}
+			srv.mu.Unlock()
+			if expired {
+				s.handler.Stop(s.buf.history)
+			}
+		})
+	}
+	srv.detached[name] = ds
+}
+
+// sessionName returns a random name for a new session, which can't be
+// guessed by other clients as a sequence number could.
+func sessionName() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (srv *Server) release(name string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	delete(srv.active, name)
+}
+
+// readLine reads a line of input outside of the editing loop, echoing it if
+// the client does not echo for itself. It returns false if the session ends
+// first.
+func (s *session) readLine(echo bool) (string, bool) {
+	var line []byte
+	for {
+		ch, ok := s.getChar()
+		if !ok {
+			return "", false
+		}
+		switch ch {
+		case RETURN, NEWLINE:
+			if echo {
+				s.putString("\n")
+			}
+			return string(line), true
+		case DELETE, BACKSPACE:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				if echo {
+					s.putString("\b \b")
+				}
+			}
+		default:
+			if ch >= SPACE && (s.maxLineLength == 0 || len(line) < s.maxLineLength) {
+				line = append(line, ch)
+				if echo {
+					s.putChar(ch)
+				}
+			}
+		}
+	}
+}
+
+// feed passes input read from r to the session, in chunks of whatever is
+// available, ending the session when r is exhausted.
+func (s *session) feed(r io.Reader) {
+	var limiter *rateLimiter
+	if s.inputRate > 0 {
+		limiter = newRateLimiter(s.inputRate, s.inputBurst)
+	}
+	for {
+		s.waitHeld()
+		var data [1024]byte
+		n, err := r.Read(data[:])
+		if n > 0 {
+			if limiter != nil {
+				limiter.wait(n)
+			}
+			select {
+			case s.input <- data[:n]:
+			case <-s.stopped:
+				return
+			}
+		}
+		if err == errReadCanceled && s.waitHeld() {
+			continue //held for a subprocess, and now let go
+		}
+		if err != nil {
+			s.post(func() { s.done = true })
+			return
+		}
+	}
+}
+
+// runLines runs the session without any editing or echo of its own, for
+// clients that send a whole line at a time.
+func (s *session) runLines() error {
+	defer close(s.stopped)
+	s.lineMode = true
+	buf := s.begin()
+	var lastChar byte
+	tooLong := false
+	for true {
+		ch, ok := s.getChar()
+		if !ok {
+			return s.end()
+		}
+		switch ch {
+		case 0:
+			//telnet sends CR NUL for a bare carriage return
+		case NEWLINE, RETURN:
+			if ch == NEWLINE && lastChar == RETURN {
+				break
+			}
+			if tooLong {
+				tooLong = false
+				buf.Clear()
+				s.putString(s.messages.LineTooLong + "\n")
+				s.showPrompt()
+				break
+			}
+			str := buf.String()
+			buf.AddToHistory(str)
+			buf.Clear()
+			if str, ok := s.heredocLine(str); ok {
+				s.eval(s.expandAlias(str))
+			}
+		case CTRL_D:
+			if buf.IsEmpty() {
+				s.thisCommand = "delete-char-or-eof"
+				if s.eof(); s.exit {
+					return nil
+				}
+			}
+		default:
+			if buf.fit(1) == 0 {
+				tooLong = true
+			}
+			buf.Insert(ch)
+		}
+		lastChar = ch
+		s.lastCommand, s.thisCommand = s.thisCommand, ""
+	}
+	return nil //never happens
+}
+
+// crlfWriter translates newlines into the CRLF pairs expected by a remote
+// terminal, which does no output processing of its own.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (cw crlfWriter) Write(p []byte) (int, error) {
+	_, err := cw.w.Write(bytes.Replace(p, []byte{'\n'}, []byte{'\r', '\n'}, -1))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
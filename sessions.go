@@ -0,0 +1,363 @@
+package repl
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A HandlerFactory returns the handler for a new session. Serve, ServeTelnet,
+// WebSocketHandler, GRPCHandler and HTTPHandler call it once for every
+// connection or client session, so that each gets a handler of its own, with
+// its own history and prompt state, and none can see what another has
+// evaluated. To have the sessions share state instead, pass the Handler
+// method of a manager made with Shared, which makes that explicit.
+type HandlerFactory func() ReplHandler
+
+// SessionManager hands out the handlers for sessions that may run at the same
+// time, such as a local terminal alongside network and protocol sessions. A
+// manager either serializes every session's calls onto one shared handler, or
+// isolates the sessions by giving each its own handler from a factory.
+//
+// Its Handler method is a HandlerFactory, to be passed to Serve and the other
+// server functions:
+//
+//	m := repl.Shared(handler)
+//	go repl.Serve(listener, m.Handler)
+//	repl.REPL(m.Handler())
+type SessionManager struct {
+	lock       chan struct{} //holds a value while a session calls the shared handler
+	shared     ReplHandler
+	newHandler HandlerFactory
+}
+
+// Shared returns a manager whose sessions all use handler, with calls from the
+// different sessions made one at a time. While one session is in Eval, the
+// others wait for it before they can evaluate, complete, or draw a prompt,
+// unless the session evaluating abandons the evaluation, which lets them go
+// on while it finishes. Each session still calls Start and Stop, so a handler
+// that persists history in Stop should expect to be called once per session.
+// The sessions also share the prompt, and any history file, which is not
+// namespaced per session as it is for isolated sessions (see
+// WithHistoryNamespace). The optional interfaces the handler implements are
+// used as they would be without the manager, and called in turn in the same
+// way, except for Interrupt, which is called straight away. The handler is
+// given the evaluating session's Progress, Raw and entry number just before
+// each Eval, rather than when the session starts.
+func Shared(handler ReplHandler) *SessionManager {
+	return &SessionManager{lock: make(chan struct{}, 1), shared: handler}
+}
+
+// Isolated returns a manager that gives every session its own handler from
+// newHandler, so that the sessions share no handler state at all.
+func Isolated(newHandler HandlerFactory) *SessionManager {
+	return &SessionManager{newHandler: newHandler}
+}
+
+// Handler returns the handler to be used by a new session.
+func (m *SessionManager) Handler() ReplHandler {
+	if m.newHandler != nil {
+		return m.newHandler()
+	}
+	return &serializedHandler{lock: m.lock, handler: m.shared}
+}
+
+// serializedHandler makes one session's calls to a shared handler while
+// holding the lock shared by all of the sessions. It implements every
+// optional interface, doing what the session would do without it for those
+// the shared handler doesn't implement.
+type serializedHandler struct {
+	lock    chan struct{}
+	handler ReplHandler
+
+	mu        sync.Mutex
+	abandoned chan struct{} //closed when the session stops waiting for Eval
+	progress  *Progress     //the session's, given to the shared handler before each Eval
+	raw       *Raw          //the session's, given to the shared handler before each Eval
+	number    int           //the number of the next Eval, or 0 if it isn't numbered
+}
+
+// Unwrapper may be implemented by a handler that wraps another and passes its
+// calls on, such as the recorder of the repltest package, so that sessions
+// can tell which of the optional interfaces, such as Tokenizer, the handler
+// it wraps implements. The wrapper itself must implement all of them,
+// passing each on, or doing what the session would do without it.
+type Unwrapper interface {
+	Unwrap() ReplHandler
+}
+
+// implemented returns the handler whose optional interfaces h offers: h
+// itself, or the handler behind a serializedHandler or an Unwrapper.
+func implemented(h ReplHandler) ReplHandler {
+	for {
+		switch w := h.(type) {
+		case *serializedHandler:
+			h = w.handler
+		case Unwrapper:
+			h = w.Unwrap()
+		default:
+			return h
+		}
+	}
+}
+
+// serialized returns the serializedHandler h is or wraps, if any.
+func serialized(h ReplHandler) (*serializedHandler, bool) {
+	for {
+		switch w := h.(type) {
+		case *serializedHandler:
+			return w, true
+		case Unwrapper:
+			h = w.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+}
+
+func (sh *serializedHandler) acquire() {
+	sh.lock <- struct{}{}
+}
+
+func (sh *serializedHandler) release() {
+	<-sh.lock
+}
+
+// Eval evaluates expr once the other sessions' calls are done, holding the
+// lock until Eval returns or the session abandons it. The shared handler is
+// given this session's Progress, Raw and entry number first, so that what it
+// reports and takes over during Eval is the evaluating session's.
+func (sh *serializedHandler) Eval(expr string) (string, bool, error) {
+	abandoned := make(chan struct{})
+	sh.mu.Lock()
+	sh.abandoned = abandoned
+	progress, raw, number := sh.progress, sh.raw, sh.number
+	sh.number = 0
+	sh.mu.Unlock()
+	select {
+	case sh.lock <- struct{}{}:
+	case <-abandoned:
+		return "", false, errAbandoned //given up on before its turn came
+	}
+	if pr, ok := sh.handler.(ProgressReporter); ok && progress != nil {
+		pr.SetProgress(progress)
+	}
+	if rt, ok := sh.handler.(RawTaker); ok && raw != nil {
+		rt.SetRaw(raw)
+	}
+	if nb, ok := sh.handler.(Numbered); ok && number > 0 {
+		nb.SetNumber(number)
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+		case <-abandoned:
+		}
+		sh.release()
+	}()
+	return sh.handler.Eval(expr)
+}
+
+// abandon lets the other sessions go on while the Eval the session has
+// stopped waiting for finishes by itself.
+func (sh *serializedHandler) abandon() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.abandoned != nil {
+		close(sh.abandoned)
+		sh.abandoned = nil
+	}
+}
+
+func (sh *serializedHandler) Complete(expr string) (string, []string) {
+	sh.acquire()
+	defer sh.release()
+	return sh.handler.Complete(expr)
+}
+
+func (sh *serializedHandler) Reset() {
+	sh.acquire()
+	defer sh.release()
+	sh.handler.Reset()
+}
+
+func (sh *serializedHandler) ResetFor(reason ResetReason, discarded string) {
+	sh.acquire()
+	defer sh.release()
+	resetHandler(sh.handler, reason, discarded)
+}
+
+func (sh *serializedHandler) Prompt() string {
+	sh.acquire()
+	defer sh.release()
+	return sh.handler.Prompt()
+}
+
+func (sh *serializedHandler) Start() []string {
+	sh.acquire()
+	defer sh.release()
+	return sh.handler.Start()
+}
+
+func (sh *serializedHandler) Stop(history []string) {
+	sh.acquire()
+	defer sh.release()
+	sh.handler.Stop(history)
+}
+
+// Interrupt is called without the lock, which the Eval it interrupts holds.
+func (sh *serializedHandler) Interrupt() {
+	if i, ok := sh.handler.(Interrupter); ok {
+		i.Interrupt()
+	}
+}
+
+func (sh *serializedHandler) CompletionTriggers() string {
+	sh.acquire()
+	defer sh.release()
+	if ac, ok := sh.handler.(AutoCompleter); ok {
+		return ac.CompletionTriggers()
+	}
+	return ""
+}
+
+func (sh *serializedHandler) Tokens(line string) []Token {
+	sh.acquire()
+	defer sh.release()
+	if t, ok := sh.handler.(Tokenizer); ok {
+		return t.Tokens(line)
+	}
+	return nil
+}
+
+func (sh *serializedHandler) Resized(cols, rows int) {
+	sh.acquire()
+	defer sh.release()
+	if r, ok := sh.handler.(Resizer); ok {
+		r.Resized(cols, rows)
+	}
+}
+
+func (sh *serializedHandler) ConfirmExit() bool {
+	sh.acquire()
+	defer sh.release()
+	if c, ok := sh.handler.(ExitConfirmer); ok {
+		return c.ConfirmExit()
+	}
+	return true
+}
+
+// SetRaw, SetProgress and SetNumber keep what the session gives them for its
+// next Eval, as the other sessions would overwrite it in the shared handler.
+func (sh *serializedHandler) SetRaw(r *Raw) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.raw = r
+}
+
+func (sh *serializedHandler) SetProgress(p *Progress) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.progress = p
+}
+
+func (sh *serializedHandler) SetNumber(n int) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.number = n
+}
+
+func (sh *serializedHandler) HistoryName() string {
+	sh.acquire()
+	defer sh.release()
+	return historyName(sh.handler)
+}
+
+func (sh *serializedHandler) Describe(candidate string) string {
+	sh.acquire()
+	defer sh.release()
+	if d, ok := sh.handler.(Describer); ok {
+		return d.Describe(candidate)
+	}
+	return ""
+}
+
+func (sh *serializedHandler) StyledPrompt() StyledText {
+	sh.acquire()
+	defer sh.release()
+	if sp, ok := sh.handler.(StyledPrompter); ok {
+		return sp.StyledPrompt()
+	}
+	return Text(sh.handler.Prompt())
+}
+
+func (sh *serializedHandler) Symbols() []string {
+	sh.acquire()
+	defer sh.release()
+	if sym, ok := sh.handler.(Symbolizer); ok {
+		return sym.Symbols()
+	}
+	return nil
+}
+
+func (sh *serializedHandler) Tick() {
+	sh.acquire()
+	defer sh.release()
+	if t, ok := sh.handler.(Ticker); ok {
+		t.Tick()
+	}
+}
+
+// WithHistoryNamespace sets fn to name the history namespace of a network
+// session from its client's credentials. Network sessions given a history
+// file with WithHistoryFile each keep their history in a file of their own,
+// named by adding a dot and the namespace to the path, so that one client
+// does not recall lines entered by another. By default the namespace is the
+// session name on a detachable Server and the client's host otherwise. If fn
+// returns an empty string the session keeps no history file. Sessions sharing
+// a handler made with Shared share the history file as it is.
+func WithHistoryNamespace(fn func(c Credentials) string) Option {
+	return func(s *session) {
+		s.historyNamespace = fn
+	}
+}
+
+// isolate namespaces the history file of a network session, once its handler
+// has been chosen, given the client's credentials and the session's name, if
+// it has one.
+func (s *session) isolate(c Credentials, name string) {
+	if s.historyFile == "" {
+		return
+	}
+	if _, shared := serialized(s.handler); shared {
+		return
+	}
+	ns := name
+	if s.historyNamespace != nil {
+		ns = s.historyNamespace(c)
+	} else if ns == "" {
+		ns = c.RemoteAddr
+		if host, _, err := net.SplitHostPort(ns); err == nil {
+			ns = host
+		}
+	}
+	if ns == "" {
+		s.historyFile = ""
+		return
+	}
+	s.historyFile = filepath.Clean(s.historyFile + "." + fileSafe(ns))
+}
+
+// fileSafe returns name with anything but letters, digits, dots, dashes and
+// underscores replaced, for use in a file name.
+func fileSafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,114 @@
+package repl
+
+import (
+	"sync"
+	"time"
+)
+
+// SpinnerFrames are the frames spinners show by default, which every
+// terminal can display.
+var SpinnerFrames = []string{"|", "/", "-", "\\"}
+
+// spinnerInterval is how long each frame of a spinner is shown by default.
+const spinnerInterval = 100 * time.Millisecond
+
+// Spinner shows that work is going on, with a label beside an animation,
+// drawn by the session so that it doesn't disturb the line being edited.
+type Spinner struct {
+	p        *Progress
+	mu       sync.Mutex
+	label    string
+	frames   []string
+	interval time.Duration
+	frame    int
+	stop     chan struct{}
+}
+
+// NewSpinner returns a spinner with the given label, which is not shown until
+// it is started.
+func (p *Progress) NewSpinner(label string) *Spinner {
+	return &Spinner{p: p, label: label, frames: SpinnerFrames, interval: spinnerInterval}
+}
+
+// SetLabel changes the text shown beside the spinner.
+func (sp *Spinner) SetLabel(label string) {
+	sp.mu.Lock()
+	sp.label = label
+	sp.mu.Unlock()
+}
+
+// SetFrames changes the frames of the spinner's animation, and how long each
+// is shown.
+func (sp *Spinner) SetFrames(frames []string, interval time.Duration) {
+	sp.mu.Lock()
+	if len(frames) > 0 {
+		sp.frames = frames
+	}
+	if interval > 0 {
+		sp.interval = interval
+	}
+	sp.mu.Unlock()
+}
+
+// Start shows the spinner, until Stop is called or the session ends.
+func (sp *Spinner) Start() {
+	sp.mu.Lock()
+	if sp.stop != nil {
+		sp.mu.Unlock()
+		return
+	}
+	sp.stop = make(chan struct{})
+	stop, interval := sp.stop, sp.interval
+	sp.mu.Unlock()
+	p := sp.p
+	p.mu.Lock()
+	p.spinners = append(p.spinners, sp)
+	p.mu.Unlock()
+	p.changed()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sp.mu.Lock()
+				sp.frame++
+				sp.mu.Unlock()
+				p.changed()
+			case <-stop:
+				return
+			case <-p.s.stopped:
+				return
+			}
+		}
+	}()
+}
+
+// Stop removes the spinner.
+func (sp *Spinner) Stop() {
+	sp.mu.Lock()
+	if sp.stop == nil {
+		sp.mu.Unlock()
+		return
+	}
+	close(sp.stop)
+	sp.stop = nil
+	sp.mu.Unlock()
+	p := sp.p
+	p.mu.Lock()
+	for i, other := range p.spinners {
+		if other == sp {
+			p.spinners = append(p.spinners[:i], p.spinners[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	p.changed()
+}
+
+// line returns the spinner as it should be drawn now.
+func (sp *Spinner) line() string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.frames[sp.frame%len(sp.frames)] + " " + sp.label
+}
@@ -0,0 +1,107 @@
+package repl
+
+import "io"
+
+// WithPlainOutput removes escape sequences, and control characters other
+// than newlines and tabs, from everything the session writes, for output
+// that goes to a file or a pipe rather than a terminal. REPL and Wrap turn
+// it on when stdout isn't a terminal, unless CLICOLOR_FORCE asks for color
+// anyway. Anything the session's output is recorded or traced with gets
+// the plain output too.
+func WithPlainOutput(on bool) Option {
+	return func(s *session) {
+		s.plainOutput = on
+	}
+}
+
+// WithTranscript writes a clean text transcript of the session to w: each
+// line entered, after the prompt it was entered at, and the result or error
+// the session printed for it, without escape sequences or any of the editing
+// that went into the line. Output the handler writes itself, rather than
+// returning as its result, isn't included.
+func WithTranscript(w io.Writer) Option {
+	return func(s *session) {
+		s.transcript = &plainWriter{w: w}
+	}
+}
+
+// escapeParser follows the escape sequences in a stream of output, a byte at
+// a time, carrying on across writes.
+type escapeParser struct {
+	state int
+}
+
+// The states of an escapeParser.
+const (
+	inText   = iota
+	inEscape //after ESC
+	inCSI    //after ESC [
+	inString //in an OSC, DCS or other string, which ends with BEL or ESC \
+	inStringEscape
+)
+
+// text takes the next byte of output, and returns true if it is text rather
+// than part of an escape sequence.
+func (p *escapeParser) text(c byte) bool {
+	switch p.state {
+	case inEscape:
+		switch c {
+		case '[':
+			p.state = inCSI
+		case ']', 'P', '_', '^', 'X':
+			p.state = inString
+		default:
+			if c < '0' || c > '~' {
+				return false //an intermediate byte, such as the ( of ESC ( B
+			}
+			p.state = inText
+		}
+		return false
+	case inCSI:
+		if c >= '@' && c <= '~' {
+			p.state = inText
+		}
+		return false
+	case inString:
+		if c == BEEP {
+			p.state = inText
+		} else if c == ESCAPE {
+			p.state = inStringEscape
+		}
+		return false
+	case inStringEscape:
+		p.state = inString
+		if c == '\\' {
+			p.state = inText
+		}
+		return false
+	}
+	if c == ESCAPE {
+		p.state = inEscape
+		return false
+	}
+	return true
+}
+
+// plainWriter passes output on with its escape sequences and control
+// characters removed.
+type plainWriter struct {
+	w      io.Writer
+	parser escapeParser
+	buf    []byte
+}
+
+func (pw *plainWriter) Write(p []byte) (int, error) {
+	pw.buf = pw.buf[:0]
+	for _, c := range p {
+		if pw.parser.text(c) && (c >= ' ' || c == '\n' || c == '\t') && c != DELETE {
+			pw.buf = append(pw.buf, c)
+		}
+	}
+	if len(pw.buf) > 0 {
+		if _, err := pw.w.Write(pw.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
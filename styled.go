@@ -0,0 +1,117 @@
+package repl
+
+import (
+	"strings"
+	"time"
+)
+
+// StyledText is text made of runs in different styles, kept apart from the
+// escape sequences that draw them, so that the session always knows how
+// wide it is. Prompts and toolbars given as StyledText, rather than strings
+// with escape sequences in them, never leave the cursor in the wrong place.
+// The zero StyledText is empty.
+type StyledText struct {
+	spans []textSpan
+}
+
+// textSpan is a run of StyledText in a single style.
+type textSpan struct {
+	style Style
+	text  string
+}
+
+// Text returns text as StyledText in the default style of wherever it is
+// drawn, such as the theme's Prompt style for a prompt.
+func Text(text string) StyledText {
+	return Styled(Style{}, text)
+}
+
+// Styled returns text as StyledText in the given style.
+func Styled(style Style, text string) StyledText {
+	if text == "" {
+		return StyledText{}
+	}
+	return StyledText{spans: []textSpan{{style, text}}}
+}
+
+// Add returns t followed by text in the given style.
+func (t StyledText) Add(style Style, text string) StyledText {
+	return t.Append(Styled(style, text))
+}
+
+// Append returns t followed by u.
+func (t StyledText) Append(u StyledText) StyledText {
+	spans := make([]textSpan, 0, len(t.spans)+len(u.spans))
+	return StyledText{spans: append(append(spans, t.spans...), u.spans...)}
+}
+
+// Plain returns the text without its styles.
+func (t StyledText) Plain() string {
+	var sb strings.Builder
+	for _, span := range t.spans {
+		sb.WriteString(span.text)
+	}
+	return sb.String()
+}
+
+// Width returns the number of columns the text takes up on the terminal.
+func (t StyledText) Width() int {
+	w := 0
+	for _, span := range t.spans {
+		w += displayWidth([]byte(span.text))
+	}
+	return w
+}
+
+// String returns the text with the escape sequences that draw it, for
+// writing it out directly.
+func (t StyledText) String() string {
+	var sb strings.Builder
+	for _, span := range t.spans {
+		if sgr := span.style.SGR(); sgr != "" {
+			sb.WriteString("\033[" + sgr + "m" + span.text + sgrReset)
+		} else {
+			sb.WriteString(span.text)
+		}
+	}
+	return sb.String()
+}
+
+// StyledPrompter may be implemented by a handler whose prompt has styles of
+// its own. StyledPrompt is then called instead of Prompt, and the parts of
+// the prompt in the default style are drawn in the theme's Prompt style.
+type StyledPrompter interface {
+	StyledPrompt() StyledText
+}
+
+// WithStyledToolbar is WithToolbar for a toolbar given as StyledText, whose
+// parts in the default style are drawn in the theme's Toolbar style.
+func WithStyledToolbar(fn func() StyledText, refresh time.Duration) Option {
+	return func(s *session) {
+		s.toolbar = func() string {
+			s.toolbarText = fn()
+			return s.toolbarText.String()
+		}
+		s.styledToolbar = true
+		s.toolbarRefresh = refresh
+	}
+}
+
+// appendText appends the bytes of t from from to to, as the plain text
+// counts them, to frame, with the parts in the default style in base.
+func (s *session) appendText(frame []byte, t StyledText, base string, from, to int) []byte {
+	at := 0
+	for _, span := range t.spans {
+		start, end := at, at+len(span.text)
+		at = end
+		if end <= from || start >= to {
+			continue
+		}
+		sgr := span.style.SGR()
+		if sgr == "" {
+			sgr = base
+		}
+		frame = s.appendStyled(frame, sgr, []byte(span.text[clamp(from-start, 0, len(span.text)):clamp(to-start, 0, len(span.text))]))
+	}
+	return frame
+}
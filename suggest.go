@@ -0,0 +1,130 @@
+package repl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Symbolizer may be implemented by a handler that can list the names it
+// knows, such as the functions and variables defined, for the session to
+// suggest when completion finds nothing for a misspelled word.
+type Symbolizer interface {
+	Symbols() []string
+}
+
+// maxSuggestions is the most near misses suggested at once.
+const maxSuggestions = 3
+
+// WithSuggestions turns on suggestions for completion misses: when TAB finds
+// no candidates, the names the handler lists, if it is a Symbolizer, and the
+// words in the history are searched for ones a typo or two away from the
+// word before the cursor, which are shown below the line. The line itself
+// is left alone.
+func WithSuggestions(on bool) Option {
+	return func(s *session) {
+		s.suggest = on
+	}
+}
+
+// suggestions returns the near misses for the word before the cursor,
+// closest first.
+func (s *session) suggestions() []string {
+	buf := s.buf
+	start := buf.cursor
+	for start > 0 && isDabbrevChar(buf.at(start-1)) {
+		start--
+	}
+	word := string(buf.buf[start:buf.cursor])
+	if len(word) < 2 {
+		return nil
+	}
+	limit := 1 + len(word)/4
+	var words []string
+	if sym, ok := s.handler.(Symbolizer); ok {
+		words = sym.Symbols()
+	}
+	for _, line := range buf.history {
+		words = append(words, dabbrevWords(line)...)
+	}
+	distance := map[string]int{}
+	var near []string
+	for _, w := range words {
+		if _, seen := distance[w]; seen || w == word {
+			continue
+		}
+		d := editDistance(word, w, limit)
+		distance[w] = d
+		if d <= limit {
+			near = append(near, w)
+		}
+	}
+	sort.SliceStable(near, func(i, j int) bool {
+		return distance[near[i]] < distance[near[j]]
+	})
+	if len(near) > maxSuggestions {
+		near = near[:maxSuggestions]
+	}
+	return near
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters that turn a into b, or limit+1 if it
+// is more than limit.
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	prevBest := 0
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := prev[j-1] + cost
+			if prev[j]+1 < d {
+				d = prev[j] + 1
+			}
+			if cur[j-1]+1 < d {
+				d = cur[j-1] + 1
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && prev2[j-2]+1 < d {
+				d = prev2[j-2] + 1
+			}
+			cur[j] = d
+			if d < best {
+				best = d
+			}
+		}
+		if best > limit && prevBest > limit {
+			//a transposition reaches back two rows, so both must be past it
+			return limit + 1
+		}
+		prevBest = best
+		prev2, prev, cur = prev, cur, prev2
+	}
+	if prev[len(b)] > limit {
+		return limit + 1
+	}
+	return prev[len(b)]
+}
+
+// didYouMean shows the near misses for the word before the cursor below the
+// line, and reports whether there were any.
+func (s *session) didYouMean() bool {
+	near := s.suggestions()
+	if len(near) == 0 {
+		return false
+	}
+	s.showBelow([]string{fmt.Sprintf(s.messages.DidYouMean, strings.Join(near, ", "))})
+	return true
+}
@@ -0,0 +1,229 @@
+package repl
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetEcho     = 1
+	telnetSGA      = 3
+	telnetNAWS     = 31
+	telnetLinemode = 34
+)
+
+// ServeTelnet is like Serve, but speaks the telnet protocol to each client. It
+// asks the client to let the server echo (ECHO), to send characters as they
+// are typed (SGA), not to do its own line editing (LINEMODE), and to report
+// its window size (NAWS). If the client agrees, the session gets the full line
+// editing of the REPL; otherwise it falls back to plain line mode.
+func ServeTelnet(l net.Listener, newHandler HandlerFactory) error {
+	srv := &Server{NewHandler: newHandler, Telnet: true}
+	return srv.Serve(l)
+}
+
+// telnet reads and writes the data stream of a telnet connection, handling
+// the option negotiation embedded in it.
+type telnet struct {
+	conn     net.Conn
+	pending  []byte
+	state    int
+	verb     byte
+	sub      []byte
+	lastCR   bool
+	mu       sync.Mutex
+	echo     int //0 unanswered, 1 agreed, -1 refused
+	sga      int
+	answered chan struct{}
+	cols     int
+	rows     int
+	resized  func(cols, rows int)
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateVerb
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+// maxTelnetSub bounds how much of a subnegotiation is kept. Those the server
+// understands are far shorter; the rest of a longer one, as of one whose SE
+// never comes, is dropped.
+const maxTelnetSub = 64
+
+func newTelnet(conn net.Conn) *telnet {
+	return &telnet{conn: conn, answered: make(chan struct{})}
+}
+
+// negotiate offers the options the REPL wants and waits up to timeout for the
+// client to answer. It reports whether the client will send characters as they
+// are typed and leave the echoing to the server.
+func (t *telnet) negotiate(timeout time.Duration) bool {
+	t.conn.Write([]byte{
+		telnetIAC, telnetWILL, telnetEcho,
+		telnetIAC, telnetWILL, telnetSGA,
+		telnetIAC, telnetDONT, telnetLinemode,
+		telnetIAC, telnetDO, telnetNAWS,
+	})
+	deadline := time.Now().Add(timeout)
+	t.conn.SetReadDeadline(deadline)
+	var data [256]byte
+	for {
+		select {
+		case <-t.answered:
+		default:
+			n, err := t.conn.Read(data[:])
+			t.pending = append(t.pending, t.parse(data[:n])...)
+			if err == nil {
+				continue
+			}
+		}
+		break
+	}
+	t.conn.SetReadDeadline(time.Time{})
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.echo > 0 && t.sga > 0
+}
+
+// Read returns the data bytes sent by the client, with telnet commands
+// removed.
+func (t *telnet) Read(p []byte) (int, error) {
+	for len(t.pending) == 0 {
+		var data [1024]byte
+		n, err := t.conn.Read(data[:])
+		t.pending = t.parse(data[:n])
+		if err != nil && len(t.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// Write sends p to the client, doubling any IAC bytes in it.
+func (t *telnet) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b == telnetIAC {
+			out = append(out, telnetIAC)
+		}
+		out = append(out, b)
+	}
+	if _, err := t.conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// parse consumes bytes from the connection and returns the data among them.
+// The NUL or LF that follows a CR is dropped, so a typed return reaches the
+// session as a single CR.
+func (t *telnet) parse(in []byte) []byte {
+	var data []byte
+	for _, b := range in {
+		switch t.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				t.state = telnetStateIAC
+			} else {
+				if !(t.lastCR && (b == 0 || b == NEWLINE)) {
+					data = append(data, b)
+				}
+				t.lastCR = b == RETURN
+			}
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				data = append(data, b)
+				t.lastCR = false
+				t.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.verb = b
+				t.state = telnetStateVerb
+			case telnetSB:
+				t.sub = t.sub[:0]
+				t.state = telnetStateSub
+			default:
+				t.state = telnetStateData
+			}
+		case telnetStateVerb:
+			t.option(t.verb, b)
+			t.state = telnetStateData
+		case telnetStateSub:
+			if b == telnetIAC {
+				t.state = telnetStateSubIAC
+			} else if len(t.sub) < maxTelnetSub {
+				t.sub = append(t.sub, b)
+			}
+		case telnetStateSubIAC:
+			if b == telnetSE {
+				t.subnegotiation()
+				t.state = telnetStateData
+			} else {
+				if len(t.sub) < maxTelnetSub {
+					t.sub = append(t.sub, b)
+				}
+				t.state = telnetStateSub
+			}
+		}
+	}
+	return data
+}
+
+// option handles the client's answer to, or request for, a telnet option.
+func (t *telnet) option(verb byte, opt byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch verb {
+	case telnetDO, telnetDONT:
+		answer := 1
+		if verb == telnetDONT {
+			answer = -1
+		}
+		switch opt {
+		case telnetEcho:
+			t.echo = answer
+		case telnetSGA:
+			t.sga = answer
+		default:
+			if verb == telnetDO {
+				t.conn.Write([]byte{telnetIAC, telnetWONT, opt})
+			}
+			return
+		}
+		if t.echo != 0 && t.sga != 0 {
+			select {
+			case <-t.answered:
+			default:
+				close(t.answered)
+			}
+		}
+	case telnetWILL:
+		if opt != telnetNAWS {
+			t.conn.Write([]byte{telnetIAC, telnetDONT, opt})
+		}
+	}
+}
+
+func (t *telnet) subnegotiation() {
+	if len(t.sub) == 5 && t.sub[0] == telnetNAWS {
+		t.cols = int(t.sub[1])<<8 | int(t.sub[2])
+		t.rows = int(t.sub[3])<<8 | int(t.sub[4])
+		if t.resized != nil {
+			t.resized(t.cols, t.rows)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package term offers the line reading part of the API of
+// golang.org/x/term's Terminal on top of the repl package's line editor, so
+// that code written against it, such as an SSH server's shell, gains the
+// editing, history and completion of a repl session by changing its import
+// path:
+//
+//	t := term.NewTerminal(channel, "> ")
+//	for {
+//		line, err := t.ReadLine()
+//		if err != nil {
+//			break
+//		}
+//		fmt.Fprintln(t, "you said", line)
+//	}
+//
+// As with x/term, the caller puts a local terminal into raw mode itself, and
+// the Terminal sends a carriage return before each newline it writes.
+package term
+
+import (
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/boynton/repl"
+)
+
+// EscapeCodes are the escape sequences for colors and for resetting them,
+// for writing to a Terminal.
+type EscapeCodes struct {
+	Black, Red, Green, Yellow, Blue, Magenta, Cyan, White []byte
+	Reset                                                 []byte
+}
+
+// vt100EscapeCodes are the EscapeCodes of the terminals sessions draw for.
+var vt100EscapeCodes = EscapeCodes{
+	Black:   []byte("\033[30m"),
+	Red:     []byte("\033[31m"),
+	Green:   []byte("\033[32m"),
+	Yellow:  []byte("\033[33m"),
+	Blue:    []byte("\033[34m"),
+	Magenta: []byte("\033[35m"),
+	Cyan:    []byte("\033[36m"),
+	White:   []byte("\033[37m"),
+	Reset:   []byte("\033[0m"),
+}
+
+// Terminal reads lines from a stream of keystrokes and writes to the stream
+// that goes back to the terminal, as x/term's Terminal does.
+type Terminal struct {
+	// AutoCompleteCallback, if set, completes the line when TAB is pressed.
+	// It is given the line, the position of the cursor, and '\t' for the
+	// key, and returns the new line, and true, or false if it has no
+	// completion. Only a new line that adds to the part before the cursor
+	// is taken. Unlike x/term's, it isn't called for other keys.
+	AutoCompleteCallback func(line string, pos int, key rune) (newLine string, newPos int, ok bool)
+
+	// Escape holds the escape sequences for the colors of the terminal.
+	Escape *EscapeCodes
+
+	mu     sync.Mutex //held while reading a line
+	t      *streamTerminal
+	editor *repl.LineEditor
+	prompt string
+}
+
+// NewTerminal returns a Terminal that reads keystrokes from c and writes to
+// it, showing prompt before each line it reads.
+func NewTerminal(c io.ReadWriter, prompt string) *Terminal {
+	return NewTerminalOptions(c, prompt)
+}
+
+// NewTerminalOptions is NewTerminal with options, for features of the repl
+// package that x/term has no counterpart for.
+func NewTerminalOptions(c io.ReadWriter, prompt string, options ...repl.Option) *Terminal {
+	escape := vt100EscapeCodes
+	st := &streamTerminal{c: c, cols: 80, rows: 24}
+	//completion gives the whole line, with no space after it
+	options = append([]repl.Option{repl.WithCompletionSpace(false)}, options...)
+	t := &Terminal{Escape: &escape, t: st, editor: repl.NewLineEditor(st, options...), prompt: prompt}
+	t.editor.Complete = t.complete
+	return t
+}
+
+// ReadLine shows the prompt and returns the line entered, which is added to
+// the history. It returns io.EOF if the user presses Ctrl-D on an empty line
+// or Ctrl-C, or the input ends.
+func (t *Terminal) ReadLine() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line, err := t.editor.Edit(t.prompt, "")
+	if err == repl.ErrInterrupted {
+		return "", io.EOF
+	}
+	return line, err
+}
+
+// ReadPassword shows prompt and returns what is typed up to the end of the
+// line, without echoing it or adding it to the history.
+func (t *Terminal) ReadPassword(prompt string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.t, prompt)
+	var password []byte
+	var b [1]byte //read a byte at a time, so as to take nothing past the line
+	for {
+		if _, err := t.t.Read(b[:]); err != nil {
+			return "", err
+		}
+		switch ch := b[0]; ch {
+		case '\r', '\n':
+			io.WriteString(t.t, "\n")
+			return string(password), nil
+		case 3: //Ctrl-C
+			io.WriteString(t.t, "\n")
+			return "", io.EOF
+		case 4: //Ctrl-D
+			if len(password) == 0 {
+				io.WriteString(t.t, "\n")
+				return "", io.EOF
+			}
+		case 8, 127: //backspace
+			if len(password) > 0 {
+				_, n := utf8.DecodeLastRune(password)
+				password = password[:len(password)-n]
+			}
+		default:
+			if ch >= ' ' {
+				password = append(password, ch)
+			}
+		}
+	}
+}
+
+// SetPrompt changes the prompt shown for the lines read after it.
+func (t *Terminal) SetPrompt(prompt string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prompt = prompt
+}
+
+// SetSize tells the Terminal how big the terminal is, for the lines read
+// after it. The default is 80 columns and 24 rows.
+func (t *Terminal) SetSize(width, height int) error {
+	t.t.setSize(width, height)
+	return nil
+}
+
+// Write writes buf to the terminal, with a carriage return before each
+// newline. It may be called while ReadLine is running, but the line being
+// edited isn't redrawn after it.
+func (t *Terminal) Write(buf []byte) (int, error) {
+	return t.t.Write(buf)
+}
+
+// complete completes the line up to the cursor with the AutoCompleteCallback.
+func (t *Terminal) complete(line string) (string, []string) {
+	if t.AutoCompleteCallback == nil {
+		return "", nil
+	}
+	newLine, _, ok := t.AutoCompleteCallback(line, len(line), '\t')
+	if !ok || len(newLine) <= len(line) || newLine[:len(line)] != line {
+		return "", nil
+	}
+	return newLine[len(line):], []string{newLine}
+}
+
+// streamTerminal is the repl.Terminal for a stream, which sends a carriage
+// return before each newline written to it, as a tty with output processing
+// turned on would, and has no modes of its own.
+type streamTerminal struct {
+	c io.ReadWriter
+
+	mu   sync.Mutex //held while writing, and for the size
+	cols int
+	rows int
+	out  []byte
+}
+
+func (st *streamTerminal) Read(p []byte) (int, error) {
+	return st.c.Read(p)
+}
+
+func (st *streamTerminal) Write(p []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.out = st.out[:0]
+	for _, b := range p {
+		if b == '\n' {
+			st.out = append(st.out, '\r')
+		}
+		st.out = append(st.out, b)
+	}
+	if _, err := st.c.Write(st.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (st *streamTerminal) Size() (int, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.cols, st.rows
+}
+
+func (st *streamTerminal) setSize(cols, rows int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.cols, st.rows = cols, rows
+}
+
+func (st *streamTerminal) SetMode(mode repl.TerminalMode) error {
+	return nil
+}
@@ -0,0 +1,529 @@
+package repl
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Terminal is the device a session runs on: where its keystrokes come from,
+// where its output goes, and how big it is.
+type Terminal interface {
+	// Read returns keystrokes, as the bytes the terminal sends for them.
+	Read(p []byte) (int, error)
+	// Write draws on the terminal. As on a tty with output processing
+	// enabled, a newline moves to the start of the next line.
+	Write(p []byte) (int, error)
+	// Size returns the width and height of the terminal in characters, or
+	// zeros if they are not known.
+	Size() (cols, rows int)
+	// SetMode switches the terminal's input mode.
+	SetMode(mode TerminalMode) error
+}
+
+// TerminalMode is the input mode of a Terminal.
+type TerminalMode int
+
+const (
+	// ModeNormal is the mode the terminal was in before the session started,
+	// normally line at a time input with echo.
+	ModeNormal TerminalMode = iota
+	// ModeCbreak delivers each keystroke as it is typed, without echo, but
+	// still turns Ctrl-C and the like into signals. Sessions edit in this
+	// mode.
+	ModeCbreak
+	// ModeRaw delivers every keystroke as it is typed, without echo or
+	// signals.
+	ModeRaw
+)
+
+// RunTerminal runs a REPL session for handler on t, with t in cbreak mode for
+// the length of the session. It returns when the user ends the session, or
+// when reading from t fails, or ErrClosed when it is closed by a Control.
+func RunTerminal(t Terminal, handler ReplHandler, options ...Option) error {
+	return runTerminal(newSession(handler, t, options...), t)
+}
+
+func runTerminal(s *session, t Terminal) error {
+	if err := t.SetMode(ModeCbreak); err != nil {
+		return err
+	}
+	defer t.SetMode(ModeNormal)
+	s.terminal = t
+	if cols, rows := t.Size(); cols > 0 {
+		s.cols, s.rows = cols, rows
+	}
+	if w, ok := t.(sizeWatcher); ok {
+		stop := w.watchSize(func() {
+			s.post(func() { s.resize(t.Size()) })
+		})
+		defer stop()
+	}
+	fed := make(chan struct{})
+	go func() {
+		s.feed(t)
+		close(fed)
+	}()
+	err := s.run()
+	if c, ok := t.(readCanceler); ok && err == ErrClosed {
+		//a read left waiting would steal the next key from whoever reads next
+		c.cancelRead(true)
+		select {
+		case <-fed:
+		case <-time.After(readCancelWait):
+		}
+		c.cancelRead(false)
+	}
+	return err
+}
+
+// readCancelWait is how long runTerminal waits for the read of a closed
+// session to return.
+const readCancelWait = 100 * time.Millisecond
+
+// readCanceler is implemented by terminals whose reads can be made to return
+// without input. While reads are canceled, a Read waiting for input, or
+// starting to, returns an error instead.
+type readCanceler interface {
+	cancelRead(cancel bool)
+}
+
+// sizeWatcher is implemented by terminals that can say when their size
+// changes. watchSize calls changed, on some other goroutine, whenever it does,
+// until stop is called.
+type sizeWatcher interface {
+	watchSize(changed func()) (stop func())
+}
+
+// TTY returns the Terminal for the terminal device f, such as the slave side
+// of a pseudo-terminal, for running a session on a terminal other than the
+// process's own.
+func TTY(f *os.File) Terminal {
+	return &ttyTerminal{in: f, out: f}
+}
+
+// stdTTY is the process's own terminal, on stdin and stdout.
+var stdTTY = &ttyTerminal{in: os.Stdin, out: os.Stdout}
+
+// VirtualTerminal is a Terminal held entirely in memory, for testing the
+// editing and display of a session without a real tty. Keystrokes are given
+// to it with Type, and it keeps a model of the screen that the session's
+// output has drawn, understanding the control characters and escape
+// sequences that sessions write.
+type VirtualTerminal struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	input       []byte
+	closed      bool
+	canceled    bool //whether reads return without input
+	mode        TerminalMode
+	cols        int
+	rows        int
+	screen      [][]rune
+	row         int
+	col         int
+	top         int //the scrolling region, set by CSI r
+	bottom      int
+	saved       [2]int //the cursor position saved by ESC 7
+	bells       int
+	cursorShape CursorShape
+	output      []byte
+	escape      []byte //an incomplete escape sequence
+	utf         []byte //an incomplete UTF-8 character
+	resized     func() //tells the session the size has changed
+}
+
+// NewVirtualTerminal returns a blank virtual terminal of the given size.
+func NewVirtualTerminal(cols, rows int) *VirtualTerminal {
+	vt := &VirtualTerminal{cols: cols, rows: rows, bottom: rows - 1}
+	vt.cond = sync.NewCond(&vt.mu)
+	vt.screen = make([][]rune, rows)
+	for i := range vt.screen {
+		vt.screen[i] = vt.blankLine()
+	}
+	return vt
+}
+
+func (vt *VirtualTerminal) blankLine() []rune {
+	line := make([]rune, vt.cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// Type queues keys as input to the session, as if typed on the keyboard.
+func (vt *VirtualTerminal) Type(keys string) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.input = append(vt.input, keys...)
+	vt.cond.Broadcast()
+}
+
+// Resize changes the size of the terminal, as if its window had been
+// resized, keeping what fits of the screen.
+func (vt *VirtualTerminal) Resize(cols, rows int) {
+	vt.mu.Lock()
+	screen := make([][]rune, rows)
+	vt.cols, vt.rows = cols, rows
+	for i := range screen {
+		screen[i] = vt.blankLine()
+		if i < len(vt.screen) {
+			copy(screen[i], vt.screen[i])
+		}
+	}
+	vt.screen = screen
+	vt.row = clamp(vt.row, 0, rows-1)
+	vt.col = clamp(vt.col, 0, cols-1)
+	vt.top, vt.bottom = 0, rows-1
+	resized := vt.resized
+	vt.mu.Unlock()
+	if resized != nil {
+		resized()
+	}
+}
+
+func (vt *VirtualTerminal) watchSize(changed func()) func() {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.resized = changed
+	return func() {
+		vt.mu.Lock()
+		defer vt.mu.Unlock()
+		vt.resized = nil
+	}
+}
+
+// Close ends the input. Once the session has read everything typed so far,
+// it sees end of file and finishes.
+func (vt *VirtualTerminal) Close() error {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.closed = true
+	vt.cond.Broadcast()
+	return nil
+}
+
+// errReadCanceled is what a terminal's Read returns when the session reading
+// it has been closed.
+var errReadCanceled = errors.New("Read canceled")
+
+func (vt *VirtualTerminal) cancelRead(cancel bool) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.canceled = cancel
+	vt.cond.Broadcast()
+}
+
+func (vt *VirtualTerminal) Read(p []byte) (int, error) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	for len(vt.input) == 0 && !vt.closed && !vt.canceled {
+		vt.cond.Wait()
+	}
+	if vt.canceled {
+		return 0, errReadCanceled
+	}
+	if len(vt.input) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, vt.input)
+	vt.input = vt.input[n:]
+	return n, nil
+}
+
+func (vt *VirtualTerminal) Write(p []byte) (int, error) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.output = append(vt.output, p...)
+	for _, b := range p {
+		vt.interpret(b)
+	}
+	vt.cond.Broadcast()
+	return len(p), nil
+}
+
+func (vt *VirtualTerminal) Size() (int, int) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return vt.cols, vt.rows
+}
+
+func (vt *VirtualTerminal) SetMode(mode TerminalMode) error {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.mode = mode
+	return nil
+}
+
+// Mode returns the mode the terminal was last set to.
+func (vt *VirtualTerminal) Mode() TerminalMode {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return vt.mode
+}
+
+// Output returns everything written to the terminal so far, exactly as it
+// was written.
+func (vt *VirtualTerminal) Output() []byte {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return append([]byte(nil), vt.output...)
+}
+
+// Screen returns the lines of the screen, with trailing spaces removed.
+func (vt *VirtualTerminal) Screen() []string {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	lines := make([]string, len(vt.screen))
+	for i, line := range vt.screen {
+		lines[i] = strings.TrimRight(strings.ReplaceAll(string(line), "\x00", ""), " ")
+	}
+	return lines
+}
+
+// String returns the text of the screen, one line per row, without the
+// trailing blank rows.
+func (vt *VirtualTerminal) String() string {
+	lines := vt.Screen()
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Cursor returns the row and column of the cursor, counting from 0.
+func (vt *VirtualTerminal) Cursor() (row, col int) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return vt.row, vt.col
+}
+
+// Bells returns how many times the terminal has been told to beep.
+func (vt *VirtualTerminal) Bells() int {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return vt.bells
+}
+
+// CursorShape returns the shape the cursor has been set to.
+func (vt *VirtualTerminal) CursorShape() CursorShape {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	return vt.cursorShape
+}
+
+// interpret applies one byte of output to the screen.
+func (vt *VirtualTerminal) interpret(b byte) {
+	if len(vt.escape) > 0 {
+		vt.escape = append(vt.escape, b)
+		vt.escapeSequence()
+		return
+	}
+	if len(vt.utf) > 0 || b >= utf8.RuneSelf {
+		vt.utf = append(vt.utf, b)
+		if utf8.FullRune(vt.utf) {
+			r, _ := utf8.DecodeRune(vt.utf)
+			vt.utf = vt.utf[:0]
+			vt.put(r)
+		}
+		return
+	}
+	switch b {
+	case BEEP:
+		vt.bells++
+	case BACKSPACE:
+		if vt.col > 0 {
+			vt.col--
+		}
+	case RETURN:
+		vt.col = 0
+	case NEWLINE:
+		vt.col = 0
+		vt.lineFeed()
+	case TAB:
+		vt.col = (vt.col/8 + 1) * 8
+		if vt.col >= vt.cols {
+			vt.col = vt.cols - 1
+		}
+	case ESCAPE:
+		vt.escape = append(vt.escape, b)
+	default:
+		if b >= SPACE && b < DELETE {
+			vt.put(rune(b))
+		}
+	}
+}
+
+// put writes r at the cursor, wrapping to the next line at the right margin.
+// A wide character takes two cells, the second of which holds 0, and a
+// combining mark is left out.
+func (vt *VirtualTerminal) put(r rune) {
+	w := runeWidth(r)
+	if w == 0 {
+		return
+	}
+	if vt.col+w > vt.cols {
+		vt.col = 0
+		vt.lineFeed()
+	}
+	vt.screen[vt.row][vt.col] = r
+	vt.col++
+	if w == 2 {
+		vt.screen[vt.row][vt.col] = 0
+		vt.col++
+	}
+}
+
+// lineFeed moves the cursor down a line, scrolling at the bottom of the
+// scrolling region.
+func (vt *VirtualTerminal) lineFeed() {
+	if vt.row == vt.bottom {
+		copy(vt.screen[vt.top:vt.bottom+1], vt.screen[vt.top+1:vt.bottom+1])
+		vt.screen[vt.bottom] = vt.blankLine()
+	} else if vt.row < vt.rows-1 {
+		vt.row++
+	}
+}
+
+// reverseIndex moves the cursor up a line, scrolling down at the top of the
+// scrolling region.
+func (vt *VirtualTerminal) reverseIndex() {
+	if vt.row == vt.top {
+		copy(vt.screen[vt.top+1:vt.bottom+1], vt.screen[vt.top:vt.bottom])
+		vt.screen[vt.top] = vt.blankLine()
+	} else if vt.row > 0 {
+		vt.row--
+	}
+}
+
+// escapeSequence acts on the escape sequence collected so far, once it is
+// complete. CSI sequences and a few two-byte sequences change the screen; OSC
+// strings and anything else are ignored.
+func (vt *VirtualTerminal) escapeSequence() {
+	seq := vt.escape
+	if len(seq) < 2 {
+		return
+	}
+	switch seq[1] {
+	case '[':
+		final := seq[len(seq)-1]
+		if len(seq) == 2 || final < '@' || final > '~' {
+			return
+		}
+		vt.csi(string(seq[2:len(seq)-1]), final)
+	case ']':
+		if seq[len(seq)-1] != BEEP && !(seq[len(seq)-1] == '\\' && seq[len(seq)-2] == ESCAPE) {
+			return
+		}
+	case '7':
+		vt.saved = [2]int{vt.row, vt.col}
+	case '8':
+		vt.row, vt.col = vt.saved[0], vt.saved[1]
+	case 'D':
+		vt.lineFeed()
+	case 'E':
+		vt.col = 0
+		vt.lineFeed()
+	case 'M':
+		vt.reverseIndex()
+	}
+	vt.escape = vt.escape[:0]
+}
+
+func (vt *VirtualTerminal) csi(params string, final byte) {
+	var args []int
+	for _, p := range strings.Split(strings.TrimLeft(params, "?"), ";") {
+		n, _ := strconv.Atoi(p)
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+	line := vt.screen[vt.row]
+	switch final {
+	case 'A':
+		vt.row = clamp(vt.row-arg(0, 1), 0, vt.rows-1)
+	case 'B':
+		vt.row = clamp(vt.row+arg(0, 1), 0, vt.rows-1)
+	case 'C':
+		vt.col = clamp(vt.col+arg(0, 1), 0, vt.cols-1)
+	case 'D':
+		vt.col = clamp(clamp(vt.col, 0, vt.cols-1)-arg(0, 1), 0, vt.cols-1)
+	case 'G':
+		vt.col = clamp(arg(0, 1)-1, 0, vt.cols-1)
+	case 'H', 'f':
+		vt.row = clamp(arg(0, 1)-1, 0, vt.rows-1)
+		vt.col = clamp(arg(1, 1)-1, 0, vt.cols-1)
+	case 'J':
+		from, to := vt.row+1, vt.rows
+		switch arg(0, 0) {
+		case 0:
+			vt.clear(line, vt.col, vt.cols)
+		case 1:
+			from, to = 0, vt.row
+			vt.clear(line, 0, vt.col+1)
+		case 2, 3:
+			from = 0
+		}
+		for i := from; i < to; i++ {
+			vt.screen[i] = vt.blankLine()
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			vt.clear(line, vt.col, vt.cols)
+		case 1:
+			vt.clear(line, 0, vt.col+1)
+		case 2:
+			vt.clear(line, 0, vt.cols)
+		}
+	case '@':
+		if vt.col < vt.cols {
+			n := clamp(arg(0, 1), 0, vt.cols-vt.col)
+			copy(line[vt.col+n:], line[vt.col:])
+			vt.clear(line, vt.col, vt.col+n)
+		}
+	case 'r':
+		vt.top, vt.bottom = arg(0, 1)-1, arg(1, vt.rows)-1
+		if vt.top >= vt.bottom || vt.bottom >= vt.rows {
+			vt.top, vt.bottom = 0, vt.rows-1
+		}
+		vt.row, vt.col = 0, 0
+	case 'P':
+		if vt.col < vt.cols {
+			n := clamp(arg(0, 1), 0, vt.cols-vt.col)
+			copy(line[vt.col:], line[vt.col+n:])
+			vt.clear(line, vt.cols-n, vt.cols)
+		}
+	case 'q':
+		if strings.HasSuffix(params, " ") {
+			n, _ := strconv.Atoi(strings.TrimSuffix(params, " "))
+			vt.cursorShape = CursorShape(n)
+		}
+	}
+}
+
+func (vt *VirtualTerminal) clear(line []rune, from, to int) {
+	for i := clamp(from, 0, len(line)); i < to && i < len(line); i++ {
+		line[i] = ' '
+	}
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
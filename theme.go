@@ -0,0 +1,155 @@
+package repl
+
+import (
+	"fmt"
+)
+
+// Theme sets the colors and other attributes a session uses for each kind of
+// text it draws. Each field holds the parameters of an SGR escape sequence,
+// such as "1;32" for bold green, or "7" for reverse video, which a Style can
+// build; an empty field leaves that kind of text in the terminal's default
+// style.
+type Theme struct {
+	Prompt     string //the prompt
+	Input      string //the line being edited
+	Output     string //anything the handler prints while evaluating
+	Result     string //the result of an evaluation
+	Error      string //an evaluation error
+	Hint       string //hints and suggestions shown beside the line
+	Completion string //the list of completion candidates
+	Match      string //the bracket matching the one just typed
+	Toolbar    string //the toolbar at the bottom of the terminal
+	Symbol     string //symbols in the line, as a Tokenizer finds them
+	String     string //string literals in the line
+	Number     string //numbers in the line
+	Comment    string //comments in the line
+}
+
+// DefaultTheme is the theme sessions use unless told otherwise: output in
+// blue, results in green, and errors in red.
+var DefaultTheme = Theme{
+	Output:  "0;34",
+	Result:  "0;32",
+	Error:   "0;31",
+	Hint:    "2",
+	Toolbar: "7",
+	String:  "0;33",
+	Number:  "0;36",
+	Comment: "2",
+}
+
+// DarkTheme suits terminals with a dark background.
+var DarkTheme = Theme{
+	Prompt:     "1;32",
+	Output:     "0;37",
+	Result:     "0;92",
+	Error:      "0;91",
+	Hint:       "0;90",
+	Completion: "0;96",
+	Match:      "7",
+	Toolbar:    "0;30;47",
+	String:     "0;93",
+	Number:     "0;96",
+	Comment:    "0;90",
+}
+
+// LightTheme suits terminals with a light background.
+var LightTheme = Theme{
+	Prompt:     "1;34",
+	Output:     "0;30",
+	Result:     "0;32",
+	Error:      "0;31",
+	Hint:       "2",
+	Completion: "0;35",
+	Match:      "7",
+	Toolbar:    "0;37;44",
+	String:     "0;33",
+	Number:     "0;36",
+	Comment:    "0;90",
+}
+
+// Themes are the built-in themes, by the names the configuration file uses
+// for them.
+var Themes = map[string]Theme{
+	"default": DefaultTheme,
+	"dark":    DarkTheme,
+	"light":   LightTheme,
+}
+
+// WithTheme sets the theme the session draws with, instead of one picked to
+// suit the terminal's background. It has no effect when color is turned off.
+func WithTheme(t Theme) Option {
+	return func(s *session) {
+		s.theme = t
+		s.autoTheme = false
+	}
+}
+
+const sgrReset = "\033[0;0m"
+
+// style returns the escape sequence that switches to the given style, or
+// nothing if the style is empty or color is off.
+func (s *session) style(sgr string) string {
+	if sgr == "" || s.noColor {
+		return ""
+	}
+	return "\033[" + s.sgr(sgr) + "m"
+}
+
+// resetStyle returns the escape sequence that returns to the default style,
+// or nothing if color is off.
+func (s *session) resetStyle() string {
+	if s.noColor {
+		return ""
+	}
+	return sgrReset
+}
+
+// appendStyled appends text to frame in the given style.
+func (s *session) appendStyled(frame []byte, sgr string, text []byte) []byte {
+	if sgr == "" || s.noColor {
+		return append(frame, text...)
+	}
+	frame = append(frame, ESCAPE, '[')
+	frame = append(frame, s.sgr(sgr)...)
+	frame = append(frame, 'm')
+	frame = append(frame, text...)
+	return append(frame, sgrReset...)
+}
+
+// appendLine appends target[from:to] to frame, where target is the prompt
+// followed by the line being edited, in the theme's prompt and input styles,
+// or the styles of the kinds of token the line has, if the session has them.
+func (s *session) appendLine(frame []byte, target []byte, from int, to int) []byte {
+	p := len(s.prompt)
+	if from < p {
+		end := to
+		if end > p {
+			end = p
+		}
+		if s.prompt == s.promptPlain {
+			frame = s.appendText(frame, s.promptText, s.theme.Prompt, from, end)
+		} else {
+			frame = s.appendStyled(frame, s.theme.Prompt, target[from:end])
+		}
+		from = end
+	}
+	for from < to {
+		kind := kindAt(s.kinds, from)
+		end := from + 1
+		for end < to && kindAt(s.kinds, end) == kind {
+			end++
+		}
+		frame = s.appendStyled(frame, s.tokenStyle(kind), target[from:end])
+		from = end
+	}
+	return frame
+}
+
+// themeByName returns the built-in theme with the given name.
+func themeByName(name string) (Theme, error) {
+	if t, ok := Themes[name]; ok {
+		return t, nil
+	}
+	return Theme{}, fmt.Errorf("unknown theme %q", name)
+}
@@ -0,0 +1,47 @@
+package repl
+
+import (
+	"time"
+)
+
+// Ticker may be implemented by a handler that has something to do now and
+// then while the user is editing, such as checking on a connection or
+// timing out work of its own. Tick is called on the session's goroutine,
+// between keystrokes, as often as WithTick says, so it may use the session's
+// other hooks without locking.
+type Ticker interface {
+	Tick()
+}
+
+// WithTick wakes the session every d while it waits for keystrokes. Each
+// time, the handler's Tick is called, if it is a Ticker, then its prompt is
+// asked for again and redrawn if it has changed, for prompts that show
+// something that changes, such as the time or the state of a job, and the
+// toolbar and spinners are brought up to date. It is off by default.
+func WithTick(d time.Duration) Option {
+	return func(s *session) {
+		s.tick = d
+	}
+}
+
+// ticked is called every tick while the session waits for keystrokes.
+func (s *session) ticked() {
+	if t, ok := s.handler.(Ticker); ok {
+		t.Tick()
+	}
+	if s.done || s.exit || s.locked {
+		return
+	}
+	//only the main prompt is asked for again, not that of an unfinished entry
+	if s.prompt != "" && len(s.partial) == 0 && s.heredocEnd == "" && len(s.pasted) == 0 {
+		if prompt := s.mainPrompt(); prompt != s.prompt {
+			s.prompt = prompt
+			s.drawline()
+		}
+	}
+	s.flush()
+	if s.toolbar != nil {
+		s.drawToolbar()
+	}
+	s.drawBelow()
+}
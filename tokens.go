@@ -0,0 +1,81 @@
+package repl
+
+// TokenKind is the kind of a token in the line being edited, which says
+// how it is colored.
+type TokenKind uint8
+
+const (
+	// TokenPlain is text drawn in the theme's Input style, as the line is
+	// without a Tokenizer.
+	TokenPlain TokenKind = iota
+	TokenSymbol
+	TokenString
+	TokenNumber
+	TokenComment
+	// TokenError is text that can't be right, such as an unterminated
+	// string, drawn in the theme's Error style.
+	TokenError
+)
+
+// Token is a token in the line being edited, from byte Start up to End.
+type Token struct {
+	Kind       TokenKind
+	Start, End int
+}
+
+// Tokenizer may be implemented by a handler that wants the line colored as
+// it is typed. Tokens divides the line into tokens, in order, leaving out
+// the text between them, such as spaces, which is plain. The session colors
+// each kind of token in the style the theme gives it, so the handler needn't
+// know anything of escape sequences. Tokens is called each time the line is
+// redrawn, so it should be quick.
+type Tokenizer interface {
+	Tokens(line string) []Token
+}
+
+// tokenStyle returns the theme's style for tokens of the given kind.
+func (s *session) tokenStyle(kind TokenKind) string {
+	var sgr string
+	switch kind {
+	case TokenSymbol:
+		sgr = s.theme.Symbol
+	case TokenString:
+		sgr = s.theme.String
+	case TokenNumber:
+		sgr = s.theme.Number
+	case TokenComment:
+		sgr = s.theme.Comment
+	case TokenError:
+		sgr = s.theme.Error
+	}
+	if sgr == "" {
+		return s.theme.Input
+	}
+	return sgr
+}
+
+// appendKinds appends the kind of each byte of the line being edited to
+// kinds, as the handler's Tokenizer divides it up, after plain kinds for the
+// prompt.
+func (s *session) appendKinds(kinds []TokenKind, line string) []TokenKind {
+	p := len(kinds) + len(s.prompt)
+	for len(kinds) < p+len(line) {
+		kinds = append(kinds, TokenPlain)
+	}
+	for _, t := range s.tokenizer.Tokens(line) {
+		start, end := clamp(t.Start, 0, len(line)), clamp(t.End, 0, len(line))
+		for i := start; i < end; i++ {
+			kinds[p+i] = t.Kind
+		}
+	}
+	return kinds
+}
+
+// kindAt returns the kind of byte i of a line whose bytes have the given
+// kinds, which are all plain if there are none.
+func kindAt(kinds []TokenKind, i int) TokenKind {
+	if i < len(kinds) {
+		return kinds[i]
+	}
+	return TokenPlain
+}
@@ -0,0 +1,113 @@
+package repl
+
+import (
+	"strconv"
+	"time"
+)
+
+// WithToolbar shows a toolbar on the bottom row of the terminal, holding the
+// text returned by fn, in the theme's Toolbar style. The text may contain SGR
+// escape sequences of its own. The toolbar is updated whenever the session
+// has drawn the line and is waiting for a key, and also every refresh, if
+// refresh is positive, for showing things that change by themselves, such as
+// the time or the state of a connection. The rest of the screen scrolls above
+// it. The toolbar is not shown in accessible mode, or when the size of the
+// terminal is not known.
+func WithToolbar(fn func() string, refresh time.Duration) Option {
+	return func(s *session) {
+		s.toolbar = fn
+		s.styledToolbar = false
+		s.toolbarRefresh = refresh
+	}
+}
+
+// startToolbar draws the toolbar for the first time, and starts refreshing
+// it if it should be.
+func (s *session) startToolbar() {
+	if s.toolbar == nil || s.accessible {
+		return
+	}
+	s.drawToolbar()
+	if s.toolbarRefresh > 0 {
+		go func() {
+			ticker := time.NewTicker(s.toolbarRefresh)
+			defer ticker.Stop()
+			for range ticker.C {
+				if !s.post(s.drawToolbar) {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// drawToolbar redraws the toolbar if its text has changed, first reserving
+// the bottom row for it if the terminal has changed size.
+func (s *session) drawToolbar() {
+	if s.toolbar == nil || s.accessible || s.rows < 2 {
+		return
+	}
+	text := s.toolbar()
+	frame := s.frame[:0]
+	if s.rows != s.toolbarRows {
+		//make sure there is a row below the cursor before taking the bottom one
+		frame = append(frame, ESCAPE, 'D', ESCAPE, 'M', ESCAPE, '7', ESCAPE, '[', '1', ';')
+		frame = strconv.AppendInt(frame, int64(s.rows-1), 10)
+		frame = append(frame, 'r')
+		s.toolbarRows = s.rows
+	} else if text == s.toolbarShown && s.cols == s.toolbarCols {
+		return
+	} else {
+		frame = append(frame, ESCAPE, '7')
+	}
+	frame = append(frame, ESCAPE, '[')
+	frame = strconv.AppendInt(frame, int64(s.rows), 10)
+	frame = append(frame, ';', '1', 'H', ESCAPE, '[', '2', 'K')
+	cols := s.cols
+	if cols <= 0 {
+		cols = 80
+	}
+	if s.styledToolbar {
+		plain := s.toolbarText.Plain()
+		frame = s.appendText(frame, s.toolbarText, s.theme.Toolbar, 0, len(truncateStyled([]byte(plain), cols-1)))
+	} else {
+		frame = s.appendStyled(frame, s.theme.Toolbar, truncateStyled([]byte(text), cols-1))
+	}
+	frame = append(frame, ESCAPE, '8')
+	s.frame = frame
+	s.putChars(frame)
+	s.toolbarShown = text
+	s.toolbarCols = s.cols
+}
+
+// removeToolbar erases the toolbar and gives the bottom row back.
+func (s *session) removeToolbar() {
+	if s.toolbarRows == 0 {
+		return
+	}
+	s.putString("\0337\033[r\033[" + strconv.Itoa(s.toolbarRows) + ";1H\033[2K\0338")
+	s.toolbarRows = 0
+}
+
+// truncateStyled cuts text, which may contain escape sequences, down to at
+// most width printable characters.
+func truncateStyled(text []byte, width int) []byte {
+	n := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == ESCAPE && i+1 < len(text) && text[i+1] == '[' {
+			i += 2
+			for i < len(text) && (text[i] < '@' || text[i] > '~') {
+				i++
+			}
+			continue
+		}
+		if text[i]&0xC0 == 0x80 {
+			continue //a UTF-8 continuation byte
+		}
+		if n == width {
+			return text[:i]
+		}
+		n++
+	}
+	return text
+}
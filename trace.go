@@ -0,0 +1,30 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// WithTrace writes a line to w for every chunk of input the session reads,
+// showing exactly the bytes the terminal sent, and for every key it decodes
+// from them, showing the key, the editing action it was taken as, and the
+// line and cursor position that resulted. This is for debugging terminals
+// that send unexpected sequences. w should be a file or some other writer,
+// never the terminal being edited.
+func WithTrace(w io.Writer) Option {
+	return func(s *session) {
+		s.trace = w
+	}
+}
+
+// traceInput notes a chunk of input read from the terminal.
+func (s *session) traceInput(chunk []byte) {
+	fmt.Fprintf(s.trace, "%s input %q\n", time.Now().Format("15:04:05.000"), chunk)
+}
+
+// traceKey notes a key once the session has acted on it.
+func (s *session) traceKey(key string, action string) {
+	line := s.buf.String()
+	fmt.Fprintf(s.trace, "%s key %-8s %-22s cursor %d %q\n", time.Now().Format("15:04:05.000"), key, action, s.buf.cursor, line)
+}
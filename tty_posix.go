@@ -0,0 +1,214 @@
+//go:build !windows
+
+package repl
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// ttyTerminal is a terminal device, read from in and written to out.
+type ttyTerminal struct {
+	in    *os.File
+	out   *os.File
+	state *termState //the state to restore, once the mode has been changed
+
+	wakeOnce sync.Once
+	wake     [2]int //the pipe that wakes Read when reads are canceled, or -1s if there isn't one
+}
+
+// Read waits for the terminal to have input, or for its reads to be canceled,
+// before reading it.
+func (t *ttyTerminal) Read(p []byte) (int, error) {
+	t.wakeOnce.Do(t.openWake)
+	if t.wake[0] < 0 {
+		return t.in.Read(p)
+	}
+	fd := int(t.in.Fd())
+	nfd := fd + 1
+	if t.wake[0] >= fd {
+		nfd = t.wake[0] + 1
+	}
+	for {
+		var set syscall.FdSet
+		fdSet(&set, fd)
+		fdSet(&set, t.wake[0])
+		err := selectRead(nfd, &set)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || fdIsSet(&set, fd) {
+			return t.in.Read(p)
+		}
+		return 0, errReadCanceled
+	}
+}
+
+// openWake opens the pipe that cancelRead writes to, to wake Read. Without
+// one, or if the terminal's descriptor is too big for select, reads can't be
+// canceled.
+func (t *ttyTerminal) openWake() {
+	t.wake = [2]int{-1, -1}
+	if int(t.in.Fd()) >= syscall.FD_SETSIZE {
+		return
+	}
+	var p [2]int
+	if syscall.Pipe(p[:]) != nil {
+		return
+	}
+	if p[0] >= syscall.FD_SETSIZE || syscall.SetNonblock(p[0], true) != nil {
+		syscall.Close(p[0])
+		syscall.Close(p[1])
+		return
+	}
+	syscall.CloseOnExec(p[0])
+	syscall.CloseOnExec(p[1])
+	t.wake = p
+}
+
+// cancelRead makes the wake pipe readable, to wake Read, or empties it.
+func (t *ttyTerminal) cancelRead(cancel bool) {
+	t.wakeOnce.Do(t.openWake)
+	if t.wake[0] < 0 {
+		return
+	}
+	if cancel {
+		syscall.Write(t.wake[1], []byte{0})
+		return
+	}
+	var b [16]byte
+	for {
+		if n, _ := syscall.Read(t.wake[0], b[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+// fdSet adds fd to set.
+func fdSet(set *syscall.FdSet, fd int) {
+	n := int(unsafe.Sizeof(set.Bits[0])) * 8
+	set.Bits[fd/n] |= 1 << (uint(fd) % uint(n))
+}
+
+// fdIsSet reports whether fd is in set.
+func fdIsSet(set *syscall.FdSet, fd int) bool {
+	n := int(unsafe.Sizeof(set.Bits[0])) * 8
+	return set.Bits[fd/n]&(1<<(uint(fd)%uint(n))) != 0
+}
+
+func (t *ttyTerminal) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func (t *ttyTerminal) Size() (int, int) {
+	var ws struct {
+		rows, cols, xpixel, ypixel uint16
+	}
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, t.out.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws))); err != 0 {
+		return 0, 0
+	}
+	return int(ws.cols), int(ws.rows)
+}
+
+func (t *ttyTerminal) watchSize(changed func()) func() {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-sig:
+				changed()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
+
+func (t *ttyTerminal) SetMode(mode TerminalMode) error {
+	fd := int(t.in.Fd())
+	var err error
+	if t.state != nil {
+		err = Restore(fd, t.state)
+		if mode == ModeNormal {
+			t.state = nil
+		}
+	}
+	if err != nil || mode == ModeNormal {
+		return err
+	}
+	var old *termState
+	if mode == ModeRaw {
+		old, err = MakeRaw(fd)
+	} else {
+		old, err = MakeCbreak(fd)
+	}
+	if err == nil && t.state == nil {
+		t.state = old
+	}
+	return err
+}
+
+// State contains the state of a terminal.
+type termState struct {
+	termios syscall.Termios
+}
+
+// MakeRaw put the terminal connected to the given file descriptor into raw
+// mode and returns the previous state of the terminal so that it can be
+// restored.
+func MakeRaw(fd int) (*termState, error) {
+	var oldState termState
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(getTermios), uintptr(unsafe.Pointer(&oldState.termios)), 0, 0, 0); err != 0 {
+		return nil, err
+	}
+
+	newState := oldState.termios
+	newState.Iflag &^= syscall.ISTRIP | syscall.INLCR | syscall.ICRNL | syscall.IGNCR | syscall.IXON | syscall.IXOFF
+	newState.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&newState)), 0, 0, 0); err != 0 {
+		return nil, err
+	}
+
+	return &oldState, nil
+}
+
+func MakeCbreak(fd int) (*termState, error) {
+	var oldState termState
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(getTermios), uintptr(unsafe.Pointer(&oldState.termios)), 0, 0, 0); err != 0 {
+		return nil, err
+	}
+
+	newState := oldState.termios
+	newState.Iflag &^= syscall.ISTRIP | syscall.INLCR | syscall.ICRNL | syscall.IGNCR | syscall.IXON | syscall.IXOFF
+	newState.Lflag &^= syscall.ECHO | syscall.ICANON
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&newState)), 0, 0, 0); err != 0 {
+		return nil, err
+	}
+
+	return &oldState, nil
+}
+
+// isTerminal reports whether the given file descriptor is a terminal.
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+	_, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(getTermios), uintptr(unsafe.Pointer(&termios)), 0, 0, 0)
+	return err == 0
+}
+
+// Restore restores the terminal connected to the given file descriptor to a
+// previous state.
+func Restore(fd int, state *termState) error {
+	if _, _, err := syscall.Syscall6(syscall.SYS_IOCTL, uintptr(fd), uintptr(setTermios), uintptr(unsafe.Pointer(&state.termios)), 0, 0, 0); err != 0 {
+		return err
+	}
+	return nil
+}
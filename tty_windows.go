@@ -0,0 +1,747 @@
+//go:build windows
+
+package repl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procReadConsoleInput           = kernel32.NewProc("ReadConsoleInputW")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+	procSetConsoleCursorPosition   = kernel32.NewProc("SetConsoleCursorPosition")
+	procSetConsoleTextAttribute    = kernel32.NewProc("SetConsoleTextAttribute")
+	procFillConsoleOutputCharacter = kernel32.NewProc("FillConsoleOutputCharacterW")
+	procFillConsoleOutputAttribute = kernel32.NewProc("FillConsoleOutputAttribute")
+	procCreateEvent                = kernel32.NewProc("CreateEventW")
+	procSetEvent                   = kernel32.NewProc("SetEvent")
+	procResetEvent                 = kernel32.NewProc("ResetEvent")
+	procWaitForMultipleObjects     = kernel32.NewProc("WaitForMultipleObjects")
+	procGetFileInformationByHandle = kernel32.NewProc("GetFileInformationByHandleEx")
+)
+
+// The console modes, from the Windows SDK.
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableWindowInput               = 0x0008
+	enableVirtualTerminalInput      = 0x0200
+	enableProcessedOutput           = 0x0001
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+// The types of console input events.
+const (
+	keyEvent              = 0x0001
+	windowBufferSizeEvent = 0x0004
+)
+
+// The modifier flags of a key event.
+const (
+	rightAltPressed  = 0x0001
+	leftAltPressed   = 0x0002
+	rightCtrlPressed = 0x0004
+	leftCtrlPressed  = 0x0008
+	shiftPressed     = 0x0010
+)
+
+type coord struct {
+	x, y int16
+}
+
+// packed returns c as it is passed by value to the console functions.
+func (c coord) packed() uintptr {
+	return uintptr(uint16(c.x)) | uintptr(uint16(c.y))<<16
+}
+
+type smallRect struct {
+	left, top, right, bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	size          coord
+	cursor        coord
+	attributes    uint16
+	window        smallRect
+	maxWindowSize coord
+}
+
+type inputRecord struct {
+	eventType uint16
+	_         uint16
+	event     [4]uint32
+}
+
+type keyEventRecord struct {
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	unicodeChar     uint16
+	controlKeyState uint32
+}
+
+// ttyTerminal is a Windows console, read from in and written to out. Consoles
+// of Windows 10 and later process escape sequences themselves, once asked to;
+// for older ones, Write translates those the session writes into calls to the
+// console functions, and Read turns the keys that have no character into the
+// escape sequences a terminal would send for them.
+type ttyTerminal struct {
+	in    *os.File
+	out   *os.File
+	state *termState //the input mode to restore, once the mode has been changed
+
+	outMode  uint32 //the output mode to restore
+	outSaved bool
+	vtInput  bool           //whether the console sends escape sequences for keys itself
+	legacy   *legacyConsole //the translator of escape sequences, for a console that can't process them
+	pending  []byte         //input decoded from key events and not yet returned by Read
+	high     uint16         //the first half of a surrogate pair
+
+	wakeOnce sync.Once
+	wake     syscall.Handle //the event that wakes Read when reads are canceled, or 0 if there isn't one
+
+	mu      sync.Mutex
+	resized func() //tells the session the size has changed
+
+	cygwin    bool   //whether the terminal is a Cygwin or MSYS pty, as under mintty
+	sttyState string //the settings to restore the pty to, from stty -g
+}
+
+func (t *ttyTerminal) SetMode(mode TerminalMode) error {
+	fd := int(t.in.Fd())
+	var in uint32
+	if t.cygwin || syscall.GetConsoleMode(syscall.Handle(fd), &in) != nil && isCygwinPTY(syscall.Handle(fd)) {
+		t.cygwin = true
+		return t.setPTYMode(mode)
+	}
+	var err error
+	if t.state != nil {
+		err = Restore(fd, t.state)
+		if mode == ModeNormal {
+			t.state = nil
+		}
+	}
+	if mode == ModeNormal {
+		if t.outSaved {
+			if t.legacy != nil {
+				t.legacy.reset()
+			}
+			setConsoleMode(syscall.Handle(t.out.Fd()), t.outMode)
+			t.outSaved = false
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	var old *termState
+	if mode == ModeRaw {
+		old, err = MakeRaw(fd)
+	} else {
+		old, err = MakeCbreak(fd)
+	}
+	if err != nil {
+		return err
+	}
+	if t.state == nil {
+		t.state = old
+	}
+	syscall.GetConsoleMode(syscall.Handle(fd), &in)
+	t.vtInput = in&enableVirtualTerminalInput != 0
+	if !t.outSaved {
+		t.enableOutput()
+	}
+	return nil
+}
+
+// enableOutput asks the console to process escape sequences, falling back to
+// translating them if it can't.
+func (t *ttyTerminal) enableOutput() {
+	h := syscall.Handle(t.out.Fd())
+	if syscall.GetConsoleMode(h, &t.outMode) != nil {
+		return
+	}
+	t.outSaved = true
+	t.legacy = nil
+	if setConsoleMode(h, t.outMode|enableProcessedOutput|enableVirtualTerminalProcessing) != nil {
+		setConsoleMode(h, t.outMode|enableProcessedOutput)
+		t.legacy = newLegacyConsole(t.out)
+	}
+}
+
+func (t *ttyTerminal) Write(p []byte) (int, error) {
+	if t.legacy != nil {
+		return t.legacy.Write(p)
+	}
+	return t.out.Write(p)
+}
+
+func (t *ttyTerminal) Size() (int, int) {
+	if t.cygwin {
+		var rows, cols int
+		out, err := t.stty("size")
+		if err != nil {
+			return 0, 0
+		}
+		fmt.Sscan(out, &rows, &cols)
+		return cols, rows
+	}
+	info, ok := screenBufferInfo(syscall.Handle(t.out.Fd()))
+	if !ok {
+		return 0, 0
+	}
+	return int(info.window.right-info.window.left) + 1, int(info.window.bottom-info.window.top) + 1
+}
+
+// Read returns the characters typed, and the escape sequences for other keys,
+// from the key events of the console, waiting for them to come or for reads
+// to be canceled.
+func (t *ttyTerminal) Read(p []byte) (int, error) {
+	h := syscall.Handle(t.in.Fd())
+	var mode uint32
+	if syscall.GetConsoleMode(h, &mode) != nil {
+		return t.in.Read(p)
+	}
+	t.wakeOnce.Do(t.openWake)
+	for len(t.pending) == 0 {
+		if t.wake != 0 {
+			handles := [2]syscall.Handle{h, t.wake}
+			r, _, err := procWaitForMultipleObjects.Call(2, uintptr(unsafe.Pointer(&handles[0])), 0, syscall.INFINITE)
+			if r == syscall.WAIT_FAILED {
+				return 0, err
+			}
+			if r == syscall.WAIT_OBJECT_0+1 {
+				return 0, errReadCanceled
+			}
+		}
+		var records [16]inputRecord
+		var n uint32
+		if r, _, err := procReadConsoleInput.Call(uintptr(h), uintptr(unsafe.Pointer(&records[0])), uintptr(len(records)), uintptr(unsafe.Pointer(&n))); r == 0 {
+			return 0, err
+		}
+		for _, rec := range records[:n] {
+			t.event(&rec)
+		}
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// watchSize has changed called when the console reports that its size has
+// changed, as it does for a window resized under ConPTY, in place of the
+// SIGWINCH of POSIX systems.
+func (t *ttyTerminal) watchSize(changed func()) func() {
+	if t.cygwin {
+		return t.pollSize(changed)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.resized = changed
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.resized = nil
+	}
+}
+
+// event adds the input for a console input event to t.pending, or reports a
+// change of size.
+func (t *ttyTerminal) event(rec *inputRecord) {
+	if rec.eventType == windowBufferSizeEvent {
+		t.mu.Lock()
+		resized := t.resized
+		t.mu.Unlock()
+		if resized != nil {
+			go resized() //not holding up the input while the session takes it
+		}
+		return
+	}
+	if rec.eventType != keyEvent {
+		return
+	}
+	ke := (*keyEventRecord)(unsafe.Pointer(&rec.event[0]))
+	if ke.keyDown == 0 {
+		return
+	}
+	var b []byte
+	switch ch := ke.unicodeChar; {
+	case utf16.IsSurrogate(rune(ch)) && ch < 0xdc00:
+		t.high = ch
+		return
+	case utf16.IsSurrogate(rune(ch)):
+		b = utf8.AppendRune(nil, utf16.DecodeRune(rune(t.high), rune(ch)))
+		t.high = 0
+	case ch == BACKSPACE && !t.vtInput:
+		b = []byte{DELETE}
+	case ch != 0:
+		b = utf8.AppendRune(nil, rune(ch))
+	case !t.vtInput:
+		b = keySequence(ke.virtualKeyCode, ke.controlKeyState)
+	}
+	alt := ke.controlKeyState&(leftAltPressed|rightAltPressed) != 0
+	altGr := alt && ke.controlKeyState&(leftCtrlPressed|rightCtrlPressed) != 0 && ke.unicodeChar != 0
+	if len(b) > 0 && !t.vtInput && alt && !altGr && b[0] != ESCAPE {
+		b = append([]byte{ESCAPE}, b...) //Meta, as a terminal sends it
+	}
+	for i := 0; i < int(ke.repeatCount) || i == 0; i++ {
+		t.pending = append(t.pending, b...)
+	}
+}
+
+// keySequences are the escape sequences xterm sends for keys that have no
+// character, by virtual key code, with their modifiers shown by a parameter
+// put before the final byte.
+var keySequences = map[uint16]string{
+	0x21: "\033[5~", //page up
+	0x22: "\033[6~", //page down
+	0x23: "\033[F",  //end
+	0x24: "\033[H",  //home
+	0x25: "\033[D",  //left
+	0x26: "\033[A",  //up
+	0x27: "\033[C",  //right
+	0x28: "\033[B",  //down
+	0x2d: "\033[2~", //insert
+	0x2e: "\033[3~", //delete
+	0x70: "\033OP",  //F1
+	0x71: "\033OQ",
+	0x72: "\033OR",
+	0x73: "\033OS",
+	0x74: "\033[15~", //F5
+	0x75: "\033[17~",
+	0x76: "\033[18~",
+	0x77: "\033[19~",
+	0x78: "\033[20~",
+	0x79: "\033[21~",
+	0x7a: "\033[23~",
+	0x7b: "\033[24~", //F12
+}
+
+// keySequence returns the escape sequence for the key with virtual key code
+// vk, pressed with the modifiers in state.
+func keySequence(vk uint16, state uint32) []byte {
+	seq, ok := keySequences[vk]
+	if !ok {
+		return nil
+	}
+	mod := 1
+	if state&shiftPressed != 0 {
+		mod++
+	}
+	if state&(leftAltPressed|rightAltPressed) != 0 {
+		mod += 2
+	}
+	if state&(leftCtrlPressed|rightCtrlPressed) != 0 {
+		mod += 4
+	}
+	if mod == 1 {
+		return []byte(seq)
+	}
+	final := seq[len(seq)-1]
+	params := seq[2 : len(seq)-1]
+	if params == "" || seq[1] == 'O' {
+		params = "1"
+	}
+	return []byte("\033[" + params + ";" + strconv.Itoa(mod) + string(final))
+}
+
+// openWake creates the event that cancelRead signals, to wake Read.
+func (t *ttyTerminal) openWake() {
+	r, _, _ := procCreateEvent.Call(0, 1, 0, 0)
+	t.wake = syscall.Handle(r)
+}
+
+// cancelRead signals the wake event, to wake Read, or resets it.
+func (t *ttyTerminal) cancelRead(cancel bool) {
+	t.wakeOnce.Do(t.openWake)
+	if t.wake == 0 {
+		return
+	}
+	if cancel {
+		procSetEvent.Call(uintptr(t.wake))
+	} else {
+		procResetEvent.Call(uintptr(t.wake))
+	}
+}
+
+// isCygwinPTY reports whether h is one end of a Cygwin or MSYS pty, which
+// native programs see as a named pipe, with a name such as
+// \msys-1888ae32e00d56aa-pty0-from-master.
+func isCygwinPTY(h syscall.Handle) bool {
+	if ft, err := syscall.GetFileType(h); err != nil || ft != syscall.FILE_TYPE_PIPE {
+		return false
+	}
+	var buf [2 + syscall.MAX_PATH]uint16 //a FILE_NAME_INFO: the length of the name, as a DWORD, and the name
+	const fileNameInfo = 2
+	if r, _, _ := procGetFileInformationByHandle.Call(uintptr(h), fileNameInfo, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)*2)); r == 0 {
+		return false
+	}
+	n := int(*(*uint32)(unsafe.Pointer(&buf[0]))) / 2
+	if n > len(buf)-2 {
+		return false
+	}
+	parts := strings.Split(string(utf16.Decode(buf[2:2+n])), "-")
+	if len(parts) < 5 || parts[0] != "\\msys" && parts[0] != "\\cygwin" {
+		return false
+	}
+	return strings.HasPrefix(parts[2], "pty") && (parts[3] == "from" || parts[3] == "to") && parts[4] == "master"
+}
+
+// setPTYMode sets the mode of a Cygwin or MSYS pty, whose settings belong to
+// the Cygwin runtime rather than the console, with the stty that comes with
+// it.
+func (t *ttyTerminal) setPTYMode(mode TerminalMode) error {
+	if mode == ModeNormal {
+		if t.sttyState == "" {
+			return nil
+		}
+		_, err := t.stty(t.sttyState)
+		t.sttyState = ""
+		return err
+	}
+	if t.sttyState == "" {
+		state, err := t.stty("-g")
+		if err != nil {
+			return err
+		}
+		t.sttyState = strings.TrimSpace(state)
+	}
+	args := []string{"-istrip", "-inlcr", "-icrnl", "-igncr", "-ixon", "-ixoff", "-echo", "-icanon", "min", "1", "time", "0"}
+	if mode == ModeRaw {
+		args = append(args, "-isig")
+	} else {
+		args = append(args, "isig")
+	}
+	_, err := t.stty(args...)
+	return err
+}
+
+// stty runs stty on the pty, and returns what it prints.
+func (t *ttyTerminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.in
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// ptyPollInterval is how often the size of a Cygwin or MSYS pty is checked,
+// as nothing tells a native program when it changes.
+const ptyPollInterval = time.Second
+
+// pollSize has changed called whenever the size of a Cygwin or MSYS pty is
+// seen to have changed, until the function it returns is called.
+func (t *ttyTerminal) pollSize(changed func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ptyPollInterval)
+		defer ticker.Stop()
+		cols, rows := t.Size()
+		for {
+			select {
+			case <-ticker.C:
+				if c, r := t.Size(); c != cols || r != rows {
+					cols, rows = c, r
+					changed()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// termState is the mode of a console's input.
+type termState struct {
+	mode uint32
+}
+
+// MakeRaw puts the console input with the given handle into raw mode, with
+// keys sent as escape sequences where the console can, and returns its
+// previous state so that it can be restored.
+func MakeRaw(fd int) (*termState, error) {
+	return makeInputMode(fd, enableProcessedInput|enableLineInput|enableEchoInput)
+}
+
+// MakeCbreak is like MakeRaw, but leaves Ctrl-C to the system.
+func MakeCbreak(fd int) (*termState, error) {
+	return makeInputMode(fd, enableLineInput|enableEchoInput)
+}
+
+// makeInputMode turns off the given input modes, and turns on the events for
+// changes of size and virtual terminal input, if the console has it.
+func makeInputMode(fd int, off uint32) (*termState, error) {
+	h := syscall.Handle(fd)
+	var old termState
+	if err := syscall.GetConsoleMode(h, &old.mode); err != nil {
+		return nil, err
+	}
+	mode := old.mode&^off | enableWindowInput
+	if setConsoleMode(h, mode|enableVirtualTerminalInput) != nil {
+		if err := setConsoleMode(h, mode); err != nil {
+			return nil, err
+		}
+	}
+	return &old, nil
+}
+
+// isTerminal reports whether the given handle is a console, or a Cygwin or
+// MSYS pty.
+func isTerminal(fd int) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil || isCygwinPTY(syscall.Handle(fd))
+}
+
+// Restore restores the console input with the given handle to a previous
+// state.
+func Restore(fd int, state *termState) error {
+	return setConsoleMode(syscall.Handle(fd), state.mode)
+}
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+func screenBufferInfo(h syscall.Handle) (consoleScreenBufferInfo, bool) {
+	var info consoleScreenBufferInfo
+	r, _, _ := procGetConsoleScreenBufferInfo.Call(uintptr(h), uintptr(unsafe.Pointer(&info)))
+	return info, r != 0
+}
+
+// legacyConsole writes to a console that doesn't process escape sequences,
+// carrying out those that sessions write with the console functions: cursor
+// movement, erasing, colors, and saving and restoring the cursor. Others are
+// left out.
+type legacyConsole struct {
+	out     *os.File
+	h       syscall.Handle
+	escape  []byte //an incomplete escape sequence
+	saved   coord  //the cursor position saved by ESC 7
+	initial uint16 //the attributes the console started with, restored by SGR 0
+}
+
+func newLegacyConsole(out *os.File) *legacyConsole {
+	lc := &legacyConsole{out: out, h: syscall.Handle(out.Fd())}
+	if info, ok := screenBufferInfo(lc.h); ok {
+		lc.initial = info.attributes
+	} else {
+		lc.initial = 0x07
+	}
+	return lc
+}
+
+func (lc *legacyConsole) Write(p []byte) (int, error) {
+	start := 0
+	for i, b := range p {
+		if len(lc.escape) == 0 {
+			if b != ESCAPE {
+				continue
+			}
+			if _, err := lc.out.Write(p[start:i]); err != nil {
+				return 0, err
+			}
+		}
+		lc.escape = append(lc.escape, b)
+		start = i + 1
+		if done, ok := lc.sequence(); done {
+			if ok {
+				lc.do()
+			}
+			lc.escape = lc.escape[:0]
+		}
+	}
+	if len(lc.escape) == 0 && start < len(p) {
+		if _, err := lc.out.Write(p[start:]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// sequence reports whether the escape sequence being collected is done, and
+// whether it is one to act on rather than drop.
+func (lc *legacyConsole) sequence() (done bool, ok bool) {
+	seq := lc.escape
+	if len(seq) < 2 {
+		return false, false
+	}
+	if len(seq) > 64 {
+		return true, false
+	}
+	switch seq[1] {
+	case '[':
+		final := seq[len(seq)-1]
+		return len(seq) > 2 && final >= '@' && final <= '~', true
+	case ']':
+		end := seq[len(seq)-1]
+		return end == BEEP || end == '\\' && seq[len(seq)-2] == ESCAPE, false
+	}
+	return true, true
+}
+
+// do carries out the escape sequence collected.
+func (lc *legacyConsole) do() {
+	info, ok := screenBufferInfo(lc.h)
+	if !ok {
+		return
+	}
+	seq := lc.escape
+	switch seq[1] {
+	case '7':
+		lc.saved = info.cursor
+	case '8':
+		lc.moveTo(lc.saved)
+	case '[':
+		lc.csi(string(seq[2:len(seq)-1]), seq[len(seq)-1], info)
+	}
+}
+
+func (lc *legacyConsole) csi(params string, final byte, info consoleScreenBufferInfo) {
+	if strings.HasPrefix(params, "?") {
+		return
+	}
+	var args []int
+	for _, p := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(p)
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+	win := info.window
+	pos := info.cursor
+	x, y := int(pos.x), int(pos.y)
+	cols := int(info.size.x)
+	switch final {
+	case 'A':
+		y = clamp(y-arg(0, 1), int(win.top), int(win.bottom))
+	case 'B':
+		y = clamp(y+arg(0, 1), int(win.top), int(win.bottom))
+	case 'C':
+		x = clamp(x+arg(0, 1), 0, cols-1)
+	case 'D':
+		x = clamp(x-arg(0, 1), 0, cols-1)
+	case 'G':
+		x = clamp(arg(0, 1)-1, 0, cols-1)
+	case 'H', 'f':
+		y = clamp(int(win.top)+arg(0, 1)-1, int(win.top), int(win.bottom))
+		x = clamp(arg(1, 1)-1, 0, cols-1)
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			lc.fill(pos, cols-x, info.attributes)
+		case 1:
+			lc.fill(coord{0, pos.y}, x+1, info.attributes)
+		case 2:
+			lc.fill(coord{0, pos.y}, cols, info.attributes)
+		}
+		return
+	case 'J':
+		top, bottom := int(win.top), int(win.bottom)
+		switch arg(0, 0) {
+		case 0:
+			lc.fill(pos, cols-x+cols*(bottom-y), info.attributes)
+		case 1:
+			lc.fill(coord{0, int16(top)}, cols*(y-top)+x+1, info.attributes)
+		case 2, 3:
+			lc.fill(coord{0, int16(top)}, cols*(bottom-top+1), info.attributes)
+		}
+		return
+	case 'm':
+		lc.sgr(args, info.attributes)
+		return
+	default:
+		return
+	}
+	lc.moveTo(coord{int16(x), int16(y)})
+}
+
+func (lc *legacyConsole) moveTo(c coord) {
+	procSetConsoleCursorPosition.Call(uintptr(lc.h), c.packed())
+}
+
+// fill blanks n cells from c, in the given attributes.
+func (lc *legacyConsole) fill(c coord, n int, attributes uint16) {
+	if n <= 0 {
+		return
+	}
+	var written uint32
+	procFillConsoleOutputCharacter.Call(uintptr(lc.h), uintptr(SPACE), uintptr(n), c.packed(), uintptr(unsafe.Pointer(&written)))
+	procFillConsoleOutputAttribute.Call(uintptr(lc.h), uintptr(attributes), uintptr(n), c.packed(), uintptr(unsafe.Pointer(&written)))
+}
+
+// The color bits of console attributes.
+const (
+	foregroundIntensity = 0x08
+	backgroundIntensity = 0x80
+)
+
+// consoleColor returns the console's color bits for ANSI color i, which has
+// red and blue the other way round.
+func consoleColor(i int) uint16 {
+	return uint16(i&1)<<2 | uint16(i&2) | uint16(i&4)>>2
+}
+
+// sgr sets the console's attributes from those given by the parameters of
+// an SGR sequence, starting from attr.
+func (lc *legacyConsole) sgr(args []int, attr uint16) {
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == 0:
+			attr = lc.initial
+		case a == 1:
+			attr |= foregroundIntensity
+		case a == 22:
+			attr &^= foregroundIntensity
+		case a == 7:
+			attr = attr&^0xff | (attr&0x0f)<<4 | (attr&0xf0)>>4
+		case a >= 30 && a <= 37:
+			attr = attr&^0x07 | consoleColor(a-30)
+		case a == 39:
+			attr = attr&^0x0f | lc.initial&0x0f
+		case a >= 40 && a <= 47:
+			attr = attr&^0x70 | consoleColor(a-40)<<4
+		case a == 49:
+			attr = attr&^0xf0 | lc.initial&0xf0
+		case a >= 90 && a <= 97:
+			attr = attr&^0x0f | consoleColor(a-90) | foregroundIntensity
+		case a >= 100 && a <= 107:
+			attr = attr&^0xf0 | consoleColor(a-100)<<4 | backgroundIntensity
+		case a == 38 || a == 48:
+			//256 colors and truecolor can't be shown, and are skipped
+			if i+1 < len(args) && args[i+1] == 5 {
+				i += 2
+			} else if i+1 < len(args) && args[i+1] == 2 {
+				i += 4
+			}
+		}
+	}
+	procSetConsoleTextAttribute.Call(uintptr(lc.h), uintptr(attr))
+}
+
+// reset puts the console's attributes back as they were.
+func (lc *legacyConsole) reset() {
+	procSetConsoleTextAttribute.Call(uintptr(lc.h), uintptr(lc.initial))
+}
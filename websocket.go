@@ -0,0 +1,277 @@
+package repl
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsContinuation = 0
+	wsText         = 1
+	wsBinary       = 2
+	wsClose        = 8
+	wsPing         = 9
+	wsPong         = 10
+)
+
+// maxWebSocketMessage bounds the size of a single message from the browser.
+const maxWebSocketMessage = 1 << 20
+
+// WebSocketHandler returns an http.Handler that upgrades each request to a
+// WebSocket and runs a REPL session over it, with a fresh handler from
+// newHandler, for browser consoles built on a terminal emulator such as
+// xterm.js.
+//
+// The browser sends text messages holding JSON objects, either
+//
+//	{"type": "input", "data": "typed characters"}
+//	{"type": "resize", "cols": 80, "rows": 24}
+//
+// and receives the session's output as binary messages containing the raw
+// terminal byte stream, ready to be passed to the emulator's write method.
+//
+// Requests from web pages on other origins than the server's own are refused,
+// unless WithAllowedOrigins allows them, so that a page the user happens to
+// visit can't open a session on a console served on localhost.
+func WebSocketHandler(newHandler HandlerFactory, options ...Option) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws := &webSocket{}
+		s := newSession(nil, crlfWriter{ws}, options...)
+		if !originAllowed(r, s.origins) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		if err := ws.upgrade(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer ws.conn.Close()
+		ws.resized = func(cols, rows int) {
+			s.post(func() { s.resize(cols, rows) })
+		}
+		go s.feed(ws)
+		creds := Credentials{RemoteAddr: r.RemoteAddr, Token: bearerToken(r)}
+		if !s.authenticate(&creds, true) {
+			close(s.stopped)
+			ws.writeFrame(wsClose, nil)
+			return
+		}
+		s.handler = newHandler()
+		s.isolate(creds, "")
+		s.run()
+		ws.writeFrame(wsClose, nil)
+	})
+}
+
+// webSocket is the server end of a WebSocket connection carrying a terminal.
+type webSocket struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	mu      sync.Mutex
+	pending []byte
+	resized func(cols, rows int)
+}
+
+// upgrade takes the connection over from the HTTP server, answering the
+// request for a WebSocket.
+func (ws *webSocket) upgrade(w http.ResponseWriter, r *http.Request) error {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return errors.New("Not a WebSocket request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return errors.New("Missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("Connection cannot be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+	ws.conn, ws.r = conn, rw.Reader
+	return nil
+}
+
+// WithAllowedOrigins lets web pages from the given origins, such as
+// "https://console.example.com", open WebSocketHandler sessions, as well as
+// pages served from the server's own host. An origin of "*" allows any page.
+// Requests without an Origin header, which browsers always send, are allowed
+// whatever the origins.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(s *session) {
+		s.origins = append(s.origins, origins...)
+	}
+}
+
+// originAllowed reports whether the page that sent r, if any, may open a
+// WebSocket session: one from the same host, or from one of allowed.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true //not sent by a browser
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns the characters typed in the browser, handling resize and
+// control messages along the way.
+func (ws *webSocket) Read(p []byte) (int, error) {
+	for len(ws.pending) == 0 {
+		msg, err := ws.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		var m struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+			Cols int    `json:"cols"`
+			Rows int    `json:"rows"`
+		}
+		if json.Unmarshal(msg, &m) != nil {
+			continue
+		}
+		switch m.Type {
+		case "input":
+			ws.pending = []byte(m.Data)
+		case "resize":
+			if ws.resized != nil {
+				ws.resized(m.Cols, m.Rows)
+			}
+		}
+	}
+	n := copy(p, ws.pending)
+	ws.pending = ws.pending[n:]
+	return n, nil
+}
+
+// Write sends p to the browser as a single binary message.
+func (ws *webSocket) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readMessage returns the next complete data message, answering pings and
+// reassembling fragmented messages.
+func (ws *webSocket) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			ws.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			ws.writeFrame(wsClose, payload)
+			return nil, io.EOF
+		case wsText, wsBinary, wsContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxWebSocketMessage {
+				return nil, errors.New("WebSocket message too large")
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, errors.New("Unknown WebSocket opcode")
+		}
+	}
+}
+
+func (ws *webSocket) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebSocketMessage {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (ws *webSocket) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode}
+	n := len(payload)
+	switch {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		frame = append(frame, 127)
+		frame = append(frame, ext[:]...)
+	}
+	frame = append(frame, payload...)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err := ws.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,60 @@
+package repl
+
+import (
+	"unicode/utf8"
+)
+
+// WordModel says what the word commands, such as M-f and M-d, take a word to
+// be.
+type WordModel int
+
+const (
+	// WordsWhitespace takes a word to be anything between spaces, or
+	// opening brackets and quotes, which suits Lisp and shell-like languages.
+	WordsWhitespace WordModel = iota
+	// WordsReadline takes a word to be a run of letters and digits, as
+	// readline does, so that punctuation separates words too. M-f and M-d go
+	// to the end of the next word, and M-b and M-DEL to the start of the
+	// word before.
+	WordsReadline
+)
+
+// WithWordModel sets what the word commands take a word to be. The default
+// is WordsWhitespace.
+func WithWordModel(m WordModel) Option {
+	return func(s *session) {
+		s.wordModel = m
+	}
+}
+
+// isWordChar reports whether ch is part of a word, as readline sees it: a
+// letter or digit, or part of a character that isn't ASCII.
+func isWordChar(ch byte) bool {
+	return ch >= utf8.RuneSelf || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// nextWordEnd returns the position of the end of the word at or after the
+// cursor, in readline's word model.
+func (lb *lineBuf) nextWordEnd() int {
+	i := lb.cursor
+	for i < lb.length && !isWordChar(lb.at(i)) {
+		i++
+	}
+	for i < lb.length && isWordChar(lb.at(i)) {
+		i++
+	}
+	return i
+}
+
+// previousWordStart returns the position of the start of the word before the
+// cursor, in readline's word model.
+func (lb *lineBuf) previousWordStart() int {
+	i := lb.cursor
+	for i > 0 && !isWordChar(lb.at(i-1)) {
+		i--
+	}
+	for i > 0 && isWordChar(lb.at(i-1)) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,156 @@
+package repl
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Wrap runs cmd as a child process and gives it the line editing, history and
+// completion of the REPL, in the manner of rlwrap. Each line the user enters
+// is written to the child's standard input, and whatever the child writes to
+// its standard output and error is relayed to the terminal above the line
+// being edited. The last, unterminated line of the child's output is used as
+// the prompt. Completion offers words seen so far in the child's output and in
+// the user's input.
+//
+// Wrap returns when the child exits, or when the user ends input with Ctrl-D
+// (which closes the child's standard input) and the child then exits.
+func Wrap(cmd *exec.Cmd, options ...Option) error {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return err
+	}
+	ph := &processHandler{cmd: cmd, stdin: stdin, words: make(map[string]bool)}
+	console = newSession(ph, os.Stdout, userOptions(options)...)
+	ph.session = console
+	relayed := make(chan struct{})
+	go func() {
+		ph.relay(r)
+		close(relayed)
+	}()
+	err = runConsole(console)
+	<-relayed
+	r.Close()
+	if werr := cmd.Wait(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// processHandler is the ReplHandler for a wrapped child process.
+type processHandler struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	session *session
+	partial string
+	words   map[string]bool
+}
+
+// relay copies the child's output to the session until the child closes it,
+// then ends the session. Output that arrives after the session has finished
+// is written straight through.
+func (ph *processHandler) relay(r io.Reader) {
+	var data [4096]byte
+	s := ph.session
+	for {
+		n, err := r.Read(data[:])
+		if n > 0 {
+			text := string(data[:n])
+			if !s.post(func() { ph.output(text) }) {
+				s.putString(text)
+			}
+		}
+		if err != nil {
+			s.post(func() { s.done = true })
+			return
+		}
+	}
+}
+
+// output shows text from the child, keeping any unterminated last line as
+// the prompt.
+func (ph *processHandler) output(text string) {
+	text = ph.partial + text
+	i := strings.LastIndex(text, "\n")
+	ph.partial = text[i+1:]
+	ph.learn(text[:i+1])
+	ph.session.prompt = ph.partial
+	ph.session.printAbove(text[:i+1])
+}
+
+func isWrappedWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-./:", r)
+}
+
+// learn remembers the words in text as completion candidates.
+func (ph *processHandler) learn(text string) {
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isWrappedWordChar(r) }) {
+		if len(word) > 1 {
+			ph.words[word] = true
+		}
+	}
+}
+
+func (ph *processHandler) Eval(expr string) (string, bool, error) {
+	ph.learn(expr)
+	ph.partial = ""
+	_, err := io.WriteString(ph.stdin, expr+"\n")
+	return "", false, err
+}
+
+func (ph *processHandler) Complete(expr string) (string, []string) {
+	i := strings.LastIndexFunc(expr, func(r rune) bool { return !isWrappedWordChar(r) })
+	prefix := expr[i+1:]
+	if prefix == "" {
+		return "", nil
+	}
+	var matches []string
+	for word := range ph.words {
+		if strings.HasPrefix(word, prefix) {
+			matches = append(matches, word)
+		}
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+	sort.Strings(matches)
+	common := matches[0]
+	for _, word := range matches[1:] {
+		for !strings.HasPrefix(word, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	return common[len(prefix):], matches
+}
+
+func (ph *processHandler) Reset() {
+	ph.cmd.Process.Signal(os.Interrupt)
+}
+
+func (ph *processHandler) Prompt() string {
+	return ph.partial
+}
+
+func (ph *processHandler) Start() []string {
+	return nil
+}
+
+func (ph *processHandler) Stop(history []string) {
+	ph.stdin.Close()
+}